@@ -0,0 +1,170 @@
+// Package config loads the settings of the server once at startup. Settings come from, in
+// increasing order of precedence, built-in defaults, an optional YAML or TOML file, environment
+// variables and command line flags.
+package config
+
+import "time"
+
+// Config is every setting of the server. Each setting has an environment variable (the env
+// tag), a key in the config file (the yaml and toml tags, nested by section) and a command
+// line flag named after the environment variable, e.g. -jwt-access-token-expiry for
+// JWT_ACCESS_TOKEN_EXPIRY.
+type Config struct {
+	Server       Server       `yaml:"server" toml:"server"`
+	Database     Database     `yaml:"database" toml:"database"`
+	JWT          JWT          `yaml:"jwt" toml:"jwt"`
+	Mail         Mail         `yaml:"mail" toml:"mail"`
+	SMS          SMS          `yaml:"sms" toml:"sms"`
+	Links        Links        `yaml:"links" toml:"links"`
+	Auth         Auth         `yaml:"auth" toml:"auth"`
+	Password     Password     `yaml:"password" toml:"password"`
+	PasswordHash PasswordHash `yaml:"password_hash" toml:"password_hash"`
+	WebAuthn     WebAuthn     `yaml:"webauthn" toml:"webauthn"`
+	OIDC         OIDC         `yaml:"oidc" toml:"oidc"`
+	Admin        Admin        `yaml:"admin" toml:"admin"`
+	Money        Money        `yaml:"money" toml:"money"`
+}
+
+// Server is the HTTP listener. TrustedProxies are the addresses or CIDR ranges of reverse
+// proxies whose X-Forwarded-For header gives the client IP; with none the header is ignored,
+// so clients can't pick the IP their logins are throttled by.
+type Server struct {
+	Port           string   `yaml:"port" toml:"port" env:"SERVER_PORT"`
+	TrustedProxies []string `yaml:"trusted_proxies" toml:"trusted_proxies" env:"TRUSTED_PROXIES"`
+}
+
+type Database struct {
+	User     string `yaml:"user" toml:"user" env:"MYSQL_USER"`
+	Password string `yaml:"password" toml:"password" env:"MYSQL_PASSWORD"`
+	Host     string `yaml:"host" toml:"host" env:"MYSQL_HOST"`
+	Port     string `yaml:"port" toml:"port" env:"MYSQL_PORT"`
+	Name     string `yaml:"name" toml:"name" env:"MYSQL_DATABASE"`
+}
+
+// JWT configures access and refresh tokens. Bare numbers are read as minutes for the access
+// token expiry and as days for the refresh token expiry, as before durations had units.
+type JWT struct {
+	SigningAlgorithm   string   `yaml:"signing_algorithm" toml:"signing_algorithm" env:"JWT_SIGNING_ALG"`
+	AccessSecret       string   `yaml:"access_secret" toml:"access_secret" env:"JWT_ACCESS_SECRET"`
+	RefreshSecret      string   `yaml:"refresh_secret" toml:"refresh_secret" env:"JWT_REFRESH_SECRET"`
+	AccessTokenExpiry  Duration `yaml:"access_token_expiry" toml:"access_token_expiry" env:"JWT_ACCESS_TOKEN_EXPIRY" unit:"m"`
+	RefreshTokenExpiry Duration `yaml:"refresh_token_expiry" toml:"refresh_token_expiry" env:"JWT_REFRESH_TOKEN_EXPIRY" unit:"d"`
+}
+
+type Mail struct {
+	Driver       string `yaml:"driver" toml:"driver" env:"MAILER_DRIVER"`
+	FileDir      string `yaml:"file_dir" toml:"file_dir" env:"MAILER_FILE_DIR"`
+	SMTPHost     string `yaml:"smtp_host" toml:"smtp_host" env:"SMTP_HOST"`
+	SMTPPort     int    `yaml:"smtp_port" toml:"smtp_port" env:"SMTP_PORT"`
+	SMTPUsername string `yaml:"smtp_username" toml:"smtp_username" env:"SMTP_USERNAME"`
+	SMTPPassword string `yaml:"smtp_password" toml:"smtp_password" env:"SMTP_PASSWORD"`
+	From         string `yaml:"from" toml:"from" env:"MAIL_FROM"`
+}
+
+type SMS struct {
+	Driver string `yaml:"driver" toml:"driver" env:"SMS_DRIVER"`
+}
+
+// Links are the frontend pages emailed or shared tokens are appended to. When a link is not
+// set the bare token is used.
+type Links struct {
+	PasswordReset     string `yaml:"password_reset" toml:"password_reset" env:"PASSWORD_RESET_URL"`
+	AccountUnlock     string `yaml:"account_unlock" toml:"account_unlock" env:"ACCOUNT_UNLOCK_URL"`
+	EmailVerification string `yaml:"email_verification" toml:"email_verification" env:"EMAIL_VERIFICATION_URL"`
+	MagicLink         string `yaml:"magic_link" toml:"magic_link" env:"MAGIC_LINK_URL"`
+	Invitation        string `yaml:"invitation" toml:"invitation" env:"INVITATION_URL"`
+}
+
+type Auth struct {
+	LoginAttemptStore   string `yaml:"login_attempt_store" toml:"login_attempt_store" env:"LOGIN_ATTEMPT_STORE"`
+	MagicLinkBindDevice bool   `yaml:"magic_link_bind_device" toml:"magic_link_bind_device" env:"MAGIC_LINK_BIND_DEVICE"`
+}
+
+// Password is the policy new passwords must follow. BreachedList is a local breached password
+// list, a single file or a directory of range files; "none" disables the check and an empty
+// value uses the list shipped with the server if present.
+type Password struct {
+	MinLength            int    `yaml:"min_length" toml:"min_length" env:"PASSWORD_LENGTH"`
+	MaxLength            int    `yaml:"max_length" toml:"max_length" env:"PASSWORD_MAX_LENGTH"`
+	RequireUppercase     bool   `yaml:"require_uppercase" toml:"require_uppercase" env:"PASSWORD_REQUIRE_UPPERCASE"`
+	RequireLowercase     bool   `yaml:"require_lowercase" toml:"require_lowercase" env:"PASSWORD_REQUIRE_LOWERCASE"`
+	RequireDigit         bool   `yaml:"require_digit" toml:"require_digit" env:"PASSWORD_REQUIRE_DIGIT"`
+	RequireSymbol        bool   `yaml:"require_symbol" toml:"require_symbol" env:"PASSWORD_REQUIRE_SYMBOL"`
+	DisallowPersonalInfo bool   `yaml:"disallow_personal_info" toml:"disallow_personal_info" env:"PASSWORD_DISALLOW_PERSONAL_INFO"`
+	BreachedList         string `yaml:"breached_list" toml:"breached_list" env:"PASSWORD_BREACHED_LIST"`
+}
+
+type PasswordHash struct {
+	Hasher            string `yaml:"hasher" toml:"hasher" env:"PASSWORD_HASHER"`
+	Argon2Memory      uint32 `yaml:"argon2_memory" toml:"argon2_memory" env:"ARGON2_MEMORY"` // KiB
+	Argon2Iterations  uint32 `yaml:"argon2_iterations" toml:"argon2_iterations" env:"ARGON2_ITERATIONS"`
+	Argon2Parallelism uint8  `yaml:"argon2_parallelism" toml:"argon2_parallelism" env:"ARGON2_PARALLELISM"`
+	BcryptCost        int    `yaml:"bcrypt_cost" toml:"bcrypt_cost" env:"BCRYPT_COST"`
+}
+
+// WebAuthn is the relying party passkeys are registered for. Passkeys are disabled when
+// RPID is not set.
+type WebAuthn struct {
+	RPID      string   `yaml:"rp_id" toml:"rp_id" env:"WEBAUTHN_RP_ID"`
+	RPName    string   `yaml:"rp_name" toml:"rp_name" env:"WEBAUTHN_RP_NAME"`
+	RPOrigins []string `yaml:"rp_origins" toml:"rp_origins" env:"WEBAUTHN_RP_ORIGINS"`
+}
+
+// OIDC lists the OpenID Connect providers people can sign in with, by name. In the
+// environment the names are listed in OIDC_PROVIDERS, e.g. "google,mock", and each provider
+// is configured with OIDC_<NAME>_ISSUER, OIDC_<NAME>_CLIENT_ID, OIDC_<NAME>_CLIENT_SECRET,
+// OIDC_<NAME>_REDIRECT_URL and optionally OIDC_<NAME>_SCOPES.
+type OIDC struct {
+	Providers map[string]OIDCProvider `yaml:"providers" toml:"providers"`
+}
+
+// OIDCProvider is a provider registered with a client ID. The issuer must be written exactly
+// as the provider announces it, including any trailing slash.
+type OIDCProvider struct {
+	Issuer       string   `yaml:"issuer" toml:"issuer"`
+	ClientID     string   `yaml:"client_id" toml:"client_id"`
+	ClientSecret string   `yaml:"client_secret" toml:"client_secret"`
+	RedirectURL  string   `yaml:"redirect_url" toml:"redirect_url"`
+	Scopes       []string `yaml:"scopes" toml:"scopes"`
+}
+
+type Admin struct {
+	// Accounts with these verified emails are made administrators at startup
+	Emails []string `yaml:"emails" toml:"emails" env:"ADMIN_EMAILS"`
+}
+
+type Money struct {
+	// Currency of new events that don't pick one, and of amounts stored before events had one
+	DefaultCurrency string `yaml:"default_currency" toml:"default_currency" env:"DEFAULT_CURRENCY"`
+}
+
+// Default returns the settings used when nothing else is configured.
+func Default() *Config {
+	return &Config{
+		Server:   Server{Port: "8080"},
+		Database: Database{Port: "3306"},
+		JWT: JWT{
+			SigningAlgorithm:   "HS256",
+			AccessTokenExpiry:  Duration(15 * time.Minute),
+			RefreshTokenExpiry: Duration(30 * 24 * time.Hour),
+		},
+		Mail: Mail{Driver: "file", FileDir: "mail"},
+		SMS:  SMS{Driver: "console"},
+		Auth: Auth{LoginAttemptStore: "db", MagicLinkBindDevice: true},
+		Password: Password{
+			MinLength:            8,
+			MaxLength:            72,
+			DisallowPersonalInfo: true,
+		},
+		PasswordHash: PasswordHash{
+			Hasher:            "argon2id",
+			Argon2Memory:      64 * 1024,
+			Argon2Iterations:  3,
+			Argon2Parallelism: 2,
+			BcryptCost:        10,
+		},
+		WebAuthn: WebAuthn{RPName: "Smart Divide"},
+		OIDC:     OIDC{Providers: map[string]OIDCProvider{}},
+		Money:    Money{DefaultCurrency: "USD"},
+	}
+}
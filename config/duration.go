@@ -0,0 +1,62 @@
+package config
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"time"
+)
+
+// longUnits matches the day and week units time.ParseDuration doesn't know.
+var longUnits = regexp.MustCompile(`(\d+(?:\.\d+)?)([dw])`)
+
+// Duration is a time.Duration written like "15m", "12h" or "30d". Besides the units of
+// time.ParseDuration it accepts "d" for days and "w" for weeks.
+type Duration time.Duration
+
+// ParseDuration parses a duration such as "15m", "30d" or "1d12h".
+func ParseDuration(value string) (Duration, error) {
+	var convertErr error
+	expanded := longUnits.ReplaceAllStringFunc(value, func(match string) string {
+		parts := longUnits.FindStringSubmatch(match)
+		n, err := strconv.ParseFloat(parts[1], 64)
+		if err != nil {
+			convertErr = err
+			return match
+		}
+		if parts[2] == "w" {
+			n *= 7
+		}
+		return strconv.FormatFloat(n*24, 'f', -1, 64) + "h"
+	})
+	if convertErr != nil {
+		return 0, fmt.Errorf("invalid duration %q", value)
+	}
+
+	d, err := time.ParseDuration(expanded)
+	if err != nil {
+		return 0, fmt.Errorf("invalid duration %q, expected a number with a unit such as 15m, 12h or 30d", value)
+	}
+	return Duration(d), nil
+}
+
+func (d Duration) Duration() time.Duration {
+	return time.Duration(d)
+}
+
+func (d Duration) String() string {
+	return time.Duration(d).String()
+}
+
+func (d *Duration) UnmarshalText(text []byte) error {
+	parsed, err := ParseDuration(string(text))
+	if err != nil {
+		return err
+	}
+	*d = parsed
+	return nil
+}
+
+func (d Duration) MarshalText() ([]byte, error) {
+	return []byte(d.String()), nil
+}
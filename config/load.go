@@ -0,0 +1,231 @@
+package config
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/pelletier/go-toml/v2"
+	"gopkg.in/yaml.v3"
+)
+
+// Load reads the config from the defaults, the file given with -config or CONFIG_FILE, the
+// environment and the command line flags in args, and validates it. Every problem found is
+// reported at once.
+func Load(args []string) (*Config, error) {
+	cfg := Default()
+	all := settings(cfg)
+
+	flags := flag.NewFlagSet("smart_divide", flag.ContinueOnError)
+	configFile := flags.String("config", os.Getenv("CONFIG_FILE"), "YAML or TOML file to read settings from")
+	for _, s := range all {
+		flags.String(s.flagName(), "", "overrides "+s.env)
+	}
+	if err := flags.Parse(args); err != nil {
+		return nil, err
+	}
+
+	if *configFile != "" {
+		if err := loadFile(cfg, *configFile); err != nil {
+			return nil, err
+		}
+	}
+
+	var errs []error
+	for _, s := range all {
+		// Empty variables count as unset, as in .env.example
+		if raw := os.Getenv(s.env); raw != "" {
+			if err := s.set(raw); err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", s.env, err))
+			}
+		}
+	}
+	loadOIDCFromEnv(cfg)
+
+	flags.Visit(func(f *flag.Flag) {
+		for _, s := range all {
+			if s.flagName() == f.Name {
+				if err := s.set(f.Value.String()); err != nil {
+					errs = append(errs, fmt.Errorf("-%s: %w", f.Name, err))
+				}
+			}
+		}
+	})
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+
+	// Passkeys are used on the relying party's own site unless other origins are listed
+	if cfg.WebAuthn.RPID != "" && len(cfg.WebAuthn.RPOrigins) == 0 {
+		cfg.WebAuthn.RPOrigins = []string{"https://" + cfg.WebAuthn.RPID}
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+
+	return cfg, nil
+}
+
+// loadFile reads a YAML or TOML file, chosen by its extension, over the current settings.
+// Unknown keys are rejected so typos don't go unnoticed.
+func loadFile(cfg *Config, path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open config file: %w", err)
+	}
+	defer file.Close()
+
+	switch ext := strings.ToLower(filepath.Ext(path)); ext {
+	case ".yaml", ".yml":
+		decoder := yaml.NewDecoder(file)
+		decoder.KnownFields(true)
+		err = decoder.Decode(cfg)
+	case ".toml":
+		decoder := toml.NewDecoder(file)
+		decoder.DisallowUnknownFields()
+		err = decoder.Decode(cfg)
+		var strictErr *toml.StrictMissingError
+		if errors.As(err, &strictErr) {
+			err = errors.New(strictErr.String())
+		}
+	default:
+		return fmt.Errorf("unsupported config file format %q, expected .yaml, .yml or .toml", ext)
+	}
+	if err != nil {
+		return fmt.Errorf("invalid config file %s: %w", path, err)
+	}
+
+	return nil
+}
+
+// loadOIDCFromEnv adds the providers listed in OIDC_PROVIDERS, over those from the config file.
+func loadOIDCFromEnv(cfg *Config) {
+	if cfg.OIDC.Providers == nil {
+		cfg.OIDC.Providers = make(map[string]OIDCProvider)
+	}
+
+	for _, name := range splitList(os.Getenv("OIDC_PROVIDERS")) {
+		name = strings.ToLower(name)
+		prefix := "OIDC_" + strings.ToUpper(name) + "_"
+		provider := cfg.OIDC.Providers[name]
+
+		for _, field := range []struct {
+			env   string
+			value *string
+		}{
+			{prefix + "ISSUER", &provider.Issuer},
+			{prefix + "CLIENT_ID", &provider.ClientID},
+			{prefix + "CLIENT_SECRET", &provider.ClientSecret},
+			{prefix + "REDIRECT_URL", &provider.RedirectURL},
+		} {
+			if raw := os.Getenv(field.env); raw != "" {
+				*field.value = raw
+			}
+		}
+		if scopes := os.Getenv(prefix + "SCOPES"); scopes != "" {
+			provider.Scopes = strings.Fields(strings.ReplaceAll(scopes, ",", " "))
+		}
+
+		cfg.OIDC.Providers[name] = provider
+	}
+}
+
+// setting is a single value of the config that can be set from the environment or a flag.
+type setting struct {
+	env   string
+	key   string // Key in the config file, sections separated by dots
+	unit  string // Unit of a duration given as a bare number
+	value reflect.Value
+}
+
+// settings returns every setting of cfg that has an environment variable.
+func settings(cfg *Config) []setting {
+	var all []setting
+	collectSettings(reflect.ValueOf(cfg).Elem(), "", &all)
+	return all
+}
+
+func collectSettings(v reflect.Value, prefix string, all *[]setting) {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		key := field.Tag.Get("yaml")
+		if prefix != "" {
+			key = prefix + "." + key
+		}
+
+		if env := field.Tag.Get("env"); env != "" {
+			*all = append(*all, setting{env: env, key: key, unit: field.Tag.Get("unit"), value: v.Field(i)})
+		} else if field.Type.Kind() == reflect.Struct {
+			collectSettings(v.Field(i), key, all)
+		}
+	}
+}
+
+// flagName is the command line flag of the setting, e.g. jwt-access-token-expiry.
+func (s setting) flagName() string {
+	return strings.ToLower(strings.ReplaceAll(s.env, "_", "-"))
+}
+
+func (s setting) set(raw string) error {
+	switch value := s.value.Addr().Interface().(type) {
+	case *string:
+		*value = raw
+	case *bool:
+		b, err := strconv.ParseBool(raw)
+		if err != nil {
+			return fmt.Errorf("invalid boolean %q", raw)
+		}
+		*value = b
+	case *int:
+		n, err := strconv.Atoi(raw)
+		if err != nil {
+			return fmt.Errorf("invalid number %q", raw)
+		}
+		*value = n
+	case *uint32:
+		n, err := strconv.ParseUint(raw, 10, 32)
+		if err != nil {
+			return fmt.Errorf("invalid number %q", raw)
+		}
+		*value = uint32(n)
+	case *uint8:
+		n, err := strconv.ParseUint(raw, 10, 8)
+		if err != nil {
+			return fmt.Errorf("invalid number %q, expected 0 to 255", raw)
+		}
+		*value = uint8(n)
+	case *[]string:
+		*value = splitList(raw)
+	case *Duration:
+		// Older configs gave durations as bare numbers in a fixed unit
+		if _, err := strconv.Atoi(raw); err == nil && s.unit != "" {
+			raw += s.unit
+		}
+		d, err := ParseDuration(raw)
+		if err != nil {
+			return err
+		}
+		*value = d
+	default:
+		return fmt.Errorf("unsupported setting type %T", value)
+	}
+	return nil
+}
+
+// splitList splits a comma separated list, dropping empty items.
+func splitList(raw string) []string {
+	var items []string
+	for _, item := range strings.Split(raw, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
@@ -0,0 +1,255 @@
+package config
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// setRequiredEnv sets the settings that have no default, and clears the config file.
+func setRequiredEnv(t *testing.T) {
+	t.Helper()
+
+	for env, value := range map[string]string{
+		"CONFIG_FILE":        "",
+		"MYSQL_USER":         "smart_divide",
+		"MYSQL_HOST":         "localhost",
+		"MYSQL_DATABASE":     "smart_divide",
+		"JWT_ACCESS_SECRET":  "access-secret-access-secret-access",
+		"JWT_REFRESH_SECRET": "refresh-secret-refresh-secret-refresh",
+	} {
+		t.Setenv(env, value)
+	}
+}
+
+func writeFile(t *testing.T, name, content string) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadPrecedence(t *testing.T) {
+	setRequiredEnv(t)
+	path := writeFile(t, "config.yaml", `
+server:
+  port: "9000"
+jwt:
+  access_token_expiry: 20m
+  refresh_token_expiry: 7d
+mail:
+  driver: memory
+`)
+	t.Setenv("SERVER_PORT", "9100")
+	t.Setenv("JWT_ACCESS_TOKEN_EXPIRY", "30m")
+
+	cfg, err := Load([]string{"-config", path, "-server-port", "9200"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Flags override the environment, which overrides the file, which overrides the defaults
+	if cfg.Server.Port != "9200" {
+		t.Errorf("port %q, want the flag", cfg.Server.Port)
+	}
+	if cfg.JWT.AccessTokenExpiry.Duration() != 30*time.Minute {
+		t.Errorf("access token expiry %s, want the environment", cfg.JWT.AccessTokenExpiry)
+	}
+	if cfg.JWT.RefreshTokenExpiry.Duration() != 7*24*time.Hour || cfg.Mail.Driver != "memory" {
+		t.Errorf("refresh token expiry %s and mail driver %q, want the file", cfg.JWT.RefreshTokenExpiry, cfg.Mail.Driver)
+	}
+	if cfg.SMS.Driver != "console" || cfg.PasswordHash.Hasher != "argon2id" {
+		t.Errorf("sms driver %q and hasher %q, want the defaults", cfg.SMS.Driver, cfg.PasswordHash.Hasher)
+	}
+}
+
+func TestLoadFileFromEnv(t *testing.T) {
+	setRequiredEnv(t)
+	t.Setenv("CONFIG_FILE", writeFile(t, "config.toml", `
+[server]
+port = "9000"
+trusted_proxies = ["10.0.0.0/8"]
+
+[money]
+default_currency = "EUR"
+`))
+
+	cfg, err := Load(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Server.Port != "9000" || cfg.Money.DefaultCurrency != "EUR" || !reflect.DeepEqual(cfg.Server.TrustedProxies, []string{"10.0.0.0/8"}) {
+		t.Errorf("TOML file not applied: %+v %+v", cfg.Server, cfg.Money)
+	}
+}
+
+func TestLoadEmptyEnvIsUnset(t *testing.T) {
+	setRequiredEnv(t)
+	t.Setenv("SERVER_PORT", "")
+	t.Setenv("MAILER_DRIVER", "")
+
+	cfg, err := Load(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Server.Port != "8080" || cfg.Mail.Driver != "file" {
+		t.Errorf("empty variables replaced the defaults: port %q, mail driver %q", cfg.Server.Port, cfg.Mail.Driver)
+	}
+}
+
+func TestLoadBareNumberDurations(t *testing.T) {
+	setRequiredEnv(t)
+	t.Setenv("JWT_ACCESS_TOKEN_EXPIRY", "20")
+	t.Setenv("JWT_REFRESH_TOKEN_EXPIRY", "14")
+
+	cfg, err := Load(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.JWT.AccessTokenExpiry.Duration() != 20*time.Minute || cfg.JWT.RefreshTokenExpiry.Duration() != 14*24*time.Hour {
+		t.Errorf("bare numbers read as %s and %s", cfg.JWT.AccessTokenExpiry, cfg.JWT.RefreshTokenExpiry)
+	}
+}
+
+func TestLoadReportsEveryProblem(t *testing.T) {
+	setRequiredEnv(t)
+	t.Setenv("PASSWORD_LENGTH", "eight")
+	t.Setenv("JWT_ACCESS_TOKEN_EXPIRY", "soon")
+	t.Setenv("MAGIC_LINK_BIND_DEVICE", "maybe")
+
+	_, err := Load(nil)
+	if err == nil {
+		t.Fatal("invalid settings accepted")
+	}
+	for _, env := range []string{"PASSWORD_LENGTH", "JWT_ACCESS_TOKEN_EXPIRY", "MAGIC_LINK_BIND_DEVICE"} {
+		if !strings.Contains(err.Error(), env) {
+			t.Errorf("error does not mention %s: %v", env, err)
+		}
+	}
+}
+
+func TestLoadValidates(t *testing.T) {
+	setRequiredEnv(t)
+	t.Setenv("MYSQL_USER", "")
+	t.Setenv("JWT_REFRESH_SECRET", "short")
+	t.Setenv("TRUSTED_PROXIES", "10.0.0.1,not-an-ip")
+
+	_, err := Load(nil)
+	var configErr *Error
+	if !errors.As(err, &configErr) {
+		t.Fatalf("returned %v, want an *Error", err)
+	}
+	if len(configErr.Problems) != 3 {
+		t.Errorf("problems %q, want 3", configErr.Problems)
+	}
+}
+
+func TestLoadRejectsUnknownFileKeys(t *testing.T) {
+	setRequiredEnv(t)
+
+	for name, content := range map[string]string{
+		"config.yaml": "server:\n  prot: \"9000\"\n",
+		"config.toml": "[server]\nprot = \"9000\"\n",
+	} {
+		if _, err := Load([]string{"-config", writeFile(t, name, content)}); err == nil {
+			t.Errorf("%s with a misspelled key accepted", name)
+		}
+	}
+	if _, err := Load([]string{"-config", writeFile(t, "config.json", "{}")}); err == nil {
+		t.Error("unsupported file format accepted")
+	}
+}
+
+func TestLoadOIDCFromEnv(t *testing.T) {
+	setRequiredEnv(t)
+	t.Setenv("OIDC_PROVIDERS", "Google")
+	t.Setenv("OIDC_GOOGLE_ISSUER", "https://accounts.google.com")
+	t.Setenv("OIDC_GOOGLE_CLIENT_ID", "client")
+	t.Setenv("OIDC_GOOGLE_REDIRECT_URL", "https://app.example.com/callback")
+	t.Setenv("OIDC_GOOGLE_SCOPES", "openid, email")
+
+	cfg, err := Load(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := OIDCProvider{
+		Issuer:      "https://accounts.google.com",
+		ClientID:    "client",
+		RedirectURL: "https://app.example.com/callback",
+		Scopes:      []string{"openid", "email"},
+	}
+	if got := cfg.OIDC.Providers["google"]; !reflect.DeepEqual(got, want) {
+		t.Errorf("provider %+v, want %+v", got, want)
+	}
+}
+
+func TestLoadDefaultsWebAuthnOrigin(t *testing.T) {
+	setRequiredEnv(t)
+	t.Setenv("WEBAUTHN_RP_ID", "example.com")
+
+	cfg, err := Load(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(cfg.WebAuthn.RPOrigins, []string{"https://example.com"}) {
+		t.Errorf("origins %v", cfg.WebAuthn.RPOrigins)
+	}
+}
+
+func TestParseDuration(t *testing.T) {
+	tests := []struct {
+		input string
+		want  time.Duration
+		err   bool
+	}{
+		{"15m", 15 * time.Minute, false},
+		{"12h", 12 * time.Hour, false},
+		{"90s", 90 * time.Second, false},
+		{"30d", 30 * 24 * time.Hour, false},
+		{"1d12h", 36 * time.Hour, false},
+		{"1.5d", 36 * time.Hour, false},
+		{"2w", 14 * 24 * time.Hour, false},
+		{"1w1d", 8 * 24 * time.Hour, false},
+		{"0", 0, false},
+		{"15", 0, true},
+		{"d", 0, true},
+		{"soon", 0, true},
+		{"", 0, true},
+	}
+	for _, tt := range tests {
+		got, err := ParseDuration(tt.input)
+		if tt.err {
+			if err == nil {
+				t.Errorf("ParseDuration(%q) = %s, want an error", tt.input, got)
+			}
+			continue
+		}
+		if err != nil || got.Duration() != tt.want {
+			t.Errorf("ParseDuration(%q) = %s, %v, want %s", tt.input, got, err, tt.want)
+		}
+	}
+}
+
+func TestDurationInFiles(t *testing.T) {
+	setRequiredEnv(t)
+
+	for name, content := range map[string]string{
+		"config.yaml": "jwt:\n  access_token_expiry: 1h\n  refresh_token_expiry: 2w\n",
+		"config.toml": "[jwt]\naccess_token_expiry = \"1h\"\nrefresh_token_expiry = \"2w\"\n",
+	} {
+		cfg, err := Load([]string{"-config", writeFile(t, name, content)})
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if cfg.JWT.AccessTokenExpiry.Duration() != time.Hour || cfg.JWT.RefreshTokenExpiry.Duration() != 14*24*time.Hour {
+			t.Errorf("%s: expiries %s and %s", name, cfg.JWT.AccessTokenExpiry, cfg.JWT.RefreshTokenExpiry)
+		}
+	}
+}
@@ -0,0 +1,154 @@
+package config
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/yasharya2901/smart_divide/money"
+)
+
+// minSecretLength is the shortest accepted HMAC secret, 256 bits as required for HS256.
+const minSecretLength = 32
+
+// Error lists every problem found in the config.
+type Error struct {
+	Problems []string
+}
+
+func (e *Error) Error() string {
+	return "invalid configuration:\n  - " + strings.Join(e.Problems, "\n  - ")
+}
+
+// Validate checks every setting and returns an *Error listing all problems, or nil.
+func (c *Config) Validate() error {
+	var problems []string
+	add := func(format string, args ...interface{}) {
+		problems = append(problems, fmt.Sprintf(format, args...))
+	}
+	oneOf := func(name, value string, allowed ...string) {
+		for _, a := range allowed {
+			if value == a {
+				return
+			}
+		}
+		add("%s must be one of %s, got %q", name, strings.Join(allowed, ", "), value)
+	}
+	isURL := func(name, value string) {
+		if value == "" {
+			return
+		}
+		if u, err := url.Parse(value); err != nil || u.Scheme == "" || u.Host == "" {
+			add("%s must be an absolute URL, got %q", name, value)
+		}
+	}
+
+	if port, err := strconv.Atoi(c.Server.Port); err != nil || port < 1 || port > 65535 {
+		add("SERVER_PORT (server.port) must be a port number, got %q", c.Server.Port)
+	}
+	for _, proxy := range c.Server.TrustedProxies {
+		if _, _, err := net.ParseCIDR(proxy); err != nil && net.ParseIP(proxy) == nil {
+			add("TRUSTED_PROXIES (server.trusted_proxies) must be IP addresses or CIDR ranges, got %q", proxy)
+		}
+	}
+
+	for _, required := range []struct{ name, value string }{
+		{"MYSQL_USER (database.user)", c.Database.User},
+		{"MYSQL_HOST (database.host)", c.Database.Host},
+		{"MYSQL_PORT (database.port)", c.Database.Port},
+		{"MYSQL_DATABASE (database.name)", c.Database.Name},
+	} {
+		if required.value == "" {
+			add("%s is required", required.name)
+		}
+	}
+
+	// Tokens signed with an empty or short secret could be forged
+	oneOf("JWT_SIGNING_ALG (jwt.signing_algorithm)", c.JWT.SigningAlgorithm, "HS256", "RS256", "EdDSA")
+	if c.JWT.SigningAlgorithm == "HS256" && len(c.JWT.AccessSecret) < minSecretLength {
+		add("JWT_ACCESS_SECRET (jwt.access_secret) is required with HS256 and must be at least %d characters", minSecretLength)
+	}
+	if len(c.JWT.RefreshSecret) < minSecretLength {
+		add("JWT_REFRESH_SECRET (jwt.refresh_secret) is required and must be at least %d characters", minSecretLength)
+	}
+	if c.JWT.AccessSecret != "" && c.JWT.AccessSecret == c.JWT.RefreshSecret {
+		add("JWT_ACCESS_SECRET and JWT_REFRESH_SECRET must be different")
+	}
+	if c.JWT.AccessTokenExpiry < Duration(time.Minute) {
+		add("JWT_ACCESS_TOKEN_EXPIRY (jwt.access_token_expiry) must be at least 1m, got %s", c.JWT.AccessTokenExpiry)
+	}
+	if c.JWT.RefreshTokenExpiry <= c.JWT.AccessTokenExpiry {
+		add("JWT_REFRESH_TOKEN_EXPIRY (jwt.refresh_token_expiry) must be longer than the access token expiry, got %s", c.JWT.RefreshTokenExpiry)
+	}
+
+	oneOf("MAILER_DRIVER (mail.driver)", c.Mail.Driver, "smtp", "file", "memory")
+	switch c.Mail.Driver {
+	case "smtp":
+		if c.Mail.SMTPHost == "" || c.Mail.From == "" {
+			add("SMTP_HOST (mail.smtp_host) and MAIL_FROM (mail.from) are required with the smtp mailer")
+		}
+		if c.Mail.SMTPPort < 1 || c.Mail.SMTPPort > 65535 {
+			add("SMTP_PORT (mail.smtp_port) must be a port number with the smtp mailer, got %d", c.Mail.SMTPPort)
+		}
+	case "file":
+		if c.Mail.FileDir == "" {
+			add("MAILER_FILE_DIR (mail.file_dir) is required with the file mailer")
+		}
+	}
+
+	oneOf("SMS_DRIVER (sms.driver)", c.SMS.Driver, "console", "memory")
+
+	isURL("PASSWORD_RESET_URL (links.password_reset)", c.Links.PasswordReset)
+	isURL("ACCOUNT_UNLOCK_URL (links.account_unlock)", c.Links.AccountUnlock)
+	isURL("EMAIL_VERIFICATION_URL (links.email_verification)", c.Links.EmailVerification)
+	isURL("MAGIC_LINK_URL (links.magic_link)", c.Links.MagicLink)
+	isURL("INVITATION_URL (links.invitation)", c.Links.Invitation)
+
+	oneOf("LOGIN_ATTEMPT_STORE (auth.login_attempt_store)", c.Auth.LoginAttemptStore, "db", "memory")
+
+	if c.Password.MinLength < 1 {
+		add("PASSWORD_LENGTH (password.min_length) must be at least 1, got %d", c.Password.MinLength)
+	}
+	if c.Password.MaxLength < c.Password.MinLength {
+		add("PASSWORD_MAX_LENGTH (password.max_length) can't be less than PASSWORD_LENGTH, got %d", c.Password.MaxLength)
+	}
+
+	oneOf("PASSWORD_HASHER (password_hash.hasher)", c.PasswordHash.Hasher, "argon2id", "bcrypt")
+	if c.PasswordHash.Argon2Memory < 8*uint32(c.PasswordHash.Argon2Parallelism) {
+		add("ARGON2_MEMORY (password_hash.argon2_memory) must be at least 8 KiB per thread, got %d", c.PasswordHash.Argon2Memory)
+	}
+	if c.PasswordHash.Argon2Iterations < 1 {
+		add("ARGON2_ITERATIONS (password_hash.argon2_iterations) must be at least 1")
+	}
+	if c.PasswordHash.Argon2Parallelism < 1 {
+		add("ARGON2_PARALLELISM (password_hash.argon2_parallelism) must be at least 1")
+	}
+	if c.PasswordHash.BcryptCost < 4 || c.PasswordHash.BcryptCost > 31 {
+		add("BCRYPT_COST (password_hash.bcrypt_cost) must be between 4 and 31, got %d", c.PasswordHash.BcryptCost)
+	}
+
+	for _, origin := range c.WebAuthn.RPOrigins {
+		isURL("WEBAUTHN_RP_ORIGINS (webauthn.rp_origins)", origin)
+	}
+
+	for name, provider := range c.OIDC.Providers {
+		if provider.Issuer == "" || provider.ClientID == "" || provider.RedirectURL == "" {
+			prefix := "OIDC_" + strings.ToUpper(name) + "_"
+			add("oidc provider %q needs %sISSUER, %sCLIENT_ID and %sREDIRECT_URL (oidc.providers.%s.issuer, client_id and redirect_url)", name, prefix, prefix, prefix, name)
+		}
+		isURL(fmt.Sprintf("oidc provider %q issuer", name), provider.Issuer)
+		isURL(fmt.Sprintf("oidc provider %q redirect URL", name), provider.RedirectURL)
+	}
+
+	if !money.ValidCurrency(c.Money.DefaultCurrency) {
+		add("DEFAULT_CURRENCY (money.default_currency) must be an upper case ISO 4217 code such as USD, got %q", c.Money.DefaultCurrency)
+	}
+
+	if len(problems) > 0 {
+		return &Error{Problems: problems}
+	}
+	return nil
+}
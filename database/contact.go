@@ -0,0 +1,34 @@
+package database
+
+import (
+	"github.com/yasharya2901/smart_divide/models"
+	"gorm.io/gorm"
+)
+
+// MigrateVerifiedContacts fills verified_contact for accounts that verified their number
+// before it had to be unique. When several accounts verified the same number, the oldest
+// keeps it and the others have to verify it again. Running it again does nothing.
+func MigrateVerifiedContacts(db *gorm.DB) error {
+	var people []models.Person
+	if err := db.Select("id", "contact").
+		Where("contact_verified = ? AND verified_contact IS NULL", true).
+		Order("id").Find(&people).Error; err != nil {
+		return err
+	}
+
+	for _, person := range people {
+		var count int64
+		if err := db.Model(&models.Person{}).Where("verified_contact = ?", person.Contact).Count(&count).Error; err != nil {
+			return err
+		}
+
+		updates := map[string]interface{}{"verified_contact": person.Contact}
+		if count > 0 || person.Contact == "" {
+			updates = map[string]interface{}{"contact_verified": false}
+		}
+		if err := db.Model(&models.Person{}).Where("id = ?", person.ID).Updates(updates).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
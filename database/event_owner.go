@@ -0,0 +1,40 @@
+package database
+
+import (
+	"github.com/yasharya2901/smart_divide/models"
+	"github.com/yasharya2901/smart_divide/services"
+	"gorm.io/gorm"
+)
+
+// MigrateEventOwners gives an owner to events that have none, which is every event created
+// before members had roles. The member who joined first becomes the owner, preferring
+// accounts over placeholders. Running it again does nothing.
+func MigrateEventOwners(db *gorm.DB) error {
+	var eventIDs []uint
+	if err := db.Model(&models.EventPerson{}).Distinct("event_id").
+		Where("event_id NOT IN (?)", db.Model(&models.EventPerson{}).Select("event_id").Where("role = ?", services.RoleOwner)).
+		Pluck("event_id", &eventIDs).Error; err != nil {
+		return err
+	}
+
+	for _, eventID := range eventIDs {
+		var members []models.EventPerson
+		if err := db.Table("event_people").Select("event_people.*").
+			Joins("JOIN people ON people.id = event_people.person_id").
+			Where("event_people.event_id = ?", eventID).
+			Order("people.placeholder, event_people.created_at, event_people.person_id").
+			Limit(1).Find(&members).Error; err != nil {
+			return err
+		}
+		if len(members) == 0 {
+			continue
+		}
+
+		if err := db.Model(&models.EventPerson{}).
+			Where("event_id = ? AND person_id = ?", eventID, members[0].PersonID).
+			Update("role", services.RoleOwner).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,91 @@
+package database
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/glebarez/sqlite"
+	"github.com/yasharya2901/smart_divide/models"
+	"github.com/yasharya2901/smart_divide/services"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+func TestMigrateEventOwners(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "test.db")), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if sqlDB, err := db.DB(); err == nil {
+			sqlDB.Close()
+		}
+	})
+	if err := db.AutoMigrate(&models.EventPerson{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.SetupJoinTable(&models.Event{}, "People", &models.EventPerson{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.AutoMigrate(&models.Person{}, &models.Event{}); err != nil {
+		t.Fatal(err)
+	}
+
+	people := []models.Person{
+		{Name: "Placeholder", Placeholder: true},
+		{Name: "First", Email: "first@example.com"},
+		{Name: "Second", Email: "second@example.com"},
+	}
+	if err := db.Create(&people).Error; err != nil {
+		t.Fatal(err)
+	}
+	placeholder, first, second := people[0].ID, people[1].ID, people[2].ID
+
+	events := []models.Event{{Name: "Old"}, {Name: "Owned"}, {Name: "Placeholders only"}, {Name: "Empty"}}
+	if err := db.Create(&events).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	// Members from before roles all have the default role
+	joined := time.Now().Add(-time.Hour)
+	memberships := []models.EventPerson{
+		{EventID: events[0].ID, PersonID: placeholder, Role: services.RoleMember, CreatedAt: joined},
+		{EventID: events[0].ID, PersonID: second, Role: services.RoleMember, CreatedAt: joined.Add(time.Minute)},
+		{EventID: events[0].ID, PersonID: first, Role: services.RoleMember, CreatedAt: joined.Add(2 * time.Minute)},
+		{EventID: events[1].ID, PersonID: first, Role: services.RoleMember, CreatedAt: joined},
+		{EventID: events[1].ID, PersonID: second, Role: services.RoleOwner, CreatedAt: joined.Add(time.Minute)},
+		{EventID: events[2].ID, PersonID: placeholder, Role: services.RoleMember, CreatedAt: joined},
+	}
+	if err := db.Create(&memberships).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	for run := 0; run < 2; run++ {
+		if err := MigrateEventOwners(db); err != nil {
+			t.Fatal(err)
+		}
+
+		var owners []models.EventPerson
+		if err := db.Where("role = ?", services.RoleOwner).Order("event_id").Find(&owners).Error; err != nil {
+			t.Fatal(err)
+		}
+		got := map[uint]uint{}
+		for _, owner := range owners {
+			got[owner.EventID] = owner.PersonID
+		}
+		want := map[uint]uint{
+			events[0].ID: second,      // The account that joined first, not the placeholder
+			events[1].ID: second,      // Already had an owner
+			events[2].ID: placeholder, // Nobody else can own it
+		}
+		if len(owners) != len(want) {
+			t.Errorf("run %d: %d owners, want %d", run, len(owners), len(want))
+		}
+		for eventID, personID := range want {
+			if got[eventID] != personID {
+				t.Errorf("run %d: event %d owned by %d, want %d", run, eventID, got[eventID], personID)
+			}
+		}
+	}
+}
@@ -0,0 +1,68 @@
+package database
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/yasharya2901/smart_divide/models"
+	"github.com/yasharya2901/smart_divide/money"
+	"gorm.io/gorm"
+)
+
+// decimalColumn is an amount column from before amounts were stored in minor units, and the
+// columns of the money.Money that replaced it.
+type decimalColumn struct {
+	model    interface{}
+	table    string
+	column   string
+	minor    string
+	currency string
+}
+
+var decimalColumns = []decimalColumn{
+	{&models.Expense{}, "expenses", "total_amount", "total_amount_minor", "total_currency"},
+	{&models.ExpensePerson{}, "expense_people", "paid_amount", "paid_amount_minor", "paid_currency"},
+	{&models.ExpensePerson{}, "expense_people", "owed_amount", "owed_amount_minor", "owed_currency"},
+}
+
+// MigrateMoney moves amounts stored as decimal(10,2) into minor unit columns and drops the
+// old columns. It must run after the schema is migrated. Existing events and amounts are in
+// the given currency, as the server had a single one before. Decimal arithmetic is exact, so
+// no amount changes, and amounts with more decimals than the currency has stop the migration
+// rather than being rounded. Running it again does nothing.
+func MigrateMoney(db *gorm.DB, currency string) error {
+	if err := db.Exec("UPDATE events SET currency = ? WHERE currency = ''", currency).Error; err != nil {
+		return err
+	}
+
+	exponent := money.Exponent(currency)
+	scale := int64(math.Pow10(exponent))
+	for _, c := range decimalColumns {
+		if !db.Migrator().HasColumn(c.model, c.column) {
+			continue
+		}
+
+		err := db.Transaction(func(tx *gorm.DB) error {
+			var inexact int64
+			if err := tx.Raw(fmt.Sprintf("SELECT COUNT(*) FROM %s WHERE %s <> ROUND(%s, ?)", c.table, c.column, c.column), exponent).
+				Scan(&inexact).Error; err != nil {
+				return err
+			}
+			if inexact > 0 {
+				return fmt.Errorf("%d amounts in %s.%s have more decimals than %s allows, set DEFAULT_CURRENCY to the currency they are in",
+					inexact, c.table, c.column, currency)
+			}
+
+			return tx.Exec(fmt.Sprintf("UPDATE %s SET %s = ROUND(COALESCE(%s, 0) * ?), %s = ?", c.table, c.minor, c.column, c.currency),
+				scale, currency).Error
+		})
+		if err != nil {
+			return err
+		}
+
+		if err := db.Migrator().DropColumn(c.model, c.column); err != nil {
+			return err
+		}
+	}
+	return nil
+}
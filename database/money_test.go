@@ -0,0 +1,148 @@
+package database
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/glebarez/sqlite"
+	"github.com/yasharya2901/smart_divide/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// newDecimalDB returns a database with the amount columns of before MigrateMoney, holding
+// the given expense totals and an owed amount for each.
+func newDecimalDB(t *testing.T, totals ...string) *gorm.DB {
+	t.Helper()
+
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "test.db")), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if sqlDB, err := db.DB(); err == nil {
+			sqlDB.Close()
+		}
+	})
+
+	if err := db.AutoMigrate(&models.Event{}, &models.Expense{}, &models.ExpensePerson{}); err != nil {
+		t.Fatal(err)
+	}
+	for _, statement := range []string{
+		`ALTER TABLE "expenses" ADD COLUMN "total_amount" decimal(10,2)`,
+		`ALTER TABLE "expense_people" ADD COLUMN "paid_amount" decimal(10,2)`,
+		`ALTER TABLE "expense_people" ADD COLUMN "owed_amount" decimal(10,2)`,
+		`INSERT INTO "events" ("name", "currency") VALUES ('Trip', '')`,
+	} {
+		if err := db.Exec(statement).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for i, total := range totals {
+		if err := db.Exec(`INSERT INTO "expenses" ("name", "event_id", "paid_by_id", "total_amount") VALUES ('Dinner', 1, 1, ?)`, total).Error; err != nil {
+			t.Fatal(err)
+		}
+		if err := db.Exec(`INSERT INTO "expense_people" ("expense_id", "person_id", "paid_amount", "owed_amount") VALUES (?, 1, NULL, ?)`, i+1, total).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	return db
+}
+
+func TestMigrateMoney(t *testing.T) {
+	totals := []string{"33.33", "33.33", "33.34", "0.10", "-5.05", "99999999.99"}
+	db := newDecimalDB(t, totals...)
+
+	if err := MigrateMoney(db, "USD"); err != nil {
+		t.Fatal(err)
+	}
+
+	var expenses []models.Expense
+	if err := db.Order("id").Find(&expenses).Error; err != nil {
+		t.Fatal(err)
+	}
+	var sum int64
+	for i, expense := range expenses {
+		if expense.TotalAmount.String() != totals[i] || expense.TotalAmount.Currency != "USD" {
+			t.Errorf("expense %d has %s %s, want %s", expense.ID, expense.TotalAmount, expense.TotalAmount.Currency, totals[i])
+		}
+		if i < 3 {
+			sum += expense.TotalAmount.Amount
+		}
+	}
+	if sum != 10000 {
+		t.Errorf("33.33 + 33.33 + 33.34 migrated to %d cents", sum)
+	}
+
+	var people []models.ExpensePerson
+	if err := db.Order("id").Find(&people).Error; err != nil {
+		t.Fatal(err)
+	}
+	for i, person := range people {
+		if person.OwedAmount.String() != totals[i] || !person.PaidAmount.IsZero() || person.PaidAmount.Currency != "USD" {
+			t.Errorf("expense person %d owes %s and paid %s", person.ID, person.OwedAmount, person.PaidAmount)
+		}
+	}
+
+	var event models.Event
+	if err := db.First(&event).Error; err != nil {
+		t.Fatal(err)
+	}
+	if event.Currency != "USD" {
+		t.Errorf("event currency %q", event.Currency)
+	}
+
+	for _, c := range decimalColumns {
+		if db.Migrator().HasColumn(c.model, c.column) {
+			t.Errorf("%s.%s was not dropped", c.table, c.column)
+		}
+	}
+
+	// Running it again changes nothing
+	if err := MigrateMoney(db, "EUR"); err != nil {
+		t.Fatal(err)
+	}
+	var again models.Expense
+	if err := db.First(&again, expenses[0].ID).Error; err != nil {
+		t.Fatal(err)
+	}
+	if !again.TotalAmount.Equal(expenses[0].TotalAmount) {
+		t.Errorf("second run changed %v to %v", expenses[0].TotalAmount, again.TotalAmount)
+	}
+}
+
+func TestMigrateMoneyWithoutDecimals(t *testing.T) {
+	db := newDecimalDB(t, "1500.00")
+
+	if err := MigrateMoney(db, "JPY"); err != nil {
+		t.Fatal(err)
+	}
+
+	var expense models.Expense
+	if err := db.First(&expense).Error; err != nil {
+		t.Fatal(err)
+	}
+	if expense.TotalAmount.Amount != 1500 || expense.TotalAmount.String() != "1500" {
+		t.Errorf("migrated to %d minor units", expense.TotalAmount.Amount)
+	}
+}
+
+func TestMigrateMoneyRefusesRounding(t *testing.T) {
+	db := newDecimalDB(t, "10.00", "33.33")
+
+	if err := MigrateMoney(db, "JPY"); err == nil {
+		t.Fatal("33.33 JPY migrated")
+	}
+	if !db.Migrator().HasColumn(&models.Expense{}, "total_amount") {
+		t.Error("amounts dropped after a failed migration")
+	}
+
+	var expense models.Expense
+	if err := db.Last(&expense).Error; err != nil {
+		t.Fatal(err)
+	}
+	if expense.TotalAmount.Amount != 0 {
+		t.Errorf("failed migration wrote %d", expense.TotalAmount.Amount)
+	}
+}
@@ -0,0 +1,90 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/yasharya2901/smart_divide/middleware"
+	"github.com/yasharya2901/smart_divide/services"
+	"gorm.io/gorm"
+)
+
+type AccountHandler struct {
+	service          *services.AccountService
+	discoveryService *services.DiscoveryService
+}
+
+func NewAccountHandler(db *gorm.DB) *AccountHandler {
+	return &AccountHandler{service: services.NewAccountService(db), discoveryService: services.NewDiscoveryService(db)}
+}
+
+// ExportData sends everything stored about the logged in person, as JSON or, with
+// ?format=zip, as a ZIP archive of JSON files.
+func (h *AccountHandler) ExportData() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		format := c.DefaultQuery("format", "json")
+		if format != "json" && format != "zip" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "format must be json or zip"})
+			return
+		}
+
+		personID, _ := middleware.GetPersonID(c)
+		export, err := h.service.ExportData(personID)
+		if err != nil {
+			c.JSON(errorStatus(err, http.StatusInternalServerError), gin.H{"error": err.Error()})
+			return
+		}
+
+		filename := fmt.Sprintf("smart-divide-export-%s", export.ExportedAt.Format("20060102-150405"))
+		if format == "json" {
+			c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.json"`, filename))
+			c.JSON(http.StatusOK, export)
+			return
+		}
+
+		c.Header("Content-Type", "application/zip")
+		c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.zip"`, filename))
+		c.Status(http.StatusOK)
+		if err := export.WriteZip(c.Writer); err != nil {
+			c.Error(err)
+		}
+	}
+}
+
+func (h *AccountHandler) GetBalances() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		personID, _ := middleware.GetPersonID(c)
+
+		balances, err := h.service.GetBalances(personID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"balances": balances})
+	}
+}
+
+// SetDiscoverable lets the logged in person choose whether others can find them by their
+// verified email or contact number.
+func (h *AccountHandler) SetDiscoverable() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req struct {
+			Discoverable *bool `json:"discoverable" binding:"required"`
+		}
+
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		personID, _ := middleware.GetPersonID(c)
+		if err := h.discoveryService.SetDiscoverable(personID, *req.Discoverable); err != nil {
+			c.JSON(errorStatus(err, http.StatusInternalServerError), gin.H{"error": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"discoverable": *req.Discoverable})
+	}
+}
@@ -0,0 +1,249 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/yasharya2901/smart_divide/config"
+	"github.com/yasharya2901/smart_divide/mailer"
+	"github.com/yasharya2901/smart_divide/middleware"
+	"github.com/yasharya2901/smart_divide/models"
+	"github.com/yasharya2901/smart_divide/passwordhash"
+	"github.com/yasharya2901/smart_divide/services"
+	"github.com/yasharya2901/smart_divide/utils"
+	"gorm.io/gorm"
+)
+
+type AdminHandler struct {
+	service *services.AdminService
+}
+
+func NewAdminHandler(db *gorm.DB, cfg *config.Config, mail mailer.Mailer, passwords *passwordhash.Manager, attempts services.LoginAttemptStore, accessKeys utils.KeySet, auditLog *services.AuditLog) *AdminHandler {
+	return &AdminHandler{service: services.NewAdminService(db, cfg, mail, passwords, attempts, accessKeys, auditLog)}
+}
+
+type adminPersonResponse struct {
+	ID               uint       `json:"id"`
+	Name             string     `json:"name"`
+	Email            string     `json:"email"`
+	EmailVerified    bool       `json:"email_verified"`
+	Contact          string     `json:"contact"`
+	ContactVerified  bool       `json:"contact_verified"`
+	TwoFactorEnabled bool       `json:"two_factor_enabled"`
+	IsAdmin          bool       `json:"is_admin"`
+	DisabledAt       *time.Time `json:"disabled_at"`
+	DisabledReason   string     `json:"disabled_reason,omitempty"`
+	CreatedAt        time.Time  `json:"created_at"`
+}
+
+func newAdminPersonResponse(person *models.Person) adminPersonResponse {
+	return adminPersonResponse{
+		ID:               person.ID,
+		Name:             person.Name,
+		Email:            person.Email,
+		EmailVerified:    person.EmailVerified,
+		Contact:          person.Contact,
+		ContactVerified:  person.ContactVerified,
+		TwoFactorEnabled: person.TOTPEnabled,
+		IsAdmin:          person.IsAdmin,
+		DisabledAt:       person.DisabledAt,
+		DisabledReason:   person.DisabledReason,
+		CreatedAt:        person.CreatedAt,
+	}
+}
+
+// adminTarget parses the ID of the person an admin endpoint acts on.
+func adminTarget(c *gin.Context) (uint, bool) {
+	personID, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid person ID"})
+		return 0, false
+	}
+	return uint(personID), true
+}
+
+// SearchPeople finds accounts by name, email or contact number with the q query parameter.
+func (h *AdminHandler) SearchPeople() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req struct {
+			Query  string `form:"q"`
+			Limit  int    `form:"limit"`
+			Offset int    `form:"offset" binding:"min=0"`
+		}
+
+		if err := c.ShouldBindQuery(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		people, err := h.service.SearchPeople(req.Query, req.Limit, req.Offset)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		res := make([]adminPersonResponse, len(people))
+		for i := range people {
+			res[i] = newAdminPersonResponse(&people[i])
+		}
+
+		c.JSON(http.StatusOK, gin.H{"people": res})
+	}
+}
+
+func (h *AdminHandler) GetAccount() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		personID, ok := adminTarget(c)
+		if !ok {
+			return
+		}
+
+		account, err := h.service.GetAccount(personID)
+		if err != nil {
+			c.JSON(errorStatus(err, http.StatusInternalServerError), gin.H{"error": err.Error()})
+			return
+		}
+
+		events := make([]gin.H, len(account.Events))
+		for i, event := range account.Events {
+			events[i] = gin.H{"id": event.ID, "name": event.Name}
+		}
+
+		sessions := make([]sessionResponse, len(account.Sessions))
+		for i, session := range account.Sessions {
+			sessions[i] = sessionResponse{
+				ID:         session.ID,
+				DeviceName: session.DeviceName,
+				UserAgent:  session.UserAgent,
+				IPAddress:  session.IPAddress,
+				CreatedAt:  session.CreatedAt,
+				LastUsedAt: session.LastUsedAt,
+				ExpiresAt:  session.ExpiresAt,
+			}
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"person":           newAdminPersonResponse(account.Person),
+			"events":           events,
+			"sessions":         sessions,
+			"locked_out_until": account.LockedOutTill,
+		})
+	}
+}
+
+func (h *AdminHandler) LockAccount() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		personID, ok := adminTarget(c)
+		if !ok {
+			return
+		}
+
+		var req struct {
+			Reason string `json:"reason" binding:"required,max=255"`
+		}
+
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		adminID, _ := middleware.GetPersonID(c)
+		if err := h.service.LockAccount(adminID, personID, req.Reason, deviceInfo(c, "")); err != nil {
+			c.JSON(errorStatus(err, http.StatusBadRequest), gin.H{"error": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusNoContent, nil)
+	}
+}
+
+func (h *AdminHandler) UnlockAccount() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		personID, ok := adminTarget(c)
+		if !ok {
+			return
+		}
+
+		adminID, _ := middleware.GetPersonID(c)
+		if err := h.service.UnlockAccount(adminID, personID, deviceInfo(c, "")); err != nil {
+			c.JSON(errorStatus(err, http.StatusInternalServerError), gin.H{"error": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusNoContent, nil)
+	}
+}
+
+func (h *AdminHandler) ForceLogout() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		personID, ok := adminTarget(c)
+		if !ok {
+			return
+		}
+
+		adminID, _ := middleware.GetPersonID(c)
+		if err := h.service.ForceLogout(adminID, personID, deviceInfo(c, "")); err != nil {
+			c.JSON(errorStatus(err, http.StatusInternalServerError), gin.H{"error": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusNoContent, nil)
+	}
+}
+
+func (h *AdminHandler) SetAdmin() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		personID, ok := adminTarget(c)
+		if !ok {
+			return
+		}
+
+		var req struct {
+			IsAdmin *bool `json:"is_admin" binding:"required"`
+		}
+
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		adminID, _ := middleware.GetPersonID(c)
+		if err := h.service.SetAdmin(adminID, personID, *req.IsAdmin, deviceInfo(c, "")); err != nil {
+			c.JSON(errorStatus(err, http.StatusBadRequest), gin.H{"error": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusNoContent, nil)
+	}
+}
+
+// Impersonate returns a read-only access token for the account of a person. The reason is
+// kept in the audit log along with every request made with the token.
+func (h *AdminHandler) Impersonate() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		personID, ok := adminTarget(c)
+		if !ok {
+			return
+		}
+
+		var req struct {
+			Reason string `json:"reason" binding:"required,max=255"`
+		}
+
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		adminID, _ := middleware.GetPersonID(c)
+		token, expiresAt, err := h.service.Impersonate(adminID, personID, req.Reason, deviceInfo(c, ""))
+		if err != nil {
+			c.JSON(errorStatus(err, http.StatusBadRequest), gin.H{"error": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"access_token": token, "expires_at": expiresAt, "read_only": true})
+	}
+}
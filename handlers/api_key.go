@@ -0,0 +1,108 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/yasharya2901/smart_divide/middleware"
+	"github.com/yasharya2901/smart_divide/models"
+	"github.com/yasharya2901/smart_divide/services"
+	"gorm.io/gorm"
+)
+
+type APIKeyHandler struct {
+	service  *services.APIKeyService
+	auditLog *services.AuditLog
+}
+
+func NewAPIKeyHandler(db *gorm.DB, auditLog *services.AuditLog) *APIKeyHandler {
+	return &APIKeyHandler{service: services.NewAPIKeyService(db), auditLog: auditLog}
+}
+
+type apiKeyResponse struct {
+	ID         uint       `json:"id"`
+	Name       string     `json:"name"`
+	Prefix     string     `json:"prefix"`
+	Scopes     []string   `json:"scopes"`
+	CreatedAt  time.Time  `json:"created_at"`
+	ExpiresAt  *time.Time `json:"expires_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+}
+
+func newAPIKeyResponse(apiKey *models.APIKey) apiKeyResponse {
+	return apiKeyResponse{
+		ID:         apiKey.ID,
+		Name:       apiKey.Name,
+		Prefix:     services.APIKeyPrefix + apiKey.Prefix,
+		Scopes:     services.ScopeList(apiKey),
+		CreatedAt:  apiKey.CreatedAt,
+		ExpiresAt:  apiKey.ExpiresAt,
+		LastUsedAt: apiKey.LastUsedAt,
+	}
+}
+
+func (h *APIKeyHandler) GetAPIKeys() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		personID, _ := middleware.GetPersonID(c)
+
+		apiKeys, err := h.service.GetAPIKeys(personID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		res := make([]apiKeyResponse, len(apiKeys))
+		for i := range apiKeys {
+			res[i] = newAPIKeyResponse(&apiKeys[i])
+		}
+
+		c.JSON(http.StatusOK, gin.H{"api_keys": res})
+	}
+}
+
+func (h *APIKeyHandler) CreateAPIKey() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req struct {
+			Name      string     `json:"name" binding:"required"`
+			Scopes    []string   `json:"scopes" binding:"required"`
+			ExpiresAt *time.Time `json:"expires_at"`
+		}
+
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		personID, _ := middleware.GetPersonID(c)
+		apiKey, key, err := h.service.CreateAPIKey(personID, req.Name, req.Scopes, req.ExpiresAt)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		h.auditLog.Record(services.AuditEntry{PersonID: personID, Action: services.AuditAPIKeyCreated, Device: deviceInfo(c, "")})
+
+		// The key is only shown once
+		c.JSON(http.StatusCreated, gin.H{"api_key": newAPIKeyResponse(apiKey), "key": key})
+	}
+}
+
+func (h *APIKeyHandler) RevokeAPIKey() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		apiKeyID, err := strconv.ParseUint(c.Param("id"), 10, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid API key ID"})
+			return
+		}
+
+		personID, _ := middleware.GetPersonID(c)
+		if err := h.service.RevokeAPIKey(personID, uint(apiKeyID)); err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		h.auditLog.Record(services.AuditEntry{PersonID: personID, Action: services.AuditAPIKeyRevoked, Device: deviceInfo(c, "")})
+
+		c.JSON(http.StatusNoContent, nil)
+	}
+}
@@ -0,0 +1,115 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/yasharya2901/smart_divide/middleware"
+	"github.com/yasharya2901/smart_divide/models"
+	"github.com/yasharya2901/smart_divide/services"
+)
+
+type AuditHandler struct {
+	auditLog *services.AuditLog
+}
+
+func NewAuditHandler(auditLog *services.AuditLog) *AuditHandler {
+	return &AuditHandler{auditLog: auditLog}
+}
+
+type auditEventResponse struct {
+	ID        uint      `json:"id"`
+	PersonID  *uint     `json:"person_id,omitempty"`
+	Email     string    `json:"email,omitempty"`
+	Action    string    `json:"action"`
+	Outcome   string    `json:"outcome"`
+	Reason    string    `json:"reason,omitempty"`
+	ActorID   *uint     `json:"actor_id,omitempty"`
+	Detail    string    `json:"detail,omitempty"`
+	IPAddress string    `json:"ip_address"`
+	UserAgent string    `json:"user_agent"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func newAuditEventResponses(events []models.AuditEvent) []auditEventResponse {
+	res := make([]auditEventResponse, len(events))
+	for i, event := range events {
+		res[i] = auditEventResponse{
+			ID:        event.ID,
+			PersonID:  event.PersonID,
+			Email:     event.Email,
+			Action:    event.Action,
+			Outcome:   event.Outcome,
+			Reason:    event.Reason,
+			ActorID:   event.ActorID,
+			Detail:    event.Detail,
+			IPAddress: event.IPAddress,
+			UserAgent: event.UserAgent,
+			CreatedAt: event.CreatedAt,
+		}
+	}
+	return res
+}
+
+// GetMySecurityEvents returns the security history of the logged in person.
+func (h *AuditHandler) GetMySecurityEvents() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		personID, _ := middleware.GetPersonID(c)
+
+		limit, _ := strconv.Atoi(c.Query("limit"))
+		offset, _ := strconv.Atoi(c.Query("offset"))
+
+		events, err := h.auditLog.GetPersonEvents(personID, limit, offset)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"events": newAuditEventResponses(events)})
+	}
+}
+
+// QuerySecurityEvents searches the whole audit log. Filters are passed as query parameters:
+// person_id, actor_id, email, action, outcome, ip_address, since and until (RFC 3339), limit and offset.
+func (h *AuditHandler) QuerySecurityEvents() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req struct {
+			PersonID  uint      `form:"person_id"`
+			ActorID   uint      `form:"actor_id"`
+			Email     string    `form:"email"`
+			Action    string    `form:"action"`
+			Outcome   string    `form:"outcome" binding:"omitempty,oneof=success failure"`
+			IPAddress string    `form:"ip_address"`
+			Since     time.Time `form:"since" time_format:"2006-01-02T15:04:05Z07:00"`
+			Until     time.Time `form:"until" time_format:"2006-01-02T15:04:05Z07:00"`
+			Limit     int       `form:"limit"`
+			Offset    int       `form:"offset" binding:"min=0"`
+		}
+
+		if err := c.ShouldBindQuery(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		events, err := h.auditLog.Query(services.AuditFilter{
+			PersonID:  req.PersonID,
+			ActorID:   req.ActorID,
+			Email:     req.Email,
+			Action:    req.Action,
+			Outcome:   req.Outcome,
+			IPAddress: req.IPAddress,
+			Since:     req.Since,
+			Until:     req.Until,
+			Limit:     req.Limit,
+			Offset:    req.Offset,
+		})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"events": newAuditEventResponses(events)})
+	}
+}
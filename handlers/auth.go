@@ -1,27 +1,95 @@
 package handlers
 
 import (
+	"errors"
+	"log"
+	"math"
 	"net/http"
+	"strconv"
+	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/go-webauthn/webauthn/webauthn"
+	"github.com/yasharya2901/smart_divide/config"
+	"github.com/yasharya2901/smart_divide/mailer"
+	"github.com/yasharya2901/smart_divide/middleware"
+	"github.com/yasharya2901/smart_divide/oidc"
+	"github.com/yasharya2901/smart_divide/passwordhash"
+	"github.com/yasharya2901/smart_divide/passwordpolicy"
 	"github.com/yasharya2901/smart_divide/services"
+	"github.com/yasharya2901/smart_divide/sms"
 	"github.com/yasharya2901/smart_divide/utils"
 	"gorm.io/gorm"
 )
 
 type AuthHandler struct {
-	service *services.AuthService
+	service                  *services.AuthService
+	sessionService           *services.SessionService
+	emailVerificationService *services.EmailVerificationService
+	oidcService              *services.OIDCService
+	passkeyService           *services.PasskeyService
 }
 
-func NewAuthHandler(db *gorm.DB) *AuthHandler {
-	return &AuthHandler{service: services.NewAuthService(db)}
+func NewAuthHandler(db *gorm.DB, cfg *config.Config, mail mailer.Mailer, sender sms.Sender, providers map[string]*oidc.Provider, passkeys *webauthn.WebAuthn, attempts services.LoginAttemptStore, accessKeys utils.KeySet, auditLog *services.AuditLog, passwordPolicy *passwordpolicy.Policy, passwords *passwordhash.Manager) *AuthHandler {
+	authService := services.NewAuthService(db, cfg, mail, sender, attempts, accessKeys, auditLog, passwordPolicy, passwords)
+	return &AuthHandler{
+		service:                  authService,
+		sessionService:           services.NewSessionService(db),
+		emailVerificationService: services.NewEmailVerificationService(db, cfg, mail),
+		oidcService:              services.NewOIDCService(db, providers, authService),
+		passkeyService:           services.NewPasskeyService(db, passkeys, authService),
+	}
+}
+
+type sessionResponse struct {
+	ID         uint      `json:"id"`
+	DeviceName string    `json:"device_name"`
+	UserAgent  string    `json:"user_agent"`
+	IPAddress  string    `json:"ip_address"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastUsedAt time.Time `json:"last_used_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+	Current    bool      `json:"current"`
+}
+
+// deviceInfo collects the details of the device a request comes from.
+func deviceInfo(c *gin.Context, deviceName string) services.DeviceInfo {
+	return services.DeviceInfo{
+		DeviceName: deviceName,
+		UserAgent:  c.Request.UserAgent(),
+		IPAddress:  c.ClientIP(),
+	}
+}
+
+// loginFailed responds to a failed login, telling locked out clients when to retry.
+func loginFailed(c *gin.Context, err error) {
+	var locked *services.LockedError
+	if errors.As(err, &locked) {
+		tooManyRequests(c, locked.Until, err)
+		return
+	}
+
+	if errors.Is(err, services.ErrAccountDisabled) {
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+}
+
+// tooManyRequests responds with 429 and tells the client when to retry.
+func tooManyRequests(c *gin.Context, until time.Time, err error) {
+	retryAfter := int(math.Ceil(time.Until(until).Seconds()))
+	c.Header("Retry-After", strconv.Itoa(retryAfter))
+	c.JSON(http.StatusTooManyRequests, gin.H{"error": err.Error()})
 }
 
 func (a *AuthHandler) Login() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		var req struct {
-			Email    string `json:"email" binding:"required"`
-			Password string `json:"password" binding:"required"`
+			Email      string `json:"email" binding:"required"`
+			Password   string `json:"password" binding:"required"`
+			DeviceName string `json:"device_name"`
 		}
 
 		if err := c.ShouldBindJSON(&req); err != nil {
@@ -29,23 +97,24 @@ func (a *AuthHandler) Login() gin.HandlerFunc {
 			return
 		}
 
-		accessToken, refreshToken, err := a.service.Login(req.Email, req.Password)
+		result, err := a.service.Login(req.Email, req.Password, deviceInfo(c, req.DeviceName))
 		if err != nil {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+			loginFailed(c, err)
 			return
 		}
 
-		c.JSON(http.StatusOK, gin.H{"access_token": accessToken, "refresh_token": refreshToken})
+		c.JSON(http.StatusOK, result)
 	}
 }
 
 func (a *AuthHandler) Register() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		var req struct {
-			Name     string `json:"name" binding:"required"`
-			Contact  string `json:"contact" binding:"required"`
-			Email    string `json:"email" binding:"required"`
-			Password string `json:"password" binding:"required"`
+			Name       string `json:"name" binding:"required"`
+			Contact    string `json:"contact" binding:"required"`
+			Email      string `json:"email" binding:"required"`
+			Password   string `json:"password" binding:"required"`
+			DeviceName string `json:"device_name"`
 		}
 
 		if err := c.ShouldBindJSON(&req); err != nil {
@@ -63,7 +132,10 @@ func (a *AuthHandler) Register() gin.HandlerFunc {
 			return
 		}
 
-		accessToken, refreshToken, err := a.service.Register(req.Name, req.Contact, req.Email, req.Password)
+		accessToken, refreshToken, err := a.service.Register(req.Name, req.Contact, req.Email, req.Password, deviceInfo(c, req.DeviceName))
+		if passwordRejected(c, err) {
+			return
+		}
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 			return
@@ -72,3 +144,511 @@ func (a *AuthHandler) Register() gin.HandlerFunc {
 		c.JSON(http.StatusCreated, gin.H{"access_token": accessToken, "refresh_token": refreshToken})
 	}
 }
+
+func (a *AuthHandler) Refresh() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req struct {
+			RefreshToken string `json:"refresh_token" binding:"required"`
+		}
+
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		accessToken, refreshToken, err := a.service.RegenerateAccessToken(req.RefreshToken, deviceInfo(c, ""))
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"access_token": accessToken, "refresh_token": refreshToken})
+	}
+}
+
+func (a *AuthHandler) GetSessions() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		principal, _ := middleware.GetPrincipal(c)
+
+		sessions, err := a.sessionService.GetActiveSessions(principal.PersonID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		res := make([]sessionResponse, len(sessions))
+		for i, session := range sessions {
+			res[i] = sessionResponse{
+				ID:         session.ID,
+				DeviceName: session.DeviceName,
+				UserAgent:  session.UserAgent,
+				IPAddress:  session.IPAddress,
+				CreatedAt:  session.CreatedAt,
+				LastUsedAt: session.LastUsedAt,
+				ExpiresAt:  session.ExpiresAt,
+				Current:    session.ID == principal.SessionID,
+			}
+		}
+
+		c.JSON(http.StatusOK, gin.H{"sessions": res})
+	}
+}
+
+func (a *AuthHandler) RevokeSession() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		sessionID, err := strconv.ParseUint(c.Param("id"), 10, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid session ID"})
+			return
+		}
+
+		personID, _ := middleware.GetPersonID(c)
+		if err := a.service.RevokeSession(personID, uint(sessionID), deviceInfo(c, "")); err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusNoContent, nil)
+	}
+}
+
+func (a *AuthHandler) RevokeOtherSessions() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		principal, _ := middleware.GetPrincipal(c)
+
+		if err := a.service.RevokeOtherSessions(principal.PersonID, principal.SessionID, deviceInfo(c, "")); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusNoContent, nil)
+	}
+}
+
+func (a *AuthHandler) Logout() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		principal, _ := middleware.GetPrincipal(c)
+
+		if err := a.service.Logout(principal.PersonID, principal.SessionID, deviceInfo(c, "")); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusNoContent, nil)
+	}
+}
+
+func (a *AuthHandler) ChangePassword() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req struct {
+			OldPassword string `json:"old_password" binding:"required"`
+			NewPassword string `json:"new_password" binding:"required"`
+			DeviceName  string `json:"device_name"`
+		}
+
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		personID, _ := middleware.GetPersonID(c)
+		accessToken, refreshToken, err := a.service.ChangePassword(personID, req.OldPassword, req.NewPassword, deviceInfo(c, req.DeviceName))
+		if passwordRejected(c, err) {
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"access_token": accessToken, "refresh_token": refreshToken})
+	}
+}
+
+func (a *AuthHandler) DeleteAccount() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req struct {
+			Password string `json:"password"`
+			Code     string `json:"code"`
+		}
+
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		personID, _ := middleware.GetPersonID(c)
+		err := a.service.DeleteAccount(personID, req.Password, req.Code, deviceInfo(c, ""))
+
+		var unsettled *services.UnsettledBalancesError
+		if errors.As(err, &unsettled) {
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error(), "balances": unsettled.Balances})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusNoContent, nil)
+	}
+}
+
+func (a *AuthHandler) GetPasswordPolicy() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.JSON(http.StatusOK, a.service.PasswordPolicy())
+	}
+}
+
+func (a *AuthHandler) ForgotPassword() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req struct {
+			Email string `json:"email" binding:"required"`
+		}
+
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		// The response is the same whether or not the email is registered
+		if err := a.service.RequestPasswordReset(req.Email, deviceInfo(c, "")); err != nil {
+			log.Println("failed to send password reset email:", err)
+		}
+
+		c.JSON(http.StatusAccepted, gin.H{"message": "If the email is registered, a password reset link has been sent"})
+	}
+}
+
+func (a *AuthHandler) ResetPassword() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req struct {
+			Token       string `json:"token" binding:"required"`
+			NewPassword string `json:"new_password" binding:"required"`
+		}
+
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		err := a.service.ResetPassword(req.Token, req.NewPassword, deviceInfo(c, ""))
+		if passwordRejected(c, err) {
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "Password has been reset"})
+	}
+}
+
+func (a *AuthHandler) UnlockAccount() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req struct {
+			Token string `json:"token" binding:"required"`
+		}
+
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		if err := a.service.UnlockAccount(req.Token, deviceInfo(c, "")); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "Account has been unlocked"})
+	}
+}
+
+func (a *AuthHandler) GetLockouts() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		personID, _ := middleware.GetPersonID(c)
+
+		events, err := a.service.GetLockoutEvents(personID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		res := make([]gin.H, len(events))
+		for i, event := range events {
+			res[i] = gin.H{
+				"id":           event.ID,
+				"ip_address":   event.IPAddress,
+				"user_agent":   event.UserAgent,
+				"failures":     event.Failures,
+				"locked_at":    event.CreatedAt,
+				"locked_until": event.LockedUntil,
+				"unlocked_at":  event.UnlockedAt,
+			}
+		}
+
+		c.JSON(http.StatusOK, gin.H{"lockouts": res})
+	}
+}
+
+func (a *AuthHandler) VerifyEmail() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req struct {
+			Token string `json:"token" binding:"required"`
+		}
+
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		person, err := a.service.VerifyEmail(req.Token, deviceInfo(c, ""))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "Email verified", "email": person.Email})
+	}
+}
+
+func (a *AuthHandler) ResendVerification() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		personID, _ := middleware.GetPersonID(c)
+
+		if err := a.emailVerificationService.ResendVerification(personID); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusAccepted, gin.H{"message": "Verification email sent"})
+	}
+}
+
+func (a *AuthHandler) RequestLoginOTP() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req struct {
+			Contact string `json:"contact" binding:"required"`
+		}
+
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		if !utils.ValidatePhoneNumber(req.Contact) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid phone number"})
+			return
+		}
+
+		if err := a.service.RequestLoginOTP(req.Contact); err != nil {
+			if errors.Is(err, services.ErrTooManyOTPRequests) {
+				c.JSON(http.StatusTooManyRequests, gin.H{"error": err.Error()})
+				return
+			}
+			log.Println("failed to send login code:", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to send code"})
+			return
+		}
+
+		c.JSON(http.StatusAccepted, gin.H{"message": "If the number is registered, a code has been sent"})
+	}
+}
+
+func (a *AuthHandler) LoginWithOTP() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req struct {
+			Contact    string `json:"contact" binding:"required"`
+			Code       string `json:"code" binding:"required"`
+			DeviceName string `json:"device_name"`
+		}
+
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		result, err := a.service.LoginWithOTP(req.Contact, req.Code, deviceInfo(c, req.DeviceName))
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, result)
+	}
+}
+
+func (a *AuthHandler) RequestContactVerification() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		personID, _ := middleware.GetPersonID(c)
+
+		if err := a.service.RequestContactVerification(personID); err != nil {
+			c.JSON(errorStatus(err, http.StatusBadRequest), gin.H{"error": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusAccepted, gin.H{"message": "Verification code sent"})
+	}
+}
+
+func (a *AuthHandler) VerifyContact() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req struct {
+			Code string `json:"code" binding:"required"`
+		}
+
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		personID, _ := middleware.GetPersonID(c)
+		if err := a.service.VerifyContact(personID, req.Code); err != nil {
+			c.JSON(errorStatus(err, http.StatusBadRequest), gin.H{"error": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "Contact number verified"})
+	}
+}
+
+func (a *AuthHandler) CompleteTwoFactorLogin() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req struct {
+			TwoFactorToken string `json:"two_factor_token" binding:"required"`
+			Code           string `json:"code" binding:"required_without=RecoveryCode"`
+			RecoveryCode   string `json:"recovery_code"`
+			DeviceName     string `json:"device_name"`
+		}
+
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		accessToken, refreshToken, err := a.service.CompleteTwoFactorLogin(req.TwoFactorToken, req.Code, req.RecoveryCode, deviceInfo(c, req.DeviceName))
+		if err != nil {
+			loginFailed(c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"access_token": accessToken, "refresh_token": refreshToken})
+	}
+}
+
+func (a *AuthHandler) EnrollTwoFactor() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		personID, _ := middleware.GetPersonID(c)
+
+		enrollment, err := a.service.EnrollTwoFactor(personID)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, enrollment)
+	}
+}
+
+func (a *AuthHandler) ConfirmTwoFactor() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req struct {
+			Code string `json:"code" binding:"required"`
+		}
+
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		personID, _ := middleware.GetPersonID(c)
+		codes, err := a.service.ConfirmTwoFactor(personID, req.Code, deviceInfo(c, ""))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"recovery_codes": codes})
+	}
+}
+
+func (a *AuthHandler) DisableTwoFactor() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req struct {
+			Password     string `json:"password" binding:"required"`
+			Code         string `json:"code" binding:"required_without=RecoveryCode"`
+			RecoveryCode string `json:"recovery_code"`
+		}
+
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		personID, _ := middleware.GetPersonID(c)
+		if err := a.service.DisableTwoFactor(personID, req.Password, req.Code, req.RecoveryCode, deviceInfo(c, "")); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusNoContent, nil)
+	}
+}
+
+func (a *AuthHandler) RegenerateRecoveryCodes() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req struct {
+			Code string `json:"code" binding:"required"`
+		}
+
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		personID, _ := middleware.GetPersonID(c)
+		codes, err := a.service.RegenerateRecoveryCodes(personID, req.Code, deviceInfo(c, ""))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"recovery_codes": codes})
+	}
+}
+
+func (a *AuthHandler) OIDCLogin() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		authURL, err := a.oidcService.StartLogin(c.Request.Context(), c.Param("provider"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		c.Redirect(http.StatusFound, authURL)
+	}
+}
+
+func (a *AuthHandler) OIDCCallback() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if providerError := c.Query("error"); providerError != "" {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": providerError, "error_description": c.Query("error_description")})
+			return
+		}
+
+		code, state := c.Query("code"), c.Query("state")
+		if code == "" || state == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "code and state are required"})
+			return
+		}
+
+		result, err := a.oidcService.CompleteLogin(c.Request.Context(), c.Param("provider"), code, state, deviceInfo(c, c.Query("device_name")))
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, result)
+	}
+}
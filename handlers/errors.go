@@ -0,0 +1,36 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/yasharya2901/smart_divide/passwordpolicy"
+	"github.com/yasharya2901/smart_divide/services"
+	"gorm.io/gorm"
+)
+
+// errorStatus maps permission and lookup errors to a status code, and any other error to fallback.
+func errorStatus(err error, fallback int) int {
+	switch {
+	case errors.Is(err, services.ErrForbidden):
+		return http.StatusForbidden
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, services.ErrContactTaken):
+		return http.StatusConflict
+	default:
+		return fallback
+	}
+}
+
+// passwordRejected responds with the broken rules if err is a password policy error.
+func passwordRejected(c *gin.Context, err error) bool {
+	var policyErr *passwordpolicy.Error
+	if !errors.As(err, &policyErr) {
+		return false
+	}
+
+	c.JSON(http.StatusBadRequest, gin.H{"error": err.Error(), "violations": policyErr.Violations})
+	return true
+}
@@ -7,15 +7,49 @@ import (
 	"gorm.io/gorm"
 
 	"github.com/gin-gonic/gin"
+	"github.com/yasharya2901/smart_divide/config"
+	"github.com/yasharya2901/smart_divide/middleware"
+	"github.com/yasharya2901/smart_divide/models"
 	"github.com/yasharya2901/smart_divide/services"
+	"github.com/yasharya2901/smart_divide/utils"
 )
 
 type EventHandler struct {
-	service *services.EventService
+	cfg                *config.Config
+	service            *services.EventService
+	placeholderService *services.PlaceholderService
+	friendService      *services.FriendService
 }
 
-func NewEventHandler(db *gorm.DB) *EventHandler {
-	return &EventHandler{service: services.NewEventService(db)}
+func NewEventHandler(db *gorm.DB, cfg *config.Config) *EventHandler {
+	return &EventHandler{
+		cfg:                cfg,
+		service:            services.NewEventService(db),
+		placeholderService: services.NewPlaceholderService(db),
+		friendService:      services.NewFriendService(db),
+	}
+}
+
+type memberResponse struct {
+	ID          uint   `json:"id"`
+	Name        string `json:"name"`
+	Role        string `json:"role"`
+	Placeholder bool   `json:"placeholder"`
+}
+
+// eventResponse is an event with its people reduced to what other members may see. The
+// People field hides the one of the embedded event when encoded.
+type eventResponse struct {
+	models.Event
+	People []publicPersonResponse `json:"People,omitempty"`
+}
+
+func newEventResponse(event *models.Event) eventResponse {
+	res := eventResponse{Event: *event}
+	for _, person := range event.People {
+		res.People = append(res.People, publicPersonResponse{ID: person.ID, Name: person.Name})
+	}
+	return res
 }
 
 func (h *EventHandler) AddPersonToEvent() gin.HandlerFunc {
@@ -32,7 +66,28 @@ func (h *EventHandler) AddPersonToEvent() gin.HandlerFunc {
 			return
 		}
 
-		if err := h.service.AddPersonToEvent(uint(eventID), uint(personID)); err != nil {
+		var input struct {
+			Role string `json:"role"`
+		}
+
+		// The body is optional, people are added as members by default
+		if c.Request.ContentLength > 0 {
+			if err := c.ShouldBindJSON(&input); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+		}
+		if input.Role == "" {
+			input.Role = services.RoleMember
+		}
+
+		actorID, _ := middleware.GetPersonID(c)
+		if err := h.service.AuthorizeRoleChange(uint(eventID), actorID, uint(personID), input.Role); err != nil {
+			c.JSON(errorStatus(err, http.StatusInternalServerError), gin.H{"error": err.Error()})
+			return
+		}
+
+		if err := h.service.AddPersonToEvent(uint(eventID), uint(personID), input.Role); err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 			return
 		}
@@ -43,7 +98,9 @@ func (h *EventHandler) AddPersonToEvent() gin.HandlerFunc {
 
 func (h *EventHandler) GetEvents() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		events, err := h.service.GetEvents()
+		personID, _ := middleware.GetPersonID(c)
+
+		events, err := h.service.GetEvents(personID)
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 			return
@@ -61,20 +118,28 @@ func (h *EventHandler) GetEvent() gin.HandlerFunc {
 			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ID"})
 			return
 		}
+
+		personID, _ := middleware.GetPersonID(c)
+		if err := h.service.Authorize(uint(id), personID, services.PermissionViewEvent); err != nil {
+			c.JSON(errorStatus(err, http.StatusInternalServerError), gin.H{"error": err.Error()})
+			return
+		}
+
 		preloadPerson := c.Query("preloadPerson") == "true"
 		event, err := h.service.GetEventByID(uint(id), preloadPerson)
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 			return
 		}
-		c.JSON(http.StatusOK, gin.H{"event": event})
+		c.JSON(http.StatusOK, gin.H{"event": newEventResponse(event)})
 	}
 }
 
 func (h *EventHandler) CreateEvent() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		var input struct {
-			Name string `json:"name" binding:"required"`
+			Name     string `json:"name" binding:"required"`
+			Currency string `json:"currency"`
 		}
 
 		if err := c.ShouldBindJSON(&input); err != nil {
@@ -82,9 +147,15 @@ func (h *EventHandler) CreateEvent() gin.HandlerFunc {
 			return
 		}
 
-		event, err := h.service.CreateEvent(input.Name)
+		// Amounts of the event are in one currency, picked when it's created
+		if input.Currency == "" {
+			input.Currency = h.cfg.Money.DefaultCurrency
+		}
+
+		personID, _ := middleware.GetPersonID(c)
+		event, err := h.service.CreateEvent(input.Name, input.Currency, personID)
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 			return
 		}
 
@@ -115,6 +186,12 @@ func (h *EventHandler) UpdateEvent() gin.HandlerFunc {
 			return
 		}
 
+		personID, _ := middleware.GetPersonID(c)
+		if err := h.service.Authorize(uint(id), personID, services.PermissionUpdateEvent); err != nil {
+			c.JSON(errorStatus(err, http.StatusInternalServerError), gin.H{"error": err.Error()})
+			return
+		}
+
 		event, err := h.service.UpdateEvent(uint(id), input.Name)
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
@@ -134,6 +211,12 @@ func (h *EventHandler) DeleteEvent() gin.HandlerFunc {
 			return
 		}
 
+		personID, _ := middleware.GetPersonID(c)
+		if err := h.service.Authorize(uint(id), personID, services.PermissionDeleteEvent); err != nil {
+			c.JSON(errorStatus(err, http.StatusInternalServerError), gin.H{"error": err.Error()})
+			return
+		}
+
 		err = h.service.DeleteEvent(uint(id))
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
@@ -144,6 +227,120 @@ func (h *EventHandler) DeleteEvent() gin.HandlerFunc {
 	}
 }
 
+func (h *EventHandler) GetMembers() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		eventID, err := strconv.ParseUint(c.Param("id"), 10, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid event ID"})
+			return
+		}
+
+		personID, _ := middleware.GetPersonID(c)
+		if err := h.service.Authorize(uint(eventID), personID, services.PermissionViewEvent); err != nil {
+			c.JSON(errorStatus(err, http.StatusInternalServerError), gin.H{"error": err.Error()})
+			return
+		}
+
+		members, err := h.service.GetMembers(uint(eventID))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		res := make([]memberResponse, len(members))
+		for i, member := range members {
+			res[i] = memberResponse{ID: member.ID, Name: member.Name, Role: member.Role, Placeholder: member.Placeholder}
+		}
+
+		c.JSON(http.StatusOK, gin.H{"members": res})
+	}
+}
+
+// AddPlaceholder adds a member who has no account yet, by name and optionally with an
+// email or contact number they can later claim the placeholder with.
+func (h *EventHandler) AddPlaceholder() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		eventID, err := strconv.ParseUint(c.Param("id"), 10, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid event ID"})
+			return
+		}
+
+		var input struct {
+			Name    string `json:"name" binding:"required"`
+			Contact string `json:"contact"`
+			Email   string `json:"email"`
+		}
+
+		if err := c.ShouldBindJSON(&input); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		if input.Email != "" && !utils.ValidateEmail(input.Email) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid email"})
+			return
+		}
+
+		if input.Contact != "" && !utils.ValidatePhoneNumber(input.Contact) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid phone number"})
+			return
+		}
+
+		personID, _ := middleware.GetPersonID(c)
+		if err := h.service.Authorize(uint(eventID), personID, services.PermissionManageMembers); err != nil {
+			c.JSON(errorStatus(err, http.StatusInternalServerError), gin.H{"error": err.Error()})
+			return
+		}
+
+		placeholder, err := h.placeholderService.CreatePlaceholder(uint(eventID), input.Name, input.Contact, input.Email)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusCreated, memberResponse{ID: placeholder.ID, Name: placeholder.Name, Role: services.RoleMember, Placeholder: true})
+	}
+}
+
+func (h *EventHandler) UpdateMemberRole() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		eventID, err := strconv.ParseUint(c.Param("id"), 10, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid event ID"})
+			return
+		}
+
+		personID, err := strconv.ParseUint(c.Param("personId"), 10, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid person ID"})
+			return
+		}
+
+		var input struct {
+			Role string `json:"role" binding:"required"`
+		}
+
+		if err := c.ShouldBindJSON(&input); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		actorID, _ := middleware.GetPersonID(c)
+		if err := h.service.AuthorizeRoleChange(uint(eventID), actorID, uint(personID), input.Role); err != nil {
+			c.JSON(errorStatus(err, http.StatusInternalServerError), gin.H{"error": err.Error()})
+			return
+		}
+
+		if err := h.service.UpdateMemberRole(uint(eventID), uint(personID), input.Role); err != nil {
+			c.JSON(errorStatus(err, http.StatusBadRequest), gin.H{"error": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"role": input.Role})
+	}
+}
+
 func (h *EventHandler) RemovePersonFromEvent() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		eventID, err := strconv.ParseUint(c.Param("id"), 10, 64)
@@ -158,11 +355,43 @@ func (h *EventHandler) RemovePersonFromEvent() gin.HandlerFunc {
 			return
 		}
 
+		actorID, _ := middleware.GetPersonID(c)
+		if err := h.service.AuthorizeRoleChange(uint(eventID), actorID, uint(personID), ""); err != nil {
+			c.JSON(errorStatus(err, http.StatusInternalServerError), gin.H{"error": err.Error()})
+			return
+		}
+
 		if err := h.service.RemovePersonFromEvent(uint(eventID), uint(personID)); err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			c.JSON(errorStatus(err, http.StatusBadRequest), gin.H{"error": err.Error()})
 			return
 		}
 
 		c.JSON(http.StatusNoContent, nil)
 	}
 }
+
+// GetMemberSuggestions returns the friends of the logged in person who are not in the event
+// yet, to pick from when adding members.
+func (h *EventHandler) GetMemberSuggestions() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		eventID, err := strconv.ParseUint(c.Param("id"), 10, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid event ID"})
+			return
+		}
+
+		personID, _ := middleware.GetPersonID(c)
+		if err := h.service.Authorize(uint(eventID), personID, services.PermissionManageMembers); err != nil {
+			c.JSON(errorStatus(err, http.StatusInternalServerError), gin.H{"error": err.Error()})
+			return
+		}
+
+		suggestions, err := h.friendService.SuggestMembers(uint(eventID), personID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"suggestions": suggestions})
+	}
+}
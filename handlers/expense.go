@@ -5,21 +5,37 @@ import (
 	"strconv"
 
 	"github.com/gin-gonic/gin"
+	"github.com/yasharya2901/smart_divide/middleware"
+	"github.com/yasharya2901/smart_divide/money"
 	"github.com/yasharya2901/smart_divide/services"
 	"gorm.io/gorm"
 )
 
 type ExpenseHandler struct {
-	service *services.ExpenseService
+	service      *services.ExpenseService
+	eventService *services.EventService
 }
 
 func NewExpenseHandler(db *gorm.DB) *ExpenseHandler {
-	return &ExpenseHandler{service: services.NewExpenseService(db)}
+	return &ExpenseHandler{service: services.NewExpenseService(db), eventService: services.NewEventService(db)}
+}
+
+// authorize checks that the authenticated person may view, or edit, the expense.
+// It writes the error response and returns false otherwise.
+func (h *ExpenseHandler) authorize(c *gin.Context, expenseID uint, edit bool) bool {
+	personID, _ := middleware.GetPersonID(c)
+	if err := h.service.AuthorizeExpense(expenseID, personID, edit); err != nil {
+		c.JSON(errorStatus(err, http.StatusInternalServerError), gin.H{"error": err.Error()})
+		return false
+	}
+	return true
 }
 
 func (h *ExpenseHandler) GetExpenses() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		expenses, err := h.service.GetExpenses()
+		personID, _ := middleware.GetPersonID(c)
+
+		expenses, err := h.service.GetExpenses(personID)
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 			return
@@ -32,10 +48,10 @@ func (h *ExpenseHandler) GetExpenses() gin.HandlerFunc {
 func (h *ExpenseHandler) CreateExpense() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		var req struct {
-			Name        string  `json:"name" binding:"required"`
-			TotalAmount float64 `json:"total_amount" binding:"required"`
-			EventID     uint    `json:"event_id" binding:"required"`
-			PaidByID    uint    `json:"paid_by_id" binding:"required"`
+			Name        string        `json:"name" binding:"required"`
+			TotalAmount money.Decimal `json:"total_amount" binding:"required"` // Decimal string in the event's currency, e.g. "33.33"
+			EventID     uint          `json:"event_id" binding:"required"`
+			PaidByID    uint          `json:"paid_by_id"`
 		}
 
 		if err := c.ShouldBindJSON(&req); err != nil {
@@ -43,9 +59,20 @@ func (h *ExpenseHandler) CreateExpense() gin.HandlerFunc {
 			return
 		}
 
-		expense, err := h.service.CreateExpense(req.Name, req.TotalAmount, req.EventID, req.PaidByID)
+		personID, _ := middleware.GetPersonID(c)
+		if err := h.eventService.Authorize(req.EventID, personID, services.PermissionCreateExpense); err != nil {
+			c.JSON(errorStatus(err, http.StatusInternalServerError), gin.H{"error": err.Error()})
+			return
+		}
+
+		// Default the payer to the authenticated person
+		if req.PaidByID == 0 {
+			req.PaidByID = personID
+		}
+
+		expense, err := h.service.CreateExpense(req.Name, req.TotalAmount, req.EventID, req.PaidByID, personID)
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			c.JSON(errorStatus(err, http.StatusBadRequest), gin.H{"error": err.Error()})
 			return
 		}
 
@@ -63,6 +90,10 @@ func (h *ExpenseHandler) GetExpense() gin.HandlerFunc {
 			return
 		}
 
+		if !h.authorize(c, uint(uID), false) {
+			return
+		}
+
 		expense, err := h.service.GetExpenseByID(uint(uID))
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
@@ -84,9 +115,9 @@ func (h *ExpenseHandler) UpdateExpense() gin.HandlerFunc {
 		}
 
 		var req struct {
-			Name        string  `json:"name"`
-			TotalAmount float64 `json:"total_amount"`
-			PaidByID    uint    `json:"paid_by_id"`
+			Name        string        `json:"name"`
+			TotalAmount money.Decimal `json:"total_amount"`
+			PaidByID    uint          `json:"paid_by_id"`
 		}
 
 		if err := c.ShouldBindJSON(&req); err != nil {
@@ -94,9 +125,13 @@ func (h *ExpenseHandler) UpdateExpense() gin.HandlerFunc {
 			return
 		}
 
+		if !h.authorize(c, uint(expenseID), true) {
+			return
+		}
+
 		expense, err := h.service.UpdateExpense(uint(expenseID), req.Name, req.TotalAmount, req.PaidByID)
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			c.JSON(errorStatus(err, http.StatusBadRequest), gin.H{"error": err.Error()})
 			return
 		}
 
@@ -114,6 +149,10 @@ func (h *ExpenseHandler) DeleteExpense() gin.HandlerFunc {
 			return
 		}
 
+		if !h.authorize(c, uint(expenseID), true) {
+			return
+		}
+
 		err = h.service.DeleteExpense(uint(expenseID))
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
@@ -134,6 +173,10 @@ func (h *ExpenseHandler) GetParticipants() gin.HandlerFunc {
 			return
 		}
 
+		if !h.authorize(c, uint(expenseID), false) {
+			return
+		}
+
 		participants, err := h.service.GetExpensePeople(uint(expenseID))
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
@@ -163,6 +206,10 @@ func (h *ExpenseHandler) AddParticipant() gin.HandlerFunc {
 			return
 		}
 
+		if !h.authorize(c, uint(expenseID), true) {
+			return
+		}
+
 		exp, err := h.service.AddExpensePerson(uint(expenseID), uint(req.PersonID))
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
@@ -184,8 +231,8 @@ func (h *ExpenseHandler) UpdateParticipant() gin.HandlerFunc {
 		}
 
 		var req struct {
-			PaidAmount float64 `json:"paid_amount"`
-			OwedAmount float64 `json:"owed_amount"`
+			PaidAmount money.Decimal `json:"paid_amount"`
+			OwedAmount money.Decimal `json:"owed_amount"`
 		}
 
 		if err := c.ShouldBindJSON(&req); err != nil {
@@ -193,7 +240,7 @@ func (h *ExpenseHandler) UpdateParticipant() gin.HandlerFunc {
 			return
 		}
 
-		participantID := c.Param("participant_id")
+		participantID := c.Param("personId")
 
 		pID, err := strconv.ParseUint(participantID, 10, 64)
 		if err != nil {
@@ -201,9 +248,13 @@ func (h *ExpenseHandler) UpdateParticipant() gin.HandlerFunc {
 			return
 		}
 
+		if !h.authorize(c, uint(expenseID), true) {
+			return
+		}
+
 		exp, err := h.service.UpdateExpensePerson(uint(expenseID), uint(pID), req.PaidAmount, req.OwedAmount)
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			c.JSON(errorStatus(err, http.StatusBadRequest), gin.H{"error": err.Error()})
 			return
 		}
 
@@ -221,7 +272,7 @@ func (h *ExpenseHandler) RemoveParticipant() gin.HandlerFunc {
 			return
 		}
 
-		participantID := c.Param("participant_id")
+		participantID := c.Param("personId")
 
 		pID, err := strconv.ParseUint(participantID, 10, 64)
 		if err != nil {
@@ -229,6 +280,10 @@ func (h *ExpenseHandler) RemoveParticipant() gin.HandlerFunc {
 			return
 		}
 
+		if !h.authorize(c, uint(expenseID), true) {
+			return
+		}
+
 		err = h.service.DeleteExpensePerson(uint(expenseID), uint(pID))
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
@@ -239,6 +294,30 @@ func (h *ExpenseHandler) RemoveParticipant() gin.HandlerFunc {
 	}
 }
 
+func (h *ExpenseHandler) SplitExpenseEvenly() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.Param("id")
+
+		expenseID, err := strconv.ParseUint(id, 10, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid Expense ID"})
+			return
+		}
+
+		if !h.authorize(c, uint(expenseID), true) {
+			return
+		}
+
+		participants, err := h.service.SplitExpenseEvenly(uint(expenseID))
+		if err != nil {
+			c.JSON(errorStatus(err, http.StatusBadRequest), gin.H{"error": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"participants": participants})
+	}
+}
+
 func (h *ExpenseHandler) CheckExpenseConsistency() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		id := c.Param("id")
@@ -249,6 +328,10 @@ func (h *ExpenseHandler) CheckExpenseConsistency() gin.HandlerFunc {
 			return
 		}
 
+		if !h.authorize(c, uint(expenseID), false) {
+			return
+		}
+
 		err = h.service.CheckExpenseConsistency(uint(expenseID))
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"success": "false", "error": err.Error()})
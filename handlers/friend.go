@@ -0,0 +1,125 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/yasharya2901/smart_divide/middleware"
+	"github.com/yasharya2901/smart_divide/services"
+	"gorm.io/gorm"
+)
+
+type FriendHandler struct {
+	service *services.FriendService
+}
+
+func NewFriendHandler(db *gorm.DB) *FriendHandler {
+	return &FriendHandler{service: services.NewFriendService(db)}
+}
+
+func (h *FriendHandler) GetFriends() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		personID, _ := middleware.GetPersonID(c)
+
+		friends, err := h.service.GetFriends(personID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"friends": friends})
+	}
+}
+
+func (h *FriendHandler) RemoveFriend() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		friendID, err := strconv.ParseUint(c.Param("personId"), 10, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid person ID"})
+			return
+		}
+
+		personID, _ := middleware.GetPersonID(c)
+		if err := h.service.RemoveFriend(personID, uint(friendID)); err != nil {
+			c.JSON(errorStatus(err, http.StatusInternalServerError), gin.H{"error": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusNoContent, nil)
+	}
+}
+
+func (h *FriendHandler) GetRequests() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		personID, _ := middleware.GetPersonID(c)
+
+		requests, err := h.service.GetRequests(personID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"requests": requests})
+	}
+}
+
+// SendRequest sends a friend request, or accepts the one the other person already sent.
+func (h *FriendHandler) SendRequest() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req struct {
+			PersonID uint `json:"person_id" binding:"required"`
+		}
+
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		personID, _ := middleware.GetPersonID(c)
+		friendship, err := h.service.SendRequest(personID, req.PersonID)
+		if err != nil {
+			c.JSON(errorStatus(err, http.StatusBadRequest), gin.H{"error": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusCreated, gin.H{"id": friendship.ID, "accepted": friendship.AcceptedAt != nil})
+	}
+}
+
+func (h *FriendHandler) AcceptRequest() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		requestID, err := strconv.ParseUint(c.Param("id"), 10, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request ID"})
+			return
+		}
+
+		personID, _ := middleware.GetPersonID(c)
+		if _, err := h.service.AcceptRequest(personID, uint(requestID)); err != nil {
+			c.JSON(errorStatus(err, http.StatusInternalServerError), gin.H{"error": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "Friend request accepted"})
+	}
+}
+
+// DeleteRequest declines a received friend request or cancels a sent one.
+func (h *FriendHandler) DeleteRequest() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		requestID, err := strconv.ParseUint(c.Param("id"), 10, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request ID"})
+			return
+		}
+
+		personID, _ := middleware.GetPersonID(c)
+		if err := h.service.DeleteRequest(personID, uint(requestID)); err != nil {
+			c.JSON(errorStatus(err, http.StatusInternalServerError), gin.H{"error": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusNoContent, nil)
+	}
+}
@@ -0,0 +1,216 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/yasharya2901/smart_divide/config"
+	"github.com/yasharya2901/smart_divide/middleware"
+	"github.com/yasharya2901/smart_divide/services"
+	"github.com/yasharya2901/smart_divide/utils"
+	"gorm.io/gorm"
+)
+
+const (
+	defaultQRCodeSize = 256
+	maxQRCodeSize     = 1024
+)
+
+type InvitationHandler struct {
+	service *services.InvitationService
+}
+
+func NewInvitationHandler(db *gorm.DB, cfg *config.Config) *InvitationHandler {
+	return &InvitationHandler{service: services.NewInvitationService(db, cfg)}
+}
+
+type invitationResponse struct {
+	ID        uint       `json:"id"`
+	Role      string     `json:"role"`
+	Token     string     `json:"token"`
+	Link      string     `json:"link"`
+	MaxUses   int        `json:"max_uses"`
+	Uses      int        `json:"uses"`
+	CreatedAt time.Time  `json:"created_at"`
+	ExpiresAt *time.Time `json:"expires_at"`
+}
+
+func newInvitationResponse(invitation *services.Invitation) invitationResponse {
+	return invitationResponse{
+		ID:        invitation.ID,
+		Role:      invitation.Role,
+		Token:     invitation.Token,
+		Link:      invitation.Link,
+		MaxUses:   invitation.MaxUses,
+		Uses:      invitation.Uses,
+		CreatedAt: invitation.CreatedAt,
+		ExpiresAt: invitation.ExpiresAt,
+	}
+}
+
+func (h *InvitationHandler) CreateInvitation() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		eventID, err := strconv.ParseUint(c.Param("id"), 10, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid event ID"})
+			return
+		}
+
+		var input struct {
+			Role      string     `json:"role"`
+			MaxUses   int        `json:"max_uses"`
+			ExpiresAt *time.Time `json:"expires_at"`
+		}
+
+		// The body is optional, invitations make people members by default
+		if c.Request.ContentLength > 0 {
+			if err := c.ShouldBindJSON(&input); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+		}
+		if input.Role == "" {
+			input.Role = services.RoleMember
+		}
+
+		personID, _ := middleware.GetPersonID(c)
+		invitation, err := h.service.CreateInvitation(uint(eventID), personID, input.Role, input.MaxUses, input.ExpiresAt)
+		if err != nil {
+			c.JSON(errorStatus(err, http.StatusBadRequest), gin.H{"error": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusCreated, newInvitationResponse(invitation))
+	}
+}
+
+func (h *InvitationHandler) GetInvitations() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		eventID, err := strconv.ParseUint(c.Param("id"), 10, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid event ID"})
+			return
+		}
+
+		personID, _ := middleware.GetPersonID(c)
+		invitations, err := h.service.GetInvitations(uint(eventID), personID)
+		if err != nil {
+			c.JSON(errorStatus(err, http.StatusInternalServerError), gin.H{"error": err.Error()})
+			return
+		}
+
+		res := make([]invitationResponse, len(invitations))
+		for i := range invitations {
+			res[i] = newInvitationResponse(&invitations[i])
+		}
+
+		c.JSON(http.StatusOK, gin.H{"invitations": res})
+	}
+}
+
+func (h *InvitationHandler) RevokeInvitation() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		eventID, err := strconv.ParseUint(c.Param("id"), 10, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid event ID"})
+			return
+		}
+
+		invitationID, err := strconv.ParseUint(c.Param("invitationId"), 10, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid invitation ID"})
+			return
+		}
+
+		personID, _ := middleware.GetPersonID(c)
+		if err := h.service.RevokeInvitation(uint(eventID), uint(invitationID), personID); err != nil {
+			c.JSON(errorStatus(err, http.StatusInternalServerError), gin.H{"error": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusNoContent, nil)
+	}
+}
+
+// GetInvitationQRCode returns a QR code of the invitation link, to be scanned to join in
+// person. The format query parameter selects "png" (the default) or "svg", and size the
+// width of a PNG in pixels.
+func (h *InvitationHandler) GetInvitationQRCode() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		eventID, err := strconv.ParseUint(c.Param("id"), 10, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid event ID"})
+			return
+		}
+
+		invitationID, err := strconv.ParseUint(c.Param("invitationId"), 10, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid invitation ID"})
+			return
+		}
+
+		size := defaultQRCodeSize
+		if raw := c.Query("size"); raw != "" {
+			size, err = strconv.Atoi(raw)
+			if err != nil || size < 64 || size > maxQRCodeSize {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "size must be between 64 and 1024"})
+				return
+			}
+		}
+
+		format := c.DefaultQuery("format", "png")
+		if format != "png" && format != "svg" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "format must be png or svg"})
+			return
+		}
+
+		personID, _ := middleware.GetPersonID(c)
+		invitation, err := h.service.GetInvitation(uint(eventID), uint(invitationID), personID)
+		if err != nil {
+			c.JSON(errorStatus(err, http.StatusInternalServerError), gin.H{"error": err.Error()})
+			return
+		}
+
+		if format == "svg" {
+			image, err := utils.QRCodeSVG(invitation.Link)
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				return
+			}
+			c.Data(http.StatusOK, "image/svg+xml", image)
+			return
+		}
+
+		image, err := utils.QRCodePNG(invitation.Link, size)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.Data(http.StatusOK, "image/png", image)
+	}
+}
+
+// RedeemInvitation adds the logged in person to the event of an invitation.
+func (h *InvitationHandler) RedeemInvitation() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var input struct {
+			Token string `json:"token" binding:"required"`
+		}
+
+		if err := c.ShouldBindJSON(&input); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		personID, _ := middleware.GetPersonID(c)
+		event, err := h.service.RedeemInvitation(input.Token, personID)
+		if err != nil {
+			c.JSON(errorStatus(err, http.StatusBadRequest), gin.H{"error": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"event": event})
+	}
+}
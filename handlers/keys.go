@@ -0,0 +1,89 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/yasharya2901/smart_divide/keyring"
+	"github.com/yasharya2901/smart_divide/middleware"
+	"github.com/yasharya2901/smart_divide/models"
+	"github.com/yasharya2901/smart_divide/services"
+)
+
+type KeyHandler struct {
+	keyRing  *keyring.KeyRing
+	auditLog *services.AuditLog
+}
+
+func NewKeyHandler(keyRing *keyring.KeyRing, auditLog *services.AuditLog) *KeyHandler {
+	return &KeyHandler{keyRing: keyRing, auditLog: auditLog}
+}
+
+type signingKeyResponse struct {
+	KeyID       string     `json:"kid"`
+	Algorithm   string     `json:"alg"`
+	CreatedAt   time.Time  `json:"created_at"`
+	RetiredAt   *time.Time `json:"retired_at"`
+	VerifyUntil *time.Time `json:"verify_until"`
+}
+
+func newSigningKeyResponse(key *models.SigningKey) signingKeyResponse {
+	return signingKeyResponse{
+		KeyID:       key.KeyID,
+		Algorithm:   key.Algorithm,
+		CreatedAt:   key.CreatedAt,
+		RetiredAt:   key.RetiredAt,
+		VerifyUntil: key.VerifyUntil,
+	}
+}
+
+// JWKS serves the public keys access tokens can be verified with.
+func (h *KeyHandler) JWKS() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		jwks, err := h.keyRing.JWKS()
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		c.Header("Cache-Control", "public, max-age=300")
+		c.JSON(http.StatusOK, jwks)
+	}
+}
+
+func (h *KeyHandler) GetKeys() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		keys, err := h.keyRing.Keys()
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		res := make([]signingKeyResponse, len(keys))
+		for i := range keys {
+			res[i] = newSigningKeyResponse(&keys[i])
+		}
+
+		c.JSON(http.StatusOK, gin.H{"algorithm": h.keyRing.Algorithm(), "keys": res})
+	}
+}
+
+func (h *KeyHandler) RotateKeys() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		adminID, _ := middleware.GetPersonID(c)
+		key, err := h.keyRing.Rotate()
+		h.auditLog.Record(services.AuditEntry{ActorID: adminID, Action: services.AuditSigningKeyRotated, Err: err, Device: deviceInfo(c, "")})
+		if errors.Is(err, keyring.ErrRotationUnsupported) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusCreated, newSigningKeyResponse(key))
+	}
+}
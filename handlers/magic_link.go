@@ -0,0 +1,83 @@
+package handlers
+
+import (
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/yasharya2901/smart_divide/services"
+)
+
+// magicLinkDeviceCookie binds a magic link to the browser that requested it.
+const magicLinkDeviceCookie = "magic_link_device"
+
+// setMagicLinkCookie stores the device secret of a magic link in an HTTP only cookie, limited
+// to the auth routes. Max age 0 keeps it for the browser session, a negative one deletes it.
+func setMagicLinkCookie(c *gin.Context, value string, maxAge int) {
+	secure := c.Request.TLS != nil || c.GetHeader("X-Forwarded-Proto") == "https"
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(magicLinkDeviceCookie, value, maxAge, "/auth", "", secure, true)
+}
+
+func (a *AuthHandler) RequestMagicLink() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req struct {
+			Email string `json:"email" binding:"required,email"`
+		}
+
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		deviceToken, err := a.service.RequestMagicLink(req.Email, deviceInfo(c, ""))
+		var rateLimited *services.RateLimitedError
+		if errors.As(err, &rateLimited) {
+			tooManyRequests(c, rateLimited.Until, err)
+			return
+		}
+		// The response is the same whether or not the email is registered
+		if err != nil {
+			log.Println("failed to request magic link:", err)
+		}
+
+		// Browsers keep the device secret in a cookie, other clients send it back themselves
+		if deviceToken != "" {
+			setMagicLinkCookie(c, deviceToken, 0)
+		}
+
+		c.JSON(http.StatusAccepted, gin.H{
+			"message":      "If the email is registered, a login link has been sent",
+			"device_token": deviceToken,
+		})
+	}
+}
+
+func (a *AuthHandler) LoginWithMagicLink() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req struct {
+			Token       string `json:"token" binding:"required"`
+			DeviceToken string `json:"device_token"`
+			DeviceName  string `json:"device_name"`
+		}
+
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		if req.DeviceToken == "" {
+			req.DeviceToken, _ = c.Cookie(magicLinkDeviceCookie)
+		}
+
+		result, err := a.service.LoginWithMagicLink(req.Token, req.DeviceToken, deviceInfo(c, req.DeviceName))
+		if err != nil {
+			loginFailed(c, err)
+			return
+		}
+
+		setMagicLinkCookie(c, "", -1)
+		c.JSON(http.StatusOK, result)
+	}
+}
@@ -0,0 +1,184 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/yasharya2901/smart_divide/middleware"
+	"github.com/yasharya2901/smart_divide/models"
+	"github.com/yasharya2901/smart_divide/services"
+)
+
+type passkeyResponse struct {
+	ID         uint       `json:"id"`
+	Nickname   string     `json:"nickname"`
+	Transports []string   `json:"transports"`
+	Synced     bool       `json:"synced"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+}
+
+func newPasskeyResponse(passkey *models.WebAuthnCredential) passkeyResponse {
+	return passkeyResponse{
+		ID:         passkey.ID,
+		Nickname:   passkey.Nickname,
+		Transports: strings.Fields(passkey.Transports),
+		Synced:     passkey.BackupState,
+		CreatedAt:  passkey.CreatedAt,
+		LastUsedAt: passkey.LastUsedAt,
+	}
+}
+
+// passkeyStatus responds with 501 when passkeys are not configured on the server.
+func passkeyStatus(err error, fallback int) int {
+	if errors.Is(err, services.ErrPasskeysDisabled) {
+		return http.StatusNotImplemented
+	}
+	return errorStatus(err, fallback)
+}
+
+func (a *AuthHandler) BeginPasskeyRegistration() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		personID, _ := middleware.GetPersonID(c)
+
+		options, sessionToken, err := a.passkeyService.BeginRegistration(personID)
+		if err != nil {
+			c.JSON(passkeyStatus(err, http.StatusInternalServerError), gin.H{"error": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"options": options, "session_token": sessionToken})
+	}
+}
+
+func (a *AuthHandler) FinishPasskeyRegistration() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req struct {
+			SessionToken string          `json:"session_token" binding:"required"`
+			Nickname     string          `json:"nickname" binding:"max=100"`
+			Credential   json.RawMessage `json:"credential" binding:"required"`
+		}
+
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		personID, _ := middleware.GetPersonID(c)
+		passkey, err := a.passkeyService.FinishRegistration(personID, req.SessionToken, req.Credential, req.Nickname, deviceInfo(c, ""))
+		if err != nil {
+			c.JSON(passkeyStatus(err, http.StatusBadRequest), gin.H{"error": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusCreated, gin.H{"passkey": newPasskeyResponse(passkey)})
+	}
+}
+
+func (a *AuthHandler) BeginPasskeyLogin() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		options, sessionToken, err := a.passkeyService.BeginLogin()
+		if err != nil {
+			c.JSON(passkeyStatus(err, http.StatusInternalServerError), gin.H{"error": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"options": options, "session_token": sessionToken})
+	}
+}
+
+func (a *AuthHandler) FinishPasskeyLogin() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req struct {
+			SessionToken string          `json:"session_token" binding:"required"`
+			DeviceName   string          `json:"device_name"`
+			Credential   json.RawMessage `json:"credential" binding:"required"`
+		}
+
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		result, err := a.passkeyService.FinishLogin(req.SessionToken, req.Credential, deviceInfo(c, req.DeviceName))
+		if err != nil {
+			if errors.Is(err, services.ErrPasskeysDisabled) {
+				c.JSON(http.StatusNotImplemented, gin.H{"error": err.Error()})
+				return
+			}
+			loginFailed(c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, result)
+	}
+}
+
+func (a *AuthHandler) GetPasskeys() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		personID, _ := middleware.GetPersonID(c)
+
+		passkeys, err := a.passkeyService.GetPasskeys(personID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		res := make([]passkeyResponse, len(passkeys))
+		for i := range passkeys {
+			res[i] = newPasskeyResponse(&passkeys[i])
+		}
+
+		c.JSON(http.StatusOK, gin.H{"passkeys": res})
+	}
+}
+
+func (a *AuthHandler) RenamePasskey() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		passkeyID, err := strconv.ParseUint(c.Param("id"), 10, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid passkey ID"})
+			return
+		}
+
+		var req struct {
+			Nickname string `json:"nickname" binding:"required,max=100"`
+		}
+
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		personID, _ := middleware.GetPersonID(c)
+		if err := a.passkeyService.RenamePasskey(personID, uint(passkeyID), req.Nickname); err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusNoContent, nil)
+	}
+}
+
+func (a *AuthHandler) DeletePasskey() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		passkeyID, err := strconv.ParseUint(c.Param("id"), 10, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid passkey ID"})
+			return
+		}
+
+		personID, _ := middleware.GetPersonID(c)
+		if err := a.passkeyService.DeletePasskey(personID, uint(passkeyID), deviceInfo(c, "")); err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusNoContent, nil)
+	}
+}
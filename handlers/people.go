@@ -1,28 +1,41 @@
 package handlers
 
 import (
+	"errors"
 	"net/http"
 	"strconv"
-	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/yasharya2901/smart_divide/config"
+	"github.com/yasharya2901/smart_divide/mailer"
+	"github.com/yasharya2901/smart_divide/middleware"
+	"github.com/yasharya2901/smart_divide/passwordhash"
 	"github.com/yasharya2901/smart_divide/services"
 	"gorm.io/gorm"
 )
 
 type PeopleHandler struct {
-	service *services.PeopleService
+	service          *services.PeopleService
+	discoveryService *services.DiscoveryService
 }
 
-func NewPeopleHandler(db *gorm.DB) *PeopleHandler {
-	return &PeopleHandler{service: services.NewPeopleService(db)}
+func NewPeopleHandler(db *gorm.DB, cfg *config.Config, mail mailer.Mailer, passwords *passwordhash.Manager) *PeopleHandler {
+	return &PeopleHandler{service: services.NewPeopleService(db, cfg, mail, passwords), discoveryService: services.NewDiscoveryService(db)}
 }
 
 type response struct {
-	ID      uint   `json:"id"`
-	Name    string `json:"name"`
-	Contact string `json:"contact"`
-	Email   string `json:"email"`
+	ID           uint   `json:"id"`
+	Name         string `json:"name"`
+	Contact      string `json:"contact"`
+	Email        string `json:"email"`
+	PendingEmail string `json:"pending_email,omitempty"`
+}
+
+// publicPersonResponse is what anyone may see of a person, so accounts can't be listed
+// with their contact details by walking the IDs.
+type publicPersonResponse struct {
+	ID   uint   `json:"id"`
+	Name string `json:"name"`
 }
 
 func (p *PeopleHandler) CreatePerson() gin.HandlerFunc {
@@ -44,7 +57,7 @@ func (p *PeopleHandler) CreatePerson() gin.HandlerFunc {
 			return
 		}
 
-		c.JSON(http.StatusCreated, gin.H{"person": response{person.ID, person.Name, person.Contact, person.Email}})
+		c.JSON(http.StatusCreated, gin.H{"person": response{ID: person.ID, Name: person.Name, Contact: person.Contact, Email: person.Email}})
 	}
 }
 
@@ -60,10 +73,17 @@ func (p *PeopleHandler) GetPerson() gin.HandlerFunc {
 
 		person, err := p.service.GetPersonByID(uint(personID))
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			c.JSON(errorStatus(err, http.StatusInternalServerError), gin.H{"error": err.Error()})
+			return
 		}
 
-		c.JSON(http.StatusOK, gin.H{"people": person})
+		// Contact details are only shown to the person themselves
+		if currentID, _ := middleware.GetPersonID(c); currentID == person.ID {
+			c.JSON(http.StatusOK, gin.H{"people": response{ID: person.ID, Name: person.Name, Contact: person.Contact, Email: person.Email, PendingEmail: person.PendingEmail}})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"people": publicPersonResponse{ID: person.ID, Name: person.Name}})
 	}
 }
 
@@ -76,6 +96,12 @@ func (p *PeopleHandler) UpdatePerson() gin.HandlerFunc {
 			return
 		}
 
+		// Only the authenticated person can update their own profile
+		if currentID, _ := middleware.GetPersonID(c); currentID != uint(peopleID) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "You can only update your own profile"})
+			return
+		}
+
 		var req struct {
 			Name    string `json:"name"`
 			Contact string `json:"contact"`
@@ -87,77 +113,44 @@ func (p *PeopleHandler) UpdatePerson() gin.HandlerFunc {
 			return
 		}
 
-		person, err := p.service.UpdatePerson(uint(peopleID), req.Name, req.Contact, req.Email, "", time.Time{})
+		person, err := p.service.UpdatePerson(uint(peopleID), req.Name, req.Contact, req.Email)
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			c.JSON(errorStatus(err, http.StatusInternalServerError), gin.H{"error": err.Error()})
 			return
 		}
 
-		c.JSON(http.StatusOK, gin.H{"person": response{ID: person.ID, Name: person.Name, Contact: person.Contact, Email: person.Email}})
+		c.JSON(http.StatusOK, gin.H{"person": response{ID: person.ID, Name: person.Name, Contact: person.Contact, Email: person.Email, PendingEmail: person.PendingEmail}})
 
 	}
 }
 
-func (p *PeopleHandler) GetPeopleByEmails() gin.HandlerFunc {
+// Discover finds the people who opted into discovery among the caller's contacts. Emails
+// and numbers are sent as hex encoded SHA-256 hashes of their normalized form, the trimmed
+// lowercase email and the number with only the leading plus and digits.
+func (p *PeopleHandler) Discover() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		var request struct {
-			Emails []string `json:"emails" binding:"required"`
+		var req struct {
+			EmailHashes   []string `json:"email_hashes"`
+			ContactHashes []string `json:"contact_hashes"`
 		}
 
-		if err := c.ShouldBindJSON(&request); err != nil {
+		if err := c.ShouldBindJSON(&req); err != nil {
 			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 			return
 		}
 
-		people, err := p.service.GetPeopleByEmails(request.Emails)
+		personID, _ := middleware.GetPersonID(c)
+		people, err := p.discoveryService.Discover(personID, req.EmailHashes, req.ContactHashes)
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-			return
-		}
-
-		res := make([]response, len(people))
-
-		for i, person := range people {
-			res[i] = response{
-				ID:      person.ID,
-				Name:    person.Name,
-				Contact: person.Contact,
-				Email:   person.Email,
+			var rateLimited *services.LookupRateLimitedError
+			if errors.As(err, &rateLimited) {
+				tooManyRequests(c, rateLimited.Until, err)
+				return
 			}
-		}
-
-		c.JSON(http.StatusOK, gin.H{"people": res})
-	}
-}
-
-func (p *PeopleHandler) GetPeopleByContacts() gin.HandlerFunc {
-	return func(c *gin.Context) {
-		var request struct {
-			Contacts []string `json:"contacts" binding:"required"`
-		}
-
-		if err := c.ShouldBindJSON(&request); err != nil {
 			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 			return
 		}
 
-		people, err := p.service.GetPeopleByContacts(request.Contacts)
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-			return
-		}
-
-		res := make([]response, len(people))
-
-		for i, person := range people {
-			res[i] = response{
-				ID:      person.ID,
-				Name:    person.Name,
-				Contact: person.Contact,
-				Email:   person.Email,
-			}
-		}
-
-		c.JSON(http.StatusOK, gin.H{"people": res})
+		c.JSON(http.StatusOK, gin.H{"people": people})
 	}
 }
@@ -0,0 +1,241 @@
+package keyring
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/yasharya2901/smart_divide/config"
+	"github.com/yasharya2901/smart_divide/models"
+	"github.com/yasharya2901/smart_divide/utils"
+	"gorm.io/gorm"
+)
+
+// Supported signing algorithms.
+const (
+	AlgorithmHS256 = "HS256"
+	AlgorithmRS256 = "RS256"
+	AlgorithmEdDSA = "EdDSA"
+)
+
+// reloadInterval is how often the keys are reloaded from the database, so a rotation made
+// by another server instance is picked up.
+const reloadInterval = time.Minute
+
+// ErrRotationUnsupported is returned when rotating the shared HS256 secret.
+var ErrRotationUnsupported = errors.New("key rotation is only supported for asymmetric signing keys")
+
+// KeyRing signs access tokens and verifies them with any of its active keys.
+//
+// With HS256 the ring holds the shared JWT_ACCESS_SECRET. With RS256 or EdDSA the keys
+// are stored in the database: one key signs new tokens, and retired keys keep verifying
+// tokens until the longest lived of them has expired.
+type KeyRing struct {
+	db            *gorm.DB
+	algorithm     string
+	secret        utils.HMACKey
+	tokenLifetime time.Duration // Longest lifetime of a token signed by the ring
+
+	mu       sync.RWMutex
+	signing  *signingKey
+	keys     map[string]*signingKey
+	loadedAt time.Time
+}
+
+// NewFromConfig creates the key ring for the configured signing algorithm, "HS256", "RS256"
+// or "EdDSA". tokenLifetime is the longest lifetime of any token the ring signs, which may be
+// longer than the access token expiry.
+func NewFromConfig(db *gorm.DB, cfg config.JWT, tokenLifetime time.Duration) (*KeyRing, error) {
+	return New(db, cfg.SigningAlgorithm, cfg.AccessSecret, tokenLifetime)
+}
+
+// New creates a key ring. For asymmetric algorithms a signing key is generated if there is none yet.
+func New(db *gorm.DB, algorithm, secret string, tokenLifetime time.Duration) (*KeyRing, error) {
+	kr := &KeyRing{
+		db:            db,
+		algorithm:     algorithm,
+		tokenLifetime: tokenLifetime,
+	}
+
+	switch algorithm {
+	case AlgorithmHS256:
+		if secret == "" {
+			return nil, errors.New("JWT_ACCESS_SECRET is required for HS256")
+		}
+		kr.secret = utils.HMACKey(secret)
+		return kr, nil
+	case AlgorithmRS256, AlgorithmEdDSA:
+	default:
+		return nil, fmt.Errorf("unknown JWT_SIGNING_ALG %q", algorithm)
+	}
+
+	if err := kr.load(); err != nil {
+		return nil, err
+	}
+
+	if kr.signing == nil {
+		if _, err := kr.Rotate(); err != nil {
+			return nil, err
+		}
+	}
+
+	return kr, nil
+}
+
+// Algorithm returns the algorithm new tokens are signed with.
+func (kr *KeyRing) Algorithm() string {
+	return kr.algorithm
+}
+
+// IsAsymmetric reports whether tokens are signed with a private key, so they can be
+// verified by others with the public keys.
+func (kr *KeyRing) IsAsymmetric() bool {
+	return kr.algorithm != AlgorithmHS256
+}
+
+func (kr *KeyRing) SigningKey() (string, jwt.SigningMethod, interface{}, error) {
+	if !kr.IsAsymmetric() {
+		return kr.secret.SigningKey()
+	}
+
+	if err := kr.reloadIfStale(); err != nil {
+		return "", nil, nil, err
+	}
+
+	kr.mu.RLock()
+	defer kr.mu.RUnlock()
+
+	if kr.signing == nil {
+		return "", nil, nil, errors.New("no active signing key")
+	}
+	return kr.signing.KeyID, kr.signing.method(), kr.signing.private, nil
+}
+
+func (kr *KeyRing) VerificationKey(kid string) (interface{}, error) {
+	if !kr.IsAsymmetric() {
+		return kr.secret.VerificationKey(kid)
+	}
+
+	if err := kr.reloadIfStale(); err != nil {
+		return nil, err
+	}
+
+	kr.mu.RLock()
+	key, ok := kr.keys[kid]
+	kr.mu.RUnlock()
+
+	if !ok || !key.canVerify(time.Now()) {
+		return nil, fmt.Errorf("unknown signing key %q", kid)
+	}
+	return key.public, nil
+}
+
+func (kr *KeyRing) Algorithms() []string {
+	return []string{kr.algorithm}
+}
+
+// Keys returns the keys that still verify tokens, the signing key first.
+func (kr *KeyRing) Keys() ([]models.SigningKey, error) {
+	if !kr.IsAsymmetric() {
+		return nil, nil
+	}
+
+	if err := kr.reloadIfStale(); err != nil {
+		return nil, err
+	}
+
+	kr.mu.RLock()
+	defer kr.mu.RUnlock()
+
+	now := time.Now()
+	keys := []models.SigningKey{}
+	if kr.signing != nil {
+		keys = append(keys, kr.signing.SigningKey)
+	}
+	for _, key := range kr.keys {
+		if key != kr.signing && key.canVerify(now) {
+			keys = append(keys, key.SigningKey)
+		}
+	}
+	return keys, nil
+}
+
+// Rotate generates a new signing key and retires the current ones. Retired keys keep
+// verifying tokens until every token they signed has expired.
+func (kr *KeyRing) Rotate() (*models.SigningKey, error) {
+	if !kr.IsAsymmetric() {
+		return nil, ErrRotationUnsupported
+	}
+
+	key, err := generateKey(kr.algorithm)
+	if err != nil {
+		return nil, err
+	}
+
+	// Other instances may sign with the old key until they reload, so it stays valid a little longer
+	now := time.Now()
+	verifyUntil := now.Add(kr.tokenLifetime + reloadInterval)
+
+	err = kr.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&models.SigningKey{}).
+			Where("algorithm = ? AND retired_at IS NULL", kr.algorithm).
+			Updates(map[string]interface{}{"retired_at": now, "verify_until": verifyUntil}).Error; err != nil {
+			return err
+		}
+
+		return tx.Create(&key.SigningKey).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if err := kr.load(); err != nil {
+		return nil, err
+	}
+	return &key.SigningKey, nil
+}
+
+func (kr *KeyRing) reloadIfStale() error {
+	kr.mu.RLock()
+	stale := time.Since(kr.loadedAt) > reloadInterval
+	kr.mu.RUnlock()
+
+	if !stale {
+		return nil
+	}
+	return kr.load()
+}
+
+// load reads the keys of the ring's algorithm that can still verify tokens.
+func (kr *KeyRing) load() error {
+	var records []models.SigningKey
+	if err := kr.db.Where("algorithm = ? AND (retired_at IS NULL OR verify_until > ?)", kr.algorithm, time.Now()).
+		Order("created_at desc").
+		Find(&records).Error; err != nil {
+		return err
+	}
+
+	keys := make(map[string]*signingKey, len(records))
+	var signing *signingKey
+	for _, record := range records {
+		key, err := parseKey(record)
+		if err != nil {
+			return fmt.Errorf("failed to load signing key %q: %w", record.KeyID, err)
+		}
+
+		keys[key.KeyID] = key
+		if signing == nil && key.RetiredAt == nil {
+			signing = key
+		}
+	}
+
+	kr.mu.Lock()
+	defer kr.mu.Unlock()
+
+	kr.keys = keys
+	kr.signing = signing
+	kr.loadedAt = time.Now()
+	return nil
+}
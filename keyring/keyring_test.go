@@ -0,0 +1,98 @@
+package keyring
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/glebarez/sqlite"
+	"github.com/yasharya2901/smart_divide/models"
+	"github.com/yasharya2901/smart_divide/utils"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+func newTestDB(t *testing.T) *gorm.DB {
+	t.Helper()
+
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "test.db")), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if sqlDB, err := db.DB(); err == nil {
+			sqlDB.Close()
+		}
+	})
+
+	if err := db.AutoMigrate(&models.SigningKey{}); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+func TestRotateKeepsVerifyingForTheTokenLifetime(t *testing.T) {
+	for _, algorithm := range []string{AlgorithmRS256, AlgorithmEdDSA} {
+		t.Run(algorithm, func(t *testing.T) {
+			db := newTestDB(t)
+			// Access tokens may last a minute while other tokens signed by the ring last longer
+			tokenLifetime := 15 * time.Minute
+			kr, err := New(db, algorithm, "", tokenLifetime)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			token, _, err := utils.GenerateToken(utils.Claims{UserID: 1, Type: utils.TokenTypeAccess}, tokenLifetime, kr)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			rotatedAt := time.Now()
+			newKey, err := kr.Rotate()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if kid, _, _, err := kr.SigningKey(); err != nil || kid != newKey.KeyID {
+				t.Fatalf("signing with %q after rotating to %q, %v", kid, newKey.KeyID, err)
+			}
+			if _, err := utils.ValidateToken(token, kr); err != nil {
+				t.Fatalf("token of the retired key rejected: %v", err)
+			}
+
+			var retired models.SigningKey
+			if err := db.Where("retired_at IS NOT NULL").First(&retired).Error; err != nil {
+				t.Fatal(err)
+			}
+			if retired.VerifyUntil.Before(rotatedAt.Add(tokenLifetime)) {
+				t.Errorf("retired key verifies until %s, before the last token it signed expires at %s", retired.VerifyUntil, rotatedAt.Add(tokenLifetime))
+			}
+
+			keys, err := kr.Keys()
+			if err != nil || len(keys) != 2 || keys[0].KeyID != newKey.KeyID {
+				t.Errorf("published keys %v, %v", keys, err)
+			}
+
+			// Once its tokens have expired the retired key is dropped
+			if err := db.Model(&retired).Update("verify_until", time.Now().Add(-time.Second)).Error; err != nil {
+				t.Fatal(err)
+			}
+			if err := kr.load(); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := kr.VerificationKey(retired.KeyID); err == nil {
+				t.Error("expired key still verifies")
+			}
+		})
+	}
+}
+
+func TestRotateSharedSecret(t *testing.T) {
+	kr, err := New(newTestDB(t), AlgorithmHS256, "a-shared-secret-a-shared-secret!", time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := kr.Rotate(); !errors.Is(err, ErrRotationUnsupported) {
+		t.Errorf("rotating the shared secret returned %v", err)
+	}
+}
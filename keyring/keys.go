@@ -0,0 +1,108 @@
+package keyring
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/yasharya2901/smart_divide/models"
+	"github.com/yasharya2901/smart_divide/utils"
+)
+
+const rsaKeyBits = 2048
+
+// signingKey is a stored key with its parsed private and public halves.
+type signingKey struct {
+	models.SigningKey
+	private crypto.Signer
+	public  crypto.PublicKey
+}
+
+func (k *signingKey) method() jwt.SigningMethod {
+	if k.Algorithm == AlgorithmEdDSA {
+		return jwt.SigningMethodEdDSA
+	}
+	return jwt.SigningMethodRS256
+}
+
+func (k *signingKey) canVerify(now time.Time) bool {
+	return k.RetiredAt == nil || (k.VerifyUntil != nil && k.VerifyUntil.After(now))
+}
+
+func generateKey(algorithm string) (*signingKey, error) {
+	var private crypto.Signer
+	var err error
+
+	switch algorithm {
+	case AlgorithmRS256:
+		private, err = rsa.GenerateKey(rand.Reader, rsaKeyBits)
+	case AlgorithmEdDSA:
+		_, private, err = ed25519.GenerateKey(rand.Reader)
+	default:
+		return nil, fmt.Errorf("unsupported signing algorithm %q", algorithm)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	privateDER, err := x509.MarshalPKCS8PrivateKey(private)
+	if err != nil {
+		return nil, err
+	}
+
+	publicDER, err := x509.MarshalPKIXPublicKey(private.Public())
+	if err != nil {
+		return nil, err
+	}
+
+	kid, err := utils.GenerateRandomToken(16)
+	if err != nil {
+		return nil, err
+	}
+
+	return &signingKey{
+		SigningKey: models.SigningKey{
+			KeyID:      kid,
+			Algorithm:  algorithm,
+			PrivateKey: string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: privateDER})),
+			PublicKey:  string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: publicDER})),
+		},
+		private: private,
+		public:  private.Public(),
+	}, nil
+}
+
+func parseKey(record models.SigningKey) (*signingKey, error) {
+	block, _ := pem.Decode([]byte(record.PrivateKey))
+	if block == nil {
+		return nil, errors.New("invalid private key encoding")
+	}
+
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+
+	var private crypto.Signer
+	switch key := parsed.(type) {
+	case *rsa.PrivateKey:
+		private = key
+	case ed25519.PrivateKey:
+		private = key
+	default:
+		return nil, fmt.Errorf("unsupported private key type %T", parsed)
+	}
+
+	return &signingKey{
+		SigningKey: record,
+		private:    private,
+		public:     private.Public(),
+	}, nil
+}
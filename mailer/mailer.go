@@ -0,0 +1,34 @@
+package mailer
+
+import (
+	"fmt"
+
+	"github.com/yasharya2901/smart_divide/config"
+)
+
+// Mailer sends plain text emails.
+type Mailer interface {
+	Send(to, subject, body string) error
+}
+
+// Message is an email handed to a Mailer.
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// New creates the mailer selected by the driver of the config.
+// Supported drivers are "smtp", "file" and "memory".
+func New(cfg config.Mail) (Mailer, error) {
+	switch driver := cfg.Driver; driver {
+	case "smtp":
+		return NewSMTPMailer(cfg.SMTPHost, cfg.SMTPPort, cfg.SMTPUsername, cfg.SMTPPassword, cfg.From), nil
+	case "file":
+		return NewFileMailer(cfg.FileDir), nil
+	case "memory":
+		return NewMemoryMailer(), nil
+	default:
+		return nil, fmt.Errorf("unknown mailer driver %q", driver)
+	}
+}
@@ -11,20 +11,29 @@ import (
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/yasharya2901/smart_divide/config"
 	"github.com/yasharya2901/smart_divide/database"
+	"github.com/yasharya2901/smart_divide/keyring"
+	"github.com/yasharya2901/smart_divide/mailer"
+	"github.com/yasharya2901/smart_divide/middleware"
 	"github.com/yasharya2901/smart_divide/models"
+	"github.com/yasharya2901/smart_divide/oidc"
+	"github.com/yasharya2901/smart_divide/passwordhash"
+	"github.com/yasharya2901/smart_divide/passwordpolicy"
 	"github.com/yasharya2901/smart_divide/routes"
+	"github.com/yasharya2901/smart_divide/services"
+	"github.com/yasharya2901/smart_divide/sms"
 )
 
 func main() {
-	// Connect to the database
-	user := os.Getenv("MYSQL_USER")
-	password := os.Getenv("MYSQL_PASSWORD")
-	host := os.Getenv("MYSQL_HOST")
-	port := os.Getenv("MYSQL_PORT")
-	dbName := os.Getenv("MYSQL_DATABASE")
+	// Load and validate the settings, so a misconfigured server doesn't start
+	cfg, err := config.Load(os.Args[1:])
+	if err != nil {
+		log.Fatal(err)
+	}
 
-	db, err := database.NewMySQL(user, password, host, port, dbName)
+	// Connect to the database
+	db, err := database.NewMySQL(cfg.Database.User, cfg.Database.Password, cfg.Database.Host, cfg.Database.Port, cfg.Database.Name)
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -35,35 +44,152 @@ func main() {
 		}
 	}()
 
+	// Use the explicit membership model for the event_people join table
+	if err := db.GetDB().SetupJoinTable(&models.Event{}, "People", &models.EventPerson{}); err != nil {
+		log.Fatal(err)
+	}
+	if err := db.GetDB().SetupJoinTable(&models.Person{}, "Events", &models.EventPerson{}); err != nil {
+		log.Fatal(err)
+	}
+
 	// Migrate the schema
 	err = db.GetDB().AutoMigrate(
 		&models.Event{},
 		&models.Expense{},
 		&models.Person{},
 		&models.ExpensePerson{},
+		&models.EventPerson{},
+		&models.Session{},
+		&models.PasswordResetToken{},
+		&models.EmailVerificationToken{},
+		&models.PhoneOTP{},
+		&models.RecoveryCode{},
+		&models.ExternalIdentity{},
+		&models.OIDCLoginState{},
+		&models.APIKey{},
+		&models.LoginAttempt{},
+		&models.LockoutEvent{},
+		&models.AccountUnlockToken{},
+		&models.SigningKey{},
+		&models.AuditEvent{},
+		&models.WebAuthnCredential{},
+		&models.WebAuthnSession{},
+		&models.MagicLinkToken{},
+		&models.EventInvitation{},
+		&models.Friendship{},
+		&models.ContactLookup{},
 	)
 	if err != nil {
 		log.Fatal(err)
 	}
 
+	// Drop the single refresh token columns that were replaced by sessions
+	for _, column := range []string{"refresh_token", "refresh_token_family", "refresh_token_expiry_date"} {
+		if db.GetDB().Migrator().HasColumn(&models.Person{}, column) {
+			if err := db.GetDB().Migrator().DropColumn(&models.Person{}, column); err != nil {
+				log.Fatal(err)
+			}
+		}
+	}
+
+	// Give an owner to events from before members had roles
+	if err := database.MigrateEventOwners(db.GetDB()); err != nil {
+		log.Fatal(err)
+	}
+
+	// Make verified contact numbers unique, so they can be used to log in
+	if err := database.MigrateVerifiedContacts(db.GetDB()); err != nil {
+		log.Fatal(err)
+	}
+
+	// Move amounts stored as decimals into minor units
+	if err := database.MigrateMoney(db.GetDB(), cfg.Money.DefaultCurrency); err != nil {
+		log.Fatal(err)
+	}
+
+	// Set up the mailer
+	mail, err := mailer.New(cfg.Mail)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// Set up the SMS sender
+	sender, err := sms.New(cfg.SMS)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// Set up the OpenID Connect providers
+	providers := oidc.LoadProviders(cfg.OIDC)
+
+	// Set up the relying party for passkeys
+	passkeys, err := services.NewWebAuthn(cfg.WebAuthn)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// Set up the store for failed login attempts
+	attempts, err := services.NewLoginAttemptStore(db.GetDB(), cfg.Auth.LoginAttemptStore)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// Set up the keys access tokens are signed with. Retired keys verify tokens until the
+	// longest lived token they signed has expired, which may be a 2FA or impersonation token
+	keyRing, err := keyring.NewFromConfig(db.GetDB(), cfg.JWT, services.SignedTokenLifetime(cfg))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// Set up the password policy
+	passwordPolicy, err := passwordpolicy.New(cfg.Password)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// Set up password hashing
+	passwords, err := passwordhash.NewFromConfig(cfg.PasswordHash)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// Set up the audit log, queued events are written before the database is closed
+	auditLog := services.NewAuditLog(db.GetDB())
+	defer auditLog.Close()
+
+	// Make the accounts listed in ADMIN_EMAILS administrators
+	if err := services.NewAdminService(db.GetDB(), cfg, mail, passwords, attempts, keyRing, auditLog).PromoteAdmins(cfg.Admin.Emails); err != nil {
+		log.Fatal(err)
+	}
+
 	// Set up the server
 	router := gin.Default()
+	if err := router.SetTrustedProxies(cfg.Server.TrustedProxies); err != nil {
+		log.Fatal(err)
+	}
 
 	router.Use(gin.Logger())
 	router.Use(gin.Recovery())
 
 	api := router.Group("/api/v0")
+	api.Use(middleware.Authenticate(db.GetDB(), keyRing, auditLog))
 
-	routes.PersonRoutes(api, db.GetDB())
-	routes.EventRoutes(api, db.GetDB())
+	routes.PersonRoutes(api, db.GetDB(), cfg, mail, passwords)
+	routes.EventRoutes(api, db.GetDB(), cfg)
+	routes.InvitationRoutes(api, db.GetDB(), cfg)
+	routes.FriendRoutes(api, db.GetDB())
 	routes.ExpenseRoutes(api, db.GetDB())
+	routes.MeRoutes(api, db.GetDB(), auditLog)
 
 	auth := router.Group("/auth")
-	routes.AuthRoutes(auth, db.GetDB())
+	routes.AuthRoutes(auth, db.GetDB(), cfg, mail, sender, providers, passkeys, attempts, keyRing, auditLog, passwordPolicy, passwords)
+
+	routes.WellKnownRoutes(&router.RouterGroup, keyRing, auditLog)
+	routes.AdminRoutes(&router.RouterGroup, db.GetDB(), cfg, mail, passwords, attempts, keyRing, auditLog)
 
 	// Create http.Server
 	server := &http.Server{
-		Addr:    fmt.Sprintf(":%s", os.Getenv("SERVER_PORT")),
+		Addr:    fmt.Sprintf(":%s", cfg.Server.Port),
 		Handler: router,
 	}
 
@@ -83,9 +209,7 @@ func main() {
 	}()
 
 	// Start the server
-	serverPort := os.Getenv("SERVER_PORT")
-
-	log.Println("Starting server on port", serverPort)
+	log.Println("Starting server on port", cfg.Server.Port)
 	if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
 		log.Fatalf("Failed to start server: %v", err)
 	}
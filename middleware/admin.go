@@ -0,0 +1,29 @@
+package middleware
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/yasharya2901/smart_divide/models"
+	"gorm.io/gorm"
+)
+
+// RequireAdmin only lets system administrators through. They must be logged in themselves,
+// not using an API key or impersonating someone.
+func RequireAdmin(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		principal, ok := GetPrincipal(c)
+		if !ok || principal.IsAPIKey() || principal.IsImpersonated() {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "admin access required"})
+			return
+		}
+
+		var person models.Person
+		if err := db.Select("id", "is_admin").First(&person, principal.PersonID).Error; err != nil || !person.IsAdmin {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "admin access required"})
+			return
+		}
+
+		c.Next()
+	}
+}
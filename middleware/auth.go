@@ -0,0 +1,227 @@
+package middleware
+
+import (
+	"errors"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/yasharya2901/smart_divide/models"
+	"github.com/yasharya2901/smart_divide/services"
+	"github.com/yasharya2901/smart_divide/utils"
+	"gorm.io/gorm"
+)
+
+const principalKey = "principal"
+
+// Principal is the authenticated caller of a request, either a person logged in with
+// an access token or a script using one of their API keys.
+type Principal struct {
+	PersonID  uint
+	Email     string
+	SessionID uint     // Set when authenticated with an access token
+	APIKeyID  uint     // Set when authenticated with an API key
+	Scopes    []string // Scopes of the API key, access tokens are not restricted
+
+	ImpersonatorID uint // Administrator making the request on behalf of the person, if any
+}
+
+// IsAPIKey reports whether the principal authenticated with an API key.
+func (p *Principal) IsAPIKey() bool {
+	return p.APIKeyID != 0
+}
+
+// IsImpersonated reports whether an administrator is making the request as the person.
+func (p *Principal) IsImpersonated() bool {
+	return p.ImpersonatorID != 0
+}
+
+// HasScope reports whether the principal is allowed to use the scope.
+func (p *Principal) HasScope(scope string) bool {
+	return !p.IsAPIKey() || slices.Contains(p.Scopes, scope)
+}
+
+// Authenticate accepts either a bearer access token or an API key, passed as a bearer
+// token or in the X-API-Key header, and stores the principal in the context. Requests
+// without valid credentials, or with a token from a logged out session or an older
+// token version, are rejected with 401. Tokens of an impersonating administrator can only
+// read, and every request made with one is recorded in the audit log.
+func Authenticate(db *gorm.DB, accessKeys utils.KeySet, auditLog *services.AuditLog) gin.HandlerFunc {
+	apiKeyService := services.NewAPIKeyService(db)
+
+	return func(c *gin.Context) {
+		credential := c.GetHeader("X-API-Key")
+		if credential == "" {
+			header := c.GetHeader("Authorization")
+			if header == "" {
+				unauthorized(c, "missing authorization header")
+				return
+			}
+
+			tokenString, found := strings.CutPrefix(header, "Bearer ")
+			if !found || strings.TrimSpace(tokenString) == "" {
+				unauthorized(c, "invalid authorization header")
+				return
+			}
+			credential = strings.TrimSpace(tokenString)
+		}
+
+		if strings.HasPrefix(credential, services.APIKeyPrefix) {
+			apiKey, err := apiKeyService.Authenticate(credential)
+			if err != nil {
+				unauthorized(c, err.Error())
+				return
+			}
+
+			// Keys of locked or deleted accounts stop working, even if they were not revoked
+			var person models.Person
+			if err := db.Select("id", "email").
+				Where("disabled_at IS NULL AND anonymized_at IS NULL").
+				First(&person, apiKey.PersonID).Error; err != nil {
+				unauthorized(c, "invalid api key")
+				return
+			}
+
+			c.Set(principalKey, &Principal{
+				PersonID: person.ID,
+				Email:    person.Email,
+				APIKeyID: apiKey.ID,
+				Scopes:   services.ScopeList(apiKey),
+			})
+			c.Next()
+			return
+		}
+
+		claims, err := utils.ValidateToken(credential, accessKeys)
+		if err != nil || claims.Type != utils.TokenTypeAccess {
+			unauthorized(c, "invalid or expired token")
+			return
+		}
+
+		if !tokenIsCurrent(db, claims) {
+			unauthorized(c, "token has been revoked")
+			return
+		}
+
+		principal := &Principal{
+			PersonID:       claims.UserID,
+			Email:          claims.Email,
+			SessionID:      claims.SessionID,
+			ImpersonatorID: claims.ImpersonatorID,
+		}
+
+		if principal.IsImpersonated() {
+			var err error
+			if !isReadOnly(c.Request.Method) {
+				err = errors.New("impersonation is read-only")
+			}
+
+			auditLog.Record(services.AuditEntry{
+				PersonID: principal.PersonID,
+				ActorID:  principal.ImpersonatorID,
+				Action:   services.AuditImpersonatedRequest,
+				Detail:   c.Request.Method + " " + c.Request.URL.Path,
+				Device:   services.DeviceInfo{UserAgent: c.Request.UserAgent(), IPAddress: c.ClientIP()},
+				Err:      err,
+			})
+
+			if err != nil {
+				c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": err.Error()})
+				return
+			}
+		}
+
+		c.Set(principalKey, principal)
+		c.Next()
+	}
+}
+
+// RequireScope rejects API keys that were not granted the scope.
+func RequireScope(scope string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		principal, ok := GetPrincipal(c)
+		if !ok || !principal.HasScope(scope) {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "missing scope " + scope})
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// RequireSession rejects API keys, for account management routes that need a logged in person.
+func RequireSession() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		principal, ok := GetPrincipal(c)
+		if !ok || principal.IsAPIKey() {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "this endpoint can't be used with an api key"})
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// GetPrincipal returns the authenticated caller.
+func GetPrincipal(c *gin.Context) (*Principal, bool) {
+	value, exists := c.Get(principalKey)
+	if !exists {
+		return nil, false
+	}
+
+	principal, ok := value.(*Principal)
+	return principal, ok
+}
+
+// GetPersonID returns the ID of the authenticated person.
+func GetPersonID(c *gin.Context) (uint, bool) {
+	principal, ok := GetPrincipal(c)
+	if !ok {
+		return 0, false
+	}
+
+	return principal.PersonID, true
+}
+
+// tokenIsCurrent checks that the person still exists, the token version matches
+// and the session the token was issued for has not been revoked.
+func tokenIsCurrent(db *gorm.DB, claims *utils.Claims) bool {
+	var person models.Person
+	if err := db.Select("id", "token_version").First(&person, claims.UserID).Error; err != nil {
+		return false
+	}
+
+	if person.TokenVersion != claims.Version {
+		return false
+	}
+
+	// An impersonation token stops working once the administrator loses their rights
+	if claims.ImpersonatorID != 0 {
+		var impersonator models.Person
+		if err := db.Select("id", "is_admin").First(&impersonator, claims.ImpersonatorID).Error; err != nil {
+			return false
+		}
+		return impersonator.IsAdmin
+	}
+
+	if claims.SessionID == 0 {
+		return true
+	}
+
+	var session models.Session
+	if err := db.Select("id", "person_id", "revoked").First(&session, claims.SessionID).Error; err != nil {
+		return false
+	}
+
+	return session.PersonID == claims.UserID && !session.Revoked
+}
+
+// isReadOnly reports whether a request with the method can't change anything.
+func isReadOnly(method string) bool {
+	return method == http.MethodGet || method == http.MethodHead || method == http.MethodOptions
+}
+
+func unauthorized(c *gin.Context, message string) {
+	c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": message})
+}
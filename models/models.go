@@ -3,44 +3,283 @@ package models
 import (
 	"time"
 
+	"github.com/yasharya2901/smart_divide/money"
 	"gorm.io/gorm"
 )
 
 type Event struct {
 	gorm.Model           // Includes ID, CreatedAt, UpdatedAt, DeletedAt
-	Name       string    `gorm:"type:varchar(255);not null"` // Event name
-	People     []Person  `gorm:"many2many:event_people"`     // Many-to-many relationship with People
-	Expenses   []Expense `gorm:"foreignKey:EventID"`         // One-to-many relationship with Expense
+	Name       string    `gorm:"type:varchar(255);not null"`       // Event name
+	Currency   string    `gorm:"type:char(3);not null;default:''"` // ISO 4217 code of the event's amounts
+	People     []Person  `gorm:"many2many:event_people"`           // Many-to-many relationship with People
+	Expenses   []Expense `gorm:"foreignKey:EventID"`               // One-to-many relationship with Expense
+}
+
+// EventPerson is the membership of a person in an event, stored in the event_people join table.
+type EventPerson struct {
+	EventID   uint      `gorm:"primaryKey"`                                 // Foreign key to Event
+	PersonID  uint      `gorm:"primaryKey"`                                 // Foreign key to Person
+	Role      string    `gorm:"type:varchar(20);not null;default:'member'"` // Role of the person in the event
+	CreatedAt time.Time // When the person joined
+}
+
+// EventInvitation is a link anyone logged in can use to join an event with a role. The token
+// of the link is signed by the server rather than stored, so the link can be shown again.
+type EventInvitation struct {
+	gorm.Model             // Includes ID, CreatedAt, UpdatedAt, DeletedAt
+	EventID     uint       `gorm:"not null;index"`            // Foreign key to Event
+	CreatedByID uint       `gorm:"not null"`                  // Foreign key to the Person who created it
+	Nonce       string     `gorm:"type:varchar(64);not null"` // Random value the token signature covers
+	Role        string     `gorm:"type:varchar(20);not null"` // Role given to people who join with it
+	MaxUses     int        `gorm:"not null;default:0"`        // How many people can join with it, 0 for no limit
+	Uses        int        `gorm:"not null;default:0"`        // How many people joined with it
+	ExpiresAt   *time.Time `gorm:"type:timestamp"`            // Invitation expiry date, nil if it doesn't expire
+	RevokedAt   *time.Time `gorm:"type:timestamp"`            // When the invitation was revoked
 }
 
 type Expense struct {
 	gorm.Model                  // Includes ID, CreatedAt, UpdatedAt, DeletedAt
-	Name        string          `gorm:"type:varchar(255);not null"`  // Expense name
-	TotalAmount float64         `gorm:"type:decimal(10,2);not null"` // Total expense amount
-	EventID     uint            `gorm:"not null"`                    // Foreign key to Event
-	PaidByID    uint            `gorm:"not null"`                    // Foreign key to People
-	PaidBy      Person          `gorm:"foreignKey:PaidByID"`         // Reference to the person who paid
-	Splits      []ExpensePerson `gorm:"foreignKey:ExpenseID"`        // Splits for the expense
+	Name        string          `gorm:"type:varchar(255);not null"`     // Expense name
+	TotalAmount money.Money     `gorm:"embedded;embeddedPrefix:total_"` // Total expense amount
+	EventID     uint            `gorm:"not null"`                       // Foreign key to Event
+	PaidByID    uint            `gorm:"not null"`                       // Foreign key to People
+	PaidBy      Person          `gorm:"foreignKey:PaidByID"`            // Reference to the person who paid
+	CreatedByID uint            `gorm:"index"`                          // Foreign key to the person who added the expense
+	Splits      []ExpensePerson `gorm:"foreignKey:ExpenseID"`           // Splits for the expense
 }
 
 type Person struct {
-	gorm.Model                             // Includes ID, CreatedAt, UpdatedAt, DeletedAt
-	Name                   string          `gorm:"type:varchar(255);not null"` // Person name
-	Contact                string          `gorm:"type:varchar(50)"`           // Contact number
-	Email                  string          `gorm:"type:varchar(255);unique"`   // Email (unique constraint)
-	Password               string          `gorm:"type:varchar(255);not null"` // Hashed password
-	RefreshToken           string          `gorm:"type:text"`                  // Refresh token
-	RefreshTokenExpiryDate *time.Time      `gorm:"type:timestamp"`             // Refresh token expiry date
-	Events                 []Event         `gorm:"many2many:event_people"`     // Many-to-many relationship with Event
-	Expenses               []ExpensePerson `gorm:"foreignKey:PersonID"`        // Splits for expenses
+	gorm.Model                      // Includes ID, CreatedAt, UpdatedAt, DeletedAt
+	Name            string          `gorm:"type:varchar(255);not null"`  // Person name
+	Contact         string          `gorm:"type:varchar(50)"`            // Contact number
+	Email           string          `gorm:"type:varchar(255);unique"`    // Email (unique constraint)
+	EmailVerified   bool            `gorm:"not null;default:false"`      // Whether the email was confirmed
+	PendingEmail    string          `gorm:"type:varchar(255)"`           // New email waiting for confirmation
+	ContactVerified bool            `gorm:"not null;default:false"`      // Whether the contact number was confirmed with an OTP
+	Password        string          `gorm:"type:varchar(255)" json:"-"`  // Hashed password, empty for accounts that only sign in externally
+	TOTPSecret      string          `gorm:"type:varchar(64)" json:"-"`   // Base32 TOTP secret, set once enrollment starts
+	TOTPEnabled     bool            `gorm:"not null;default:false"`      // Whether two-factor authentication is required at login
+	TOTPLastStep    int64           `gorm:"not null;default:0" json:"-"` // Last accepted TOTP time step, to reject replayed codes
+	TokenVersion    uint            `gorm:"not null;default:0"`          // Incremented to invalidate every issued token
+	Events          []Event         `gorm:"many2many:event_people"`      // Many-to-many relationship with Event
+	Expenses        []ExpensePerson `gorm:"foreignKey:PersonID"`         // Splits for expenses
+
+	// Placeholders are people added to an event by name who have no account yet. Their email
+	// and number are kept apart from Email and Contact until someone verifies them.
+	Placeholder        bool   `gorm:"not null;default:false"`  // Whether the person has no account yet
+	PlaceholderEmail   string `gorm:"type:varchar(255);index"` // Email the placeholder is claimed with
+	PlaceholderContact string `gorm:"type:varchar(50);index"`  // Contact number the placeholder is claimed with
+	MergedIntoID       *uint  `gorm:"index"`                   // Account the placeholder was merged into
+
+	// Deleted accounts keep their row so expenses shared with others stay intact, but every
+	// personal detail is cleared.
+	AnonymizedAt *time.Time `gorm:"type:timestamp"` // When the account was deleted
+
+	// Administrators manage accounts, and can lock one until they unlock it again.
+	IsAdmin        bool       `gorm:"not null;default:false"` // Whether the person is a system administrator
+	DisabledAt     *time.Time `gorm:"type:timestamp"`         // When an administrator locked the account
+	DisabledReason string     `gorm:"type:varchar(255)"`      // Why the account was locked
+
+	// People who opt in can be found by others who have their email or number, which are
+	// matched by hash so lookups never carry the plain values.
+	Discoverable bool   `gorm:"not null;default:false"` // Whether others can find the person by email or number
+	EmailHash    string `gorm:"type:varchar(64);index"` // Hash of the normalized email
+	ContactHash  string `gorm:"type:varchar(64);index"` // Hash of the normalized contact number
+
+	// Anyone can enter any number, so only a verified number is unique and can log in.
+	VerifiedContact *string `gorm:"type:varchar(50);uniqueIndex" json:"-"` // Contact number once verified, NULL before
+}
+
+// Friendship is a friend request from one person to another, and a friendship in both
+// directions once accepted. Removed friendships are deleted, so the pair can request again.
+type Friendship struct {
+	gorm.Model             // Includes ID, CreatedAt, UpdatedAt, DeletedAt
+	RequesterID uint       `gorm:"not null;uniqueIndex:idx_friendship_pair"`       // Foreign key to the Person who sent the request
+	AddresseeID uint       `gorm:"not null;uniqueIndex:idx_friendship_pair;index"` // Foreign key to the Person who received it
+	AcceptedAt  *time.Time `gorm:"type:timestamp"`                                 // When the request was accepted, nil while pending
+}
+
+// ContactLookup records a contact discovery request, so lookups can be rate limited per person.
+type ContactLookup struct {
+	ID        uint      `gorm:"primaryKey"`
+	PersonID  uint      `gorm:"not null;index"` // Foreign key to the Person who looked up contacts
+	Hashes    int       `gorm:"not null"`       // How many hashes were looked up
+	CreatedAt time.Time `gorm:"index"`          // When the lookup was made
+}
+
+type Session struct {
+	gorm.Model                 // Includes ID, CreatedAt, UpdatedAt, DeletedAt
+	PersonID         uint      `gorm:"not null;index"`         // Foreign key to Person
+	DeviceName       string    `gorm:"type:varchar(255)"`      // Name given by the client
+	UserAgent        string    `gorm:"type:varchar(512)"`      // User agent at login
+	IPAddress        string    `gorm:"type:varchar(45)"`       // IP address at login
+	RefreshTokenHash string    `gorm:"type:varchar(64);index"` // Hash of the current refresh token
+	LastUsedAt       time.Time `gorm:"not null"`               // Last time the session was refreshed
+	ExpiresAt        time.Time `gorm:"not null"`               // Refresh token expiry date
+	Revoked          bool      `gorm:"not null;default:false"` // Whether the session was logged out
 }
 
 type ExpensePerson struct {
-	gorm.Model         // Includes ID, CreatedAt, UpdatedAt, DeletedAt
-	ExpenseID  uint    `gorm:"not null"`             // Foreign key to Expense
-	PersonID   uint    `gorm:"not null"`             // Foreign key to Person
-	PaidAmount float64 `gorm:"type:decimal(10,2)"`   // Amount paid by the person
-	OwedAmount float64 `gorm:"type:decimal(10,2)"`   // Amount owed by the person
-	Expense    Expense `gorm:"foreignKey:ExpenseID"` // Reference to the expense
-	Person     Person  `gorm:"foreignKey:PersonID"`  // Reference to the person
+	gorm.Model             // Includes ID, CreatedAt, UpdatedAt, DeletedAt
+	ExpenseID  uint        `gorm:"not null"`                      // Foreign key to Expense
+	PersonID   uint        `gorm:"not null"`                      // Foreign key to Person
+	PaidAmount money.Money `gorm:"embedded;embeddedPrefix:paid_"` // Amount paid by the person
+	OwedAmount money.Money `gorm:"embedded;embeddedPrefix:owed_"` // Amount owed by the person
+	Expense    Expense     `gorm:"foreignKey:ExpenseID"`          // Reference to the expense
+	Person     Person      `gorm:"foreignKey:PersonID"`           // Reference to the person
+}
+
+type PasswordResetToken struct {
+	gorm.Model            // Includes ID, CreatedAt, UpdatedAt, DeletedAt
+	PersonID   uint       `gorm:"not null;index"`                   // Foreign key to Person
+	TokenHash  string     `gorm:"type:varchar(64);not null;unique"` // Hash of the emailed token
+	ExpiresAt  time.Time  `gorm:"not null"`                         // Token expiry date
+	UsedAt     *time.Time `gorm:"type:timestamp"`                   // When the token was redeemed
+}
+
+// MagicLinkToken is an emailed link that logs a person in without a password. Tokens are also
+// stored for unknown emails, so issuance can be rate limited per address without revealing
+// which addresses are registered.
+type MagicLinkToken struct {
+	gorm.Model            // Includes ID, CreatedAt, UpdatedAt, DeletedAt
+	PersonID   *uint      `gorm:"index"`                            // Foreign key to Person, nil for unknown emails
+	Email      string     `gorm:"type:varchar(255);not null;index"` // Address the link was requested for
+	TokenHash  string     `gorm:"type:varchar(64);not null;unique"` // Hash of the emailed token
+	DeviceHash string     `gorm:"type:varchar(64)"`                 // Hash of the secret given to the requesting device
+	IPAddress  string     `gorm:"type:varchar(45)"`                 // IP address the link was requested from
+	ExpiresAt  time.Time  `gorm:"not null"`                         // Token expiry date
+	UsedAt     *time.Time `gorm:"type:timestamp"`                   // When the token was redeemed
+}
+
+type EmailVerificationToken struct {
+	gorm.Model            // Includes ID, CreatedAt, UpdatedAt, DeletedAt
+	PersonID   uint       `gorm:"not null;index"`                   // Foreign key to Person
+	Email      string     `gorm:"type:varchar(255);not null"`       // Email being verified
+	TokenHash  string     `gorm:"type:varchar(64);not null;unique"` // Hash of the emailed token
+	ExpiresAt  time.Time  `gorm:"not null"`                         // Token expiry date
+	UsedAt     *time.Time `gorm:"type:timestamp"`                   // When the token was redeemed
+}
+
+type PhoneOTP struct {
+	gorm.Model            // Includes ID, CreatedAt, UpdatedAt, DeletedAt
+	Contact    string     `gorm:"type:varchar(50);not null;index"` // Phone number the code was sent to
+	Purpose    string     `gorm:"type:varchar(20);not null"`       // What the code can be used for
+	CodeHash   string     `gorm:"type:varchar(64);not null"`       // Hash of the code
+	ExpiresAt  time.Time  `gorm:"not null"`                        // Code expiry date
+	Attempts   int        `gorm:"not null;default:0"`              // Number of verification attempts
+	ConsumedAt *time.Time `gorm:"type:timestamp"`                  // When the code was used
+}
+
+type RecoveryCode struct {
+	gorm.Model            // Includes ID, CreatedAt, UpdatedAt, DeletedAt
+	PersonID   uint       `gorm:"not null;index"`            // Foreign key to Person
+	CodeHash   string     `gorm:"type:varchar(64);not null"` // Hash of the recovery code
+	UsedAt     *time.Time `gorm:"type:timestamp"`            // When the code was used
+}
+
+type ExternalIdentity struct {
+	gorm.Model        // Includes ID, CreatedAt, UpdatedAt, DeletedAt
+	PersonID   uint   `gorm:"not null;index"`                                              // Foreign key to Person
+	Provider   string `gorm:"type:varchar(50);not null;uniqueIndex:idx_provider_subject"`  // Name of the identity provider
+	Subject    string `gorm:"type:varchar(255);not null;uniqueIndex:idx_provider_subject"` // User ID at the provider
+	Email      string `gorm:"type:varchar(255)"`                                           // Email reported by the provider
+}
+
+type OIDCLoginState struct {
+	gorm.Model             // Includes ID, CreatedAt, UpdatedAt, DeletedAt
+	StateHash    string    `gorm:"type:varchar(64);not null;unique"` // Hash of the state parameter
+	Provider     string    `gorm:"type:varchar(50);not null"`        // Provider the login was started with
+	CodeVerifier string    `gorm:"type:varchar(128);not null"`       // PKCE code verifier
+	Nonce        string    `gorm:"type:varchar(64);not null"`        // Nonce expected in the ID token
+	ExpiresAt    time.Time `gorm:"not null"`                         // State expiry date
+}
+
+type APIKey struct {
+	gorm.Model            // Includes ID, CreatedAt, UpdatedAt, DeletedAt
+	PersonID   uint       `gorm:"not null;index"`                   // Foreign key to Person
+	Name       string     `gorm:"type:varchar(255);not null"`       // Name given by the owner
+	Prefix     string     `gorm:"type:varchar(16);not null"`        // Public part of the key, to recognise it
+	KeyHash    string     `gorm:"type:varchar(64);not null;unique"` // Hash of the full key
+	Scopes     string     `gorm:"type:varchar(512);not null"`       // Space separated scopes
+	ExpiresAt  *time.Time `gorm:"type:timestamp"`                   // Optional expiry date
+	LastUsedAt *time.Time `gorm:"type:timestamp"`                   // Last time the key authenticated a request
+	RevokedAt  *time.Time `gorm:"type:timestamp"`                   // When the key was revoked
+}
+
+type LoginAttempt struct {
+	gorm.Model              // Includes ID, CreatedAt, UpdatedAt, DeletedAt
+	Key           string    `gorm:"type:varchar(255);not null;unique"` // Account or IP address the failures are counted for
+	Failures      int       `gorm:"not null;default:0"`                // Consecutive failed logins
+	LastFailureAt time.Time `gorm:"not null"`                          // Time of the last failed login
+	LockedUntil   time.Time `gorm:"not null"`                          // Logins are refused until this time
+}
+
+type LockoutEvent struct {
+	gorm.Model             // Includes ID, CreatedAt, UpdatedAt, DeletedAt
+	PersonID    uint       `gorm:"not null;index"`    // Foreign key to Person
+	IPAddress   string     `gorm:"type:varchar(45)"`  // IP address of the last failed attempt
+	UserAgent   string     `gorm:"type:varchar(512)"` // User agent of the last failed attempt
+	Failures    int        `gorm:"not null"`          // Failed attempts that caused the lockout
+	LockedUntil time.Time  `gorm:"not null"`          // End of the lockout
+	UnlockedAt  *time.Time `gorm:"type:timestamp"`    // When the owner unlocked the account by email
+}
+
+type AccountUnlockToken struct {
+	gorm.Model            // Includes ID, CreatedAt, UpdatedAt, DeletedAt
+	PersonID   uint       `gorm:"not null;index"`                   // Foreign key to Person
+	TokenHash  string     `gorm:"type:varchar(64);not null;unique"` // Hash of the emailed token
+	ExpiresAt  time.Time  `gorm:"not null"`                         // Token expiry date
+	UsedAt     *time.Time `gorm:"type:timestamp"`                   // When the token was redeemed
+}
+
+type SigningKey struct {
+	gorm.Model             // Includes ID, CreatedAt, UpdatedAt, DeletedAt
+	KeyID       string     `gorm:"type:varchar(64);not null;unique"` // kid header of the tokens signed with the key
+	Algorithm   string     `gorm:"type:varchar(10);not null"`        // JWS algorithm, RS256 or EdDSA
+	PrivateKey  string     `gorm:"type:text;not null" json:"-"`      // PKCS #8 PEM encoded private key
+	PublicKey   string     `gorm:"type:text;not null"`               // PKIX PEM encoded public key
+	RetiredAt   *time.Time `gorm:"type:timestamp"`                   // When the key stopped signing tokens
+	VerifyUntil *time.Time `gorm:"type:timestamp"`                   // Tokens signed with a retired key are accepted until then
+}
+
+// AuditEvent is an append-only record of an authentication or account action. It has no
+// UpdatedAt or DeletedAt, since entries are never changed or removed.
+type AuditEvent struct {
+	ID        uint      `gorm:"primarykey"`
+	CreatedAt time.Time `gorm:"index"`
+	PersonID  *uint     `gorm:"index"`                           // Person the action was performed by or for, if known
+	Email     string    `gorm:"type:varchar(255);index"`         // Email the action was attempted with
+	Action    string    `gorm:"type:varchar(50);not null;index"` // What was done, e.g. login or password_change
+	Outcome   string    `gorm:"type:varchar(10);not null"`       // success or failure
+	Reason    string    `gorm:"type:varchar(255)"`               // Why the action failed
+	ActorID   *uint     `gorm:"index"`                           // Administrator who acted on the person, if any
+	Detail    string    `gorm:"type:varchar(255)"`               // Context given by the administrator, or the request made while impersonating
+	IPAddress string    `gorm:"type:varchar(45);index"`          // IP address of the request
+	UserAgent string    `gorm:"type:varchar(512)"`               // User agent of the request
+}
+
+// WebAuthnCredential is a passkey registered by a person.
+type WebAuthnCredential struct {
+	gorm.Model                 // Includes ID, CreatedAt, UpdatedAt, DeletedAt
+	PersonID        uint       `gorm:"not null;index"`                    // Foreign key to Person
+	CredentialID    string     `gorm:"type:varchar(255);not null;unique"` // Base64url encoded credential ID
+	PublicKey       []byte     `gorm:"type:blob;not null"`                // COSE encoded public key
+	AttestationType string     `gorm:"type:varchar(32)"`                  // Attestation format given at registration
+	AAGUID          []byte     `gorm:"type:varbinary(16)"`                // Model of the authenticator
+	SignCount       uint32     `gorm:"not null;default:0"`                // Signature counter, to detect cloned authenticators
+	Transports      string     `gorm:"type:varchar(255)"`                 // Space separated transports, e.g. "internal hybrid"
+	BackupEligible  bool       `gorm:"not null;default:false"`            // Whether the passkey can be synced between devices
+	BackupState     bool       `gorm:"not null;default:false"`            // Whether the passkey is currently synced
+	Nickname        string     `gorm:"type:varchar(100)"`                 // Name given by the person
+	LastUsedAt      *time.Time `gorm:"type:timestamp"`                    // Last login with the passkey
+}
+
+// WebAuthnSession holds the challenge of a passkey registration or login between its two steps.
+type WebAuthnSession struct {
+	gorm.Model            // Includes ID, CreatedAt, UpdatedAt, DeletedAt
+	TokenHash   string    `gorm:"type:varchar(64);not null;unique"` // Hash of the token the client sends back
+	PersonID    uint      `gorm:"index"`                            // Person registering a passkey, zero for logins
+	Purpose     string    `gorm:"type:varchar(20);not null"`        // registration or login
+	SessionData string    `gorm:"type:text;not null"`               // JSON encoded webauthn.SessionData
+	ExpiresAt   time.Time `gorm:"not null"`                         // Expiry date of the challenge
 }
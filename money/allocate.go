@@ -0,0 +1,79 @@
+package money
+
+import (
+	"errors"
+	"math/bits"
+	"sort"
+)
+
+// Allocate splits the amount in proportion to the ratios without losing a minor unit. Each
+// part is rounded down, and the minor units left over go one each to the parts with the
+// largest remainders, earlier parts first on ties. The parts always add up to the amount.
+func (m Money) Allocate(ratios ...int64) ([]Money, error) {
+	if len(ratios) == 0 {
+		return nil, errors.New("nothing to allocate to")
+	}
+
+	var total uint64
+	for _, ratio := range ratios {
+		if ratio < 0 {
+			return nil, errors.New("ratios can't be negative")
+		}
+		var carry uint64
+		if total, carry = bits.Add64(total, uint64(ratio), 0); carry != 0 {
+			return nil, ErrOverflow
+		}
+	}
+	if total == 0 {
+		return nil, errors.New("ratios can't all be zero")
+	}
+
+	// Negative amounts are split like positive ones, so the leftover goes the same way
+	amount := uint64(m.Amount)
+	if m.Amount < 0 {
+		amount = uint64(-(m.Amount + 1)) + 1
+	}
+
+	parts := make([]Money, len(ratios))
+	remainders := make([]uint64, len(ratios))
+	left := amount
+	for i, ratio := range ratios {
+		// amount * ratio / total fits in 64 bits as ratio <= total
+		hi, lo := bits.Mul64(amount, uint64(ratio))
+		share, remainder := bits.Div64(hi, lo, total)
+		parts[i] = Money{Amount: int64(share), Currency: m.Currency}
+		remainders[i] = remainder
+		left -= share
+	}
+
+	order := make([]int, len(ratios))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool {
+		return remainders[order[a]] > remainders[order[b]]
+	})
+	for _, i := range order[:left] {
+		parts[i].Amount++
+	}
+
+	if m.Amount < 0 {
+		for i := range parts {
+			parts[i].Amount = -parts[i].Amount
+		}
+	}
+	return parts, nil
+}
+
+// Split divides the amount into n parts as even as possible, the first parts getting the
+// minor units left over.
+func (m Money) Split(n int) ([]Money, error) {
+	if n <= 0 {
+		return nil, errors.New("can't split into less than one part")
+	}
+	ratios := make([]int64, n)
+	for i := range ratios {
+		ratios[i] = 1
+	}
+	return m.Allocate(ratios...)
+}
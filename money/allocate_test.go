@@ -0,0 +1,95 @@
+package money
+
+import (
+	"math"
+	"testing"
+)
+
+func TestAllocate(t *testing.T) {
+	tests := []struct {
+		name   string
+		amount int64
+		ratios []int64
+		want   []int64
+	}{
+		{"even", 300, []int64{1, 1, 1}, []int64{100, 100, 100}},
+		{"leftover to the first", 100, []int64{1, 1, 1}, []int64{34, 33, 33}},
+		{"leftover by remainder", 100, []int64{1, 2, 3}, []int64{17, 33, 50}},
+		{"leftover tie", 5, []int64{1, 1, 1, 1}, []int64{2, 1, 1, 1}},
+		{"zero ratio", 1000, []int64{0, 1, 1}, []int64{0, 500, 500}},
+		{"negative", -100, []int64{1, 1, 1}, []int64{-34, -33, -33}},
+		{"zero amount", 0, []int64{1, 2}, []int64{0, 0}},
+		{"single part", 12345, []int64{7}, []int64{12345}},
+		{"percentages", 999, []int64{50, 30, 20}, []int64{499, 300, 200}},
+		{"large amount", math.MaxInt64, []int64{1, 1}, []int64{math.MaxInt64/2 + 1, math.MaxInt64 / 2}},
+		{"smallest amount", math.MinInt64, []int64{1, 1}, []int64{math.MinInt64 / 2, math.MinInt64 / 2}},
+		{"large ratios", 100, []int64{math.MaxInt64 / 2, math.MaxInt64 / 2}, []int64{50, 50}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			parts, err := New(tt.amount, "USD").Allocate(tt.ratios...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(parts) != len(tt.want) {
+				t.Fatalf("got %d parts, want %d", len(parts), len(tt.want))
+			}
+			for i, part := range parts {
+				if part.Amount != tt.want[i] || part.Currency != "USD" {
+					t.Errorf("part %d is %d %s, want %d", i, part.Amount, part.Currency, tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestAllocateAddsUp(t *testing.T) {
+	for amount := int64(-50); amount <= 50; amount++ {
+		for _, ratios := range [][]int64{{1, 1, 1}, {1, 2, 3, 4}, {3, 7}, {0, 0, 1}, {13, 17, 19, 23}} {
+			parts, err := New(amount, "EUR").Allocate(ratios...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			total, err := Sum("EUR", parts...)
+			if err != nil || total.Amount != amount {
+				t.Errorf("%d allocated by %v adds up to %v, %v", amount, ratios, total, err)
+			}
+		}
+	}
+}
+
+func TestAllocateInvalidRatios(t *testing.T) {
+	for _, ratios := range [][]int64{nil, {0, 0}, {1, -1}, {math.MaxInt64, math.MaxInt64, 2}} {
+		if _, err := New(100, "USD").Allocate(ratios...); err == nil {
+			t.Errorf("ratios %v accepted", ratios)
+		}
+	}
+}
+
+func TestSplit(t *testing.T) {
+	hundred, err := Parse("100.00", "USD")
+	if err != nil {
+		t.Fatal(err)
+	}
+	parts, err := hundred.Split(3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, want := range []string{"33.34", "33.33", "33.33"} {
+		if parts[i].String() != want {
+			t.Errorf("part %d is %s, want %s", i, parts[i], want)
+		}
+	}
+
+	yen, err := New(1000, "JPY").Split(3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if yen[0].String() != "334" || yen[1].String() != "333" || yen[2].String() != "333" {
+		t.Errorf("1000 JPY split in %v", yen)
+	}
+
+	if _, err := hundred.Split(0); err == nil {
+		t.Error("split into no parts")
+	}
+}
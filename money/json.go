@@ -0,0 +1,67 @@
+package money
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+)
+
+// MarshalJSON writes the amount as a decimal string along with its currency, e.g.
+// {"amount":"33.33","currency":"USD"}, so clients never parse it into a float by accident.
+func (m Money) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Amount   string `json:"amount"`
+		Currency string `json:"currency"`
+	}{m.String(), m.Currency})
+}
+
+// UnmarshalJSON reads the object written by MarshalJSON. The amount may also be a number.
+func (m *Money) UnmarshalJSON(data []byte) error {
+	var v struct {
+		Amount   Decimal `json:"amount"`
+		Currency string  `json:"currency"`
+	}
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+
+	parsed, err := Parse(string(v.Amount), v.Currency)
+	if err != nil {
+		return err
+	}
+	*m = parsed
+	return nil
+}
+
+// Decimal is an amount in a request before its currency is known. It accepts a decimal
+// string such as "33.33" or a JSON number, which is kept as written rather than read into a
+// float. It is empty when the amount is missing or null.
+type Decimal string
+
+func (d *Decimal) UnmarshalJSON(data []byte) error {
+	data = bytes.TrimSpace(data)
+	switch {
+	case bytes.Equal(data, []byte("null")):
+		*d = ""
+		return nil
+	case len(data) > 0 && data[0] == '"':
+		var s string
+		if err := json.Unmarshal(data, &s); err != nil {
+			return err
+		}
+		*d = Decimal(s)
+		return nil
+	}
+
+	var n json.Number
+	if err := json.Unmarshal(data, &n); err != nil {
+		return fmt.Errorf("invalid amount %s, expected a decimal string", data)
+	}
+	*d = Decimal(n)
+	return nil
+}
+
+// Parse reads the amount in a currency, see Parse.
+func (d Decimal) Parse(currency string) (Money, error) {
+	return Parse(string(d), currency)
+}
@@ -0,0 +1,164 @@
+package money
+
+import (
+	"errors"
+	"math"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		input    string
+		currency string
+		amount   int64
+		err      bool
+	}{
+		{"33.33", "USD", 3333, false},
+		{"33.3", "USD", 3330, false},
+		{"33", "USD", 3300, false},
+		{".5", "USD", 50, false},
+		{"5.", "USD", 500, false},
+		{" 12.34 ", "usd", 1234, false},
+		{"+1.00", "USD", 100, false},
+		{"-0.05", "USD", -5, false},
+		{"-12.50", "EUR", -1250, false},
+		{"10.000", "USD", 1000, false},
+		{"10.001", "USD", 0, true}, // Would have to be rounded
+		{"0.005", "USD", 0, true},
+
+		// Currencies without minor units and with three decimals
+		{"1500", "JPY", 1500, false},
+		{"1500.00", "JPY", 1500, false},
+		{"1500.5", "JPY", 0, true},
+		{"-3", "KRW", -3, false},
+		{"1.234", "KWD", 1234, false},
+		{"1.2", "BHD", 1200, false},
+		{"1.2345", "KWD", 0, true},
+
+		{"", "USD", 0, true},
+		{"-", "USD", 0, true},
+		{".", "USD", 0, true},
+		{"1,00", "USD", 0, true},
+		{"1e3", "USD", 0, true},
+		{"--1", "USD", 0, true},
+		{"1.2.3", "USD", 0, true},
+		{"1", "US", 0, true},
+		{"92233720368547758.07", "USD", math.MaxInt64, false},
+		{"92233720368547758.08", "USD", 0, true},
+	}
+	for _, tt := range tests {
+		got, err := Parse(tt.input, tt.currency)
+		if tt.err {
+			if err == nil {
+				t.Errorf("Parse(%q, %s) = %v, want an error", tt.input, tt.currency, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Parse(%q, %s): %v", tt.input, tt.currency, err)
+			continue
+		}
+		if got.Amount != tt.amount || got.Currency != NormalizeCurrency(tt.currency) {
+			t.Errorf("Parse(%q, %s) = %d %s, want %d", tt.input, tt.currency, got.Amount, got.Currency, tt.amount)
+		}
+	}
+}
+
+func TestString(t *testing.T) {
+	tests := []struct {
+		money Money
+		want  string
+	}{
+		{New(3333, "USD"), "33.33"},
+		{New(5, "USD"), "0.05"},
+		{New(-5, "USD"), "-0.05"},
+		{New(0, "USD"), "0.00"},
+		{New(1500, "JPY"), "1500"},
+		{New(-1500, "JPY"), "-1500"},
+		{New(1234, "KWD"), "1.234"},
+		{New(math.MinInt64, "USD"), "-92233720368547758.08"},
+	}
+	for _, tt := range tests {
+		if got := tt.money.String(); got != tt.want {
+			t.Errorf("%d %s formats as %q, want %q", tt.money.Amount, tt.money.Currency, got, tt.want)
+		}
+
+		// The magnitude of the smallest amount doesn't fit, so it can't be parsed back
+		if tt.money.Amount == math.MinInt64 {
+			continue
+		}
+		parsed, err := Parse(tt.want, tt.money.Currency)
+		if err != nil || !parsed.Equal(tt.money) {
+			t.Errorf("%q parses back as %v, %v", tt.want, parsed, err)
+		}
+	}
+}
+
+func TestSumIsExact(t *testing.T) {
+	var amounts []Money
+	for _, s := range []string{"33.33", "33.33", "33.34"} {
+		amount, err := Parse(s, "USD")
+		if err != nil {
+			t.Fatal(err)
+		}
+		amounts = append(amounts, amount)
+	}
+
+	total, err := Sum("USD", amounts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hundred, _ := Parse("100.00", "USD")
+	if !total.Equal(hundred) {
+		t.Errorf("33.33 + 33.33 + 33.34 = %s", total)
+	}
+}
+
+func TestSum(t *testing.T) {
+	total, err := Sum("EUR")
+	if err != nil || !total.Equal(Zero("EUR")) {
+		t.Errorf("empty sum = %v, %v", total, err)
+	}
+
+	if _, err := Sum("EUR", New(1, "EUR"), New(1, "USD")); !errors.Is(err, ErrCurrencyMismatch) {
+		t.Errorf("sum of mixed currencies returned %v", err)
+	}
+	if _, err := Sum("USD", New(math.MaxInt64, "USD"), New(1, "USD")); !errors.Is(err, ErrOverflow) {
+		t.Errorf("overflowing sum returned %v", err)
+	}
+	if _, err := New(math.MinInt64, "USD").Add(New(-1, "USD")); !errors.Is(err, ErrOverflow) {
+		t.Errorf("underflowing sum returned %v", err)
+	}
+	if _, err := New(0, "USD").Sub(New(math.MinInt64, "USD")); !errors.Is(err, ErrOverflow) {
+		t.Errorf("0 - MinInt64 returned %v", err)
+	}
+
+	// A zero value has no currency and adds to any
+	total, err = Money{}.Add(New(5, "USD"))
+	if err != nil || !total.Equal(New(5, "USD")) {
+		t.Errorf("zero value + 0.05 USD = %v, %v", total, err)
+	}
+}
+
+func TestCmp(t *testing.T) {
+	tests := []struct {
+		a, b Money
+		want int
+	}{
+		{New(1, "USD"), New(2, "USD"), -1},
+		{New(2, "USD"), New(2, "USD"), 0},
+		{New(3, "USD"), New(2, "USD"), 1},
+		{New(-3, "USD"), New(2, "USD"), -1},
+		{Money{}, New(0, "USD"), 0},
+	}
+	for _, tt := range tests {
+		got, err := tt.a.Cmp(tt.b)
+		if err != nil || got != tt.want {
+			t.Errorf("%v.Cmp(%v) = %d, %v, want %d", tt.a, tt.b, got, err, tt.want)
+		}
+	}
+
+	if _, err := New(1, "USD").Cmp(New(1, "EUR")); !errors.Is(err, ErrCurrencyMismatch) {
+		t.Errorf("comparing currencies returned %v", err)
+	}
+}
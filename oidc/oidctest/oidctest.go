@@ -0,0 +1,195 @@
+// Package oidctest runs an OpenID Connect provider for tests. It serves the discovery
+// document, the signing keys and a token endpoint that checks the PKCE code verifier.
+package oidctest
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const keyID = "test-key"
+
+// Issuer is a running provider. Its issuer identifier is Server.URL followed by the path
+// it was created with, so it may end in a slash.
+type Issuer struct {
+	Server   *httptest.Server
+	Issuer   string
+	ClientID string
+
+	key *ecdsa.PrivateKey
+
+	mu    sync.Mutex
+	codes map[string]authorization
+}
+
+// authorization is an issued authorization code, waiting to be exchanged for tokens.
+type authorization struct {
+	codeChallenge string
+	redirectURI   string
+	claims        jwt.MapClaims
+}
+
+// NewIssuer starts a provider with the issuer identifier at path, e.g. "" or "/tenant/".
+// It is stopped when the test ends.
+func NewIssuer(t *testing.T, path, clientID string) *Issuer {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	issuer := &Issuer{ClientID: clientID, key: key, codes: make(map[string]authorization)}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", issuer.discovery)
+	if base := strings.TrimSuffix(path, "/"); base != "" {
+		mux.HandleFunc(base+"/.well-known/openid-configuration", issuer.discovery)
+	}
+	mux.HandleFunc("/jwks", issuer.jwks)
+	mux.HandleFunc("/token", issuer.token)
+
+	issuer.Server = httptest.NewServer(mux)
+	issuer.Issuer = issuer.Server.URL + path
+	t.Cleanup(issuer.Server.Close)
+	return issuer
+}
+
+// Authorize plays the user signing in at the authorization URL and returns the code the
+// provider sends back. The ID token issued for the code has valid claims for the request,
+// overridden by claims; a nil value removes a claim.
+func (i *Issuer) Authorize(authURL string, claims jwt.MapClaims) (code, state string, err error) {
+	u, err := url.Parse(authURL)
+	if err != nil {
+		return "", "", err
+	}
+	query := u.Query()
+	if query.Get("client_id") != i.ClientID || query.Get("response_type") != "code" {
+		return "", "", errors.New("invalid authorization request")
+	}
+	if query.Get("code_challenge_method") != "S256" || query.Get("code_challenge") == "" {
+		return "", "", errors.New("authorization request without PKCE")
+	}
+
+	idClaims := i.Claims()
+	idClaims["nonce"] = query.Get("nonce")
+	for name, value := range claims {
+		if value == nil {
+			delete(idClaims, name)
+		} else {
+			idClaims[name] = value
+		}
+	}
+
+	code = randomString()
+	i.mu.Lock()
+	i.codes[code] = authorization{
+		codeChallenge: query.Get("code_challenge"),
+		redirectURI:   query.Get("redirect_uri"),
+		claims:        idClaims,
+	}
+	i.mu.Unlock()
+
+	return code, query.Get("state"), nil
+}
+
+// Claims returns valid ID token claims for a verified user, without a nonce.
+func (i *Issuer) Claims() jwt.MapClaims {
+	now := time.Now()
+	return jwt.MapClaims{
+		"iss":            i.Issuer,
+		"aud":            i.ClientID,
+		"sub":            "user-1",
+		"email":          "user@example.com",
+		"email_verified": true,
+		"name":           "Test User",
+		"iat":            now.Unix(),
+		"exp":            now.Add(5 * time.Minute).Unix(),
+	}
+}
+
+// IDToken signs claims with the key of the provider.
+func (i *Issuer) IDToken(claims jwt.MapClaims) string {
+	token := jwt.NewWithClaims(jwt.SigningMethodES256, claims)
+	token.Header["kid"] = keyID
+	signed, err := token.SignedString(i.key)
+	if err != nil {
+		panic(err)
+	}
+	return signed
+}
+
+func (i *Issuer) discovery(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]string{
+		"issuer":                 i.Issuer,
+		"authorization_endpoint": i.Server.URL + "/authorize",
+		"token_endpoint":         i.Server.URL + "/token",
+		"jwks_uri":               i.Server.URL + "/jwks",
+	})
+}
+
+func (i *Issuer) jwks(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"keys": []map[string]string{{
+			"kty": "EC",
+			"kid": keyID,
+			"use": "sig",
+			"crv": "P-256",
+			"x":   base64.RawURLEncoding.EncodeToString(i.key.X.FillBytes(make([]byte, 32))),
+			"y":   base64.RawURLEncoding.EncodeToString(i.key.Y.FillBytes(make([]byte, 32))),
+		}},
+	})
+}
+
+// token exchanges an authorization code once, if the code verifier matches its challenge.
+func (i *Issuer) token(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost || r.ParseForm() != nil || r.PostForm.Get("grant_type") != "authorization_code" {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_request"})
+		return
+	}
+
+	i.mu.Lock()
+	auth, ok := i.codes[r.PostForm.Get("code")]
+	delete(i.codes, r.PostForm.Get("code"))
+	i.mu.Unlock()
+
+	verifier := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+	if !ok || r.PostForm.Get("client_id") != i.ClientID || r.PostForm.Get("redirect_uri") != auth.redirectURI ||
+		base64.RawURLEncoding.EncodeToString(verifier[:]) != auth.codeChallenge {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_grant"})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]string{
+		"access_token": randomString(),
+		"token_type":   "Bearer",
+		"id_token":     i.IDToken(auth.claims),
+	})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func randomString() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b)
+}
@@ -0,0 +1,154 @@
+package oidc
+
+import (
+	"context"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/yasharya2901/smart_divide/oidc/oidctest"
+)
+
+const testClientID = "smart-divide"
+
+func newTestProvider(issuer *oidctest.Issuer) *Provider {
+	return NewProvider(Config{
+		Name:        "test",
+		Issuer:      issuer.Issuer,
+		ClientID:    testClientID,
+		RedirectURL: "https://app.example.com/callback",
+	})
+}
+
+func TestIssuerIsComparedExactly(t *testing.T) {
+	for _, path := range []string{"", "/", "/tenant", "/tenant/"} {
+		t.Run(path, func(t *testing.T) {
+			issuer := oidctest.NewIssuer(t, path, testClientID)
+			provider := newTestProvider(issuer)
+
+			claims := issuer.Claims()
+			claims["nonce"] = "nonce"
+			if _, err := provider.VerifyIDToken(context.Background(), issuer.IDToken(claims), "nonce"); err != nil {
+				t.Fatalf("issuer %q: %v", issuer.Issuer, err)
+			}
+
+			// The same issuer with or without a trailing slash is another issuer
+			claims["iss"] = strings.TrimSuffix(issuer.Issuer, "/")
+			if strings.HasSuffix(issuer.Issuer, "/") {
+				if _, err := provider.VerifyIDToken(context.Background(), issuer.IDToken(claims), "nonce"); err == nil {
+					t.Errorf("issuer %q accepted for %q", claims["iss"], issuer.Issuer)
+				}
+			}
+		})
+	}
+}
+
+func TestDiscoveryIssuerMismatch(t *testing.T) {
+	issuer := oidctest.NewIssuer(t, "", testClientID)
+	provider := NewProvider(Config{Issuer: issuer.Issuer + "/", ClientID: testClientID})
+
+	if _, err := provider.AuthCodeURL(context.Background(), "state", "nonce", "challenge"); err == nil {
+		t.Fatal("discovery document of another issuer accepted")
+	}
+}
+
+func TestVerifyIDToken(t *testing.T) {
+	issuer := oidctest.NewIssuer(t, "/", testClientID)
+	other := oidctest.NewIssuer(t, "/", testClientID)
+	provider := newTestProvider(issuer)
+
+	tests := []struct {
+		name   string
+		claims jwt.MapClaims
+		nonce  string
+		sign   *oidctest.Issuer
+		valid  bool
+	}{
+		{name: "valid", valid: true},
+		{name: "wrong nonce", nonce: "other"},
+		{name: "wrong audience", claims: jwt.MapClaims{"aud": "another-client"}},
+		{name: "no audience", claims: jwt.MapClaims{"aud": nil}},
+		{name: "expired", claims: jwt.MapClaims{"exp": time.Now().Add(-time.Minute).Unix()}},
+		{name: "no expiry", claims: jwt.MapClaims{"exp": nil}},
+		{name: "wrong issuer", claims: jwt.MapClaims{"iss": other.Issuer}},
+		{name: "no subject", claims: jwt.MapClaims{"sub": nil}},
+		{name: "signed by another key", sign: other},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims := issuer.Claims()
+			claims["nonce"] = "nonce"
+			for name, value := range tt.claims {
+				if value == nil {
+					delete(claims, name)
+				} else {
+					claims[name] = value
+				}
+			}
+			nonce := tt.nonce
+			if nonce == "" {
+				nonce = "nonce"
+			}
+			sign := tt.sign
+			if sign == nil {
+				sign = issuer
+			}
+
+			verified, err := provider.VerifyIDToken(context.Background(), sign.IDToken(claims), nonce)
+			if tt.valid {
+				if err != nil {
+					t.Fatal(err)
+				}
+				if verified.Subject != "user-1" || verified.Email != "user@example.com" || !verified.EmailVerified {
+					t.Errorf("claims %+v", verified)
+				}
+			} else if err == nil {
+				t.Fatal("invalid id token accepted")
+			}
+		})
+	}
+}
+
+func TestExchangeChecksCodeVerifier(t *testing.T) {
+	issuer := oidctest.NewIssuer(t, "", testClientID)
+	provider := newTestProvider(issuer)
+	ctx := context.Background()
+
+	authURL, err := provider.AuthCodeURL(ctx, "state", "nonce", CodeChallenge("verifier"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, _ := url.Parse(authURL)
+	if u.Query().Get("code_challenge_method") != "S256" || u.Query().Get("redirect_uri") != "https://app.example.com/callback" {
+		t.Errorf("authorization URL %s", authURL)
+	}
+
+	code, state, err := issuer.Authorize(authURL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state != "state" {
+		t.Errorf("state %q", state)
+	}
+	if _, err := provider.Exchange(ctx, code, "another verifier"); err == nil {
+		t.Fatal("code exchanged with the wrong verifier")
+	}
+
+	code, _, err = issuer.Authorize(authURL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, err := provider.Exchange(ctx, code, "verifier")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := provider.VerifyIDToken(ctx, token.IDToken, "nonce"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := provider.Exchange(ctx, code, "verifier"); err == nil {
+		t.Fatal("code exchanged twice")
+	}
+}
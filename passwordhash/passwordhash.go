@@ -0,0 +1,82 @@
+package passwordhash
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/yasharya2901/smart_divide/config"
+)
+
+// ErrUnknownHash is returned for a stored hash none of the hashers recognize, including an empty one.
+var ErrUnknownHash = errors.New("unknown password hash format")
+
+// Hasher hashes passwords into a self-describing string that carries the algorithm, its
+// parameters and the salt, so hashes made with older parameters can still be verified.
+type Hasher interface {
+	Hash(password string) (string, error)
+	// Verify reports whether password matches an encoded hash made by this kind of hasher.
+	Verify(encoded, password string) (bool, error)
+	// Recognizes reports whether the encoded hash was made by this kind of hasher.
+	Recognizes(encoded string) bool
+	// NeedsRehash reports whether the encoded hash was made with other parameters than the hasher's.
+	NeedsRehash(encoded string) bool
+}
+
+// Manager hashes new passwords with the preferred hasher and verifies hashes made by any
+// of the supported hashers.
+type Manager struct {
+	preferred Hasher
+	hashers   []Hasher
+}
+
+// New creates a manager hashing with preferred, which also accepts hashes made by the others.
+func New(preferred Hasher, others ...Hasher) *Manager {
+	return &Manager{preferred: preferred, hashers: append([]Hasher{preferred}, others...)}
+}
+
+// NewFromConfig creates the manager selected by the hasher of the config, "argon2id" or
+// "bcrypt", with the configured cost. Hashes of either algorithm are always verified, so
+// switching algorithms upgrades hashes as people log in.
+func NewFromConfig(cfg config.PasswordHash) (*Manager, error) {
+	argon2id := NewArgon2id(Argon2idParams{
+		Memory:      cfg.Argon2Memory,
+		Iterations:  cfg.Argon2Iterations,
+		Parallelism: cfg.Argon2Parallelism,
+		SaltLength:  DefaultArgon2idParams.SaltLength,
+		KeyLength:   DefaultArgon2idParams.KeyLength,
+	})
+	bcryptHasher := NewBcrypt(cfg.BcryptCost)
+
+	switch hasher := cfg.Hasher; hasher {
+	case "argon2id":
+		return New(argon2id, bcryptHasher), nil
+	case "bcrypt":
+		return New(bcryptHasher, argon2id), nil
+	default:
+		return nil, fmt.Errorf("unknown password hasher %q", hasher)
+	}
+}
+
+// Hash hashes a new password with the preferred hasher.
+func (m *Manager) Hash(password string) (string, error) {
+	return m.preferred.Hash(password)
+}
+
+// Verify reports whether password matches the encoded hash. needsRehash is set for a
+// matching password whose hash was made by another hasher or with other parameters.
+func (m *Manager) Verify(encoded, password string) (ok bool, needsRehash bool, err error) {
+	for _, hasher := range m.hashers {
+		if !hasher.Recognizes(encoded) {
+			continue
+		}
+
+		ok, err := hasher.Verify(encoded, password)
+		if err != nil || !ok {
+			return false, false, err
+		}
+
+		return true, hasher != m.preferred || hasher.NeedsRehash(encoded), nil
+	}
+
+	return false, false, ErrUnknownHash
+}
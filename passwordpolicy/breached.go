@@ -0,0 +1,119 @@
+package passwordpolicy
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// prefixLength is the number of hex characters of the SHA-1 hash a range is looked up by,
+// as in the k-anonymity range API of Have I Been Pwned.
+const prefixLength = 5
+
+// BreachedList checks passwords against locally stored SHA-1 hashes of breached passwords.
+// Lookups never leave the machine. Two layouts are supported:
+//
+//   - A single file with one upper or lower case hex SHA-1 hash per line, optionally followed
+//     by ":count", such as the Have I Been Pwned "ordered by hash" download. It is loaded into
+//     memory, grouped by hash prefix.
+//   - A directory of range files named after the 5 character hash prefix, e.g. "5BAA6.txt",
+//     each holding the remaining 35 characters of the hashes as "SUFFIX:COUNT" lines, the
+//     layout produced by the Have I Been Pwned downloader. Only the range of the password
+//     being checked is read, so the list can be arbitrarily large.
+type BreachedList struct {
+	dir    string
+	ranges map[string]map[string]struct{} // Hash suffixes by prefix, for single file lists
+}
+
+// LoadBreachedList opens the list at path, a file or a directory of range files.
+func LoadBreachedList(path string) (*BreachedList, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open breached password list: %w", err)
+	}
+	if info.IsDir() {
+		return &BreachedList{dir: path}, nil
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open breached password list: %w", err)
+	}
+	defer file.Close()
+
+	list := &BreachedList{ranges: make(map[string]map[string]struct{})}
+	scanner := bufio.NewScanner(file)
+	for line := 1; scanner.Scan(); line++ {
+		hash := hashFromLine(scanner.Text())
+		if hash == "" {
+			continue
+		}
+		if len(hash) != sha1.Size*2 {
+			return nil, fmt.Errorf("invalid hash on line %d of the breached password list", line)
+		}
+
+		prefix, suffix := hash[:prefixLength], hash[prefixLength:]
+		if list.ranges[prefix] == nil {
+			list.ranges[prefix] = make(map[string]struct{})
+		}
+		list.ranges[prefix][suffix] = struct{}{}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read breached password list: %w", err)
+	}
+
+	return list, nil
+}
+
+// Contains reports whether the password is in the list. Errors reading a range file are
+// treated as the password not being listed, so a damaged list can't block every password.
+func (bl *BreachedList) Contains(password string) bool {
+	sum := sha1.Sum([]byte(password))
+	hash := strings.ToUpper(hex.EncodeToString(sum[:]))
+	prefix, suffix := hash[:prefixLength], hash[prefixLength:]
+
+	if bl.dir == "" {
+		_, found := bl.ranges[prefix][suffix]
+		return found
+	}
+
+	found, err := bl.searchRangeFile(prefix, suffix)
+	return err == nil && found
+}
+
+func (bl *BreachedList) searchRangeFile(prefix, suffix string) (bool, error) {
+	file, err := os.Open(filepath.Join(bl.dir, prefix+".txt"))
+	if errors.Is(err, fs.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		if hashFromLine(scanner.Text()) == suffix {
+			return true, nil
+		}
+	}
+	return false, scanner.Err()
+}
+
+// hashFromLine returns the upper case hash of a "HASH" or "HASH:COUNT" line, or an empty
+// string for blank lines and comments.
+func hashFromLine(line string) string {
+	line = strings.TrimSpace(line)
+	if line == "" || strings.HasPrefix(line, "#") {
+		return ""
+	}
+
+	hash, _, _ := strings.Cut(line, ":")
+	return strings.ToUpper(strings.TrimSpace(hash))
+}
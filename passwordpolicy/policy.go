@@ -0,0 +1,174 @@
+package passwordpolicy
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/yasharya2901/smart_divide/config"
+)
+
+// Violation codes returned to clients.
+const (
+	CodeTooShort             = "too_short"
+	CodeTooLong              = "too_long"
+	CodeMissingUppercase     = "missing_uppercase"
+	CodeMissingLowercase     = "missing_lowercase"
+	CodeMissingDigit         = "missing_digit"
+	CodeMissingSymbol        = "missing_symbol"
+	CodeContainsPersonalInfo = "contains_personal_info"
+	CodeBreached             = "breached"
+)
+
+// defaultBreachedList is the breached password list shipped with the server.
+const defaultBreachedList = "data/breached_passwords.txt"
+
+// minPersonalInfoLength is the shortest name part or email local part that may not appear in a password.
+const minPersonalInfoLength = 3
+
+// Violation is a rule a password breaks.
+type Violation struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// Error is returned for a password that breaks one or more rules of the policy.
+type Error struct {
+	Violations []Violation
+}
+
+func (e *Error) Error() string {
+	messages := make([]string, len(e.Violations))
+	for i, violation := range e.Violations {
+		messages[i] = violation.Message
+	}
+	return "password does not meet the policy: " + strings.Join(messages, ", ")
+}
+
+// Policy is the set of rules passwords must follow.
+type Policy struct {
+	MinLength            int  `json:"min_length"`
+	MaxLength            int  `json:"max_length"`
+	RequireUppercase     bool `json:"require_uppercase"`
+	RequireLowercase     bool `json:"require_lowercase"`
+	RequireDigit         bool `json:"require_digit"`
+	RequireSymbol        bool `json:"require_symbol"`
+	DisallowPersonalInfo bool `json:"disallow_personal_info"`
+	CheckBreached        bool `json:"check_breached"`
+
+	breached *BreachedList
+}
+
+// New creates the policy configured by cfg. BreachedList points to a local breached password
+// list, see LoadBreachedList. When it is empty the shipped list is used if present, and
+// "none" disables the check.
+func New(cfg config.Password) (*Policy, error) {
+	policy := &Policy{
+		MinLength:            cfg.MinLength,
+		MaxLength:            cfg.MaxLength,
+		RequireUppercase:     cfg.RequireUppercase,
+		RequireLowercase:     cfg.RequireLowercase,
+		RequireDigit:         cfg.RequireDigit,
+		RequireSymbol:        cfg.RequireSymbol,
+		DisallowPersonalInfo: cfg.DisallowPersonalInfo,
+	}
+
+	path := cfg.BreachedList
+	if path == "" {
+		if _, err := os.Stat(defaultBreachedList); err == nil {
+			path = defaultBreachedList
+		}
+	}
+
+	if path != "" && path != "none" {
+		breached, err := LoadBreachedList(path)
+		if err != nil {
+			return nil, err
+		}
+		policy.breached = breached
+		policy.CheckBreached = true
+	}
+
+	return policy, nil
+}
+
+// Check returns an *Error listing every rule the password breaks, or nil. The personal
+// details, such as the name and email of the person, may not appear in the password.
+func (p *Policy) Check(password string, personal ...string) error {
+	var violations []Violation
+	add := func(code, message string) {
+		violations = append(violations, Violation{Code: code, Message: message})
+	}
+
+	length := utf8.RuneCountInString(password)
+	if length < p.MinLength {
+		add(CodeTooShort, fmt.Sprintf("must be at least %d characters long", p.MinLength))
+	}
+	if p.MaxLength > 0 && len(password) > p.MaxLength {
+		add(CodeTooLong, fmt.Sprintf("must be at most %d bytes long", p.MaxLength))
+	}
+
+	var hasUpper, hasLower, hasDigit, hasSymbol bool
+	for _, r := range password {
+		switch {
+		case unicode.IsUpper(r):
+			hasUpper = true
+		case unicode.IsLower(r):
+			hasLower = true
+		case unicode.IsDigit(r):
+			hasDigit = true
+		case unicode.IsPunct(r) || unicode.IsSymbol(r) || unicode.IsSpace(r):
+			hasSymbol = true
+		}
+	}
+	if p.RequireUppercase && !hasUpper {
+		add(CodeMissingUppercase, "must contain an uppercase letter")
+	}
+	if p.RequireLowercase && !hasLower {
+		add(CodeMissingLowercase, "must contain a lowercase letter")
+	}
+	if p.RequireDigit && !hasDigit {
+		add(CodeMissingDigit, "must contain a digit")
+	}
+	if p.RequireSymbol && !hasSymbol {
+		add(CodeMissingSymbol, "must contain a symbol")
+	}
+
+	if p.DisallowPersonalInfo && containsPersonalInfo(password, personal) {
+		add(CodeContainsPersonalInfo, "must not contain your name or email")
+	}
+
+	if p.breached != nil && p.breached.Contains(password) {
+		add(CodeBreached, "appears in a list of breached passwords")
+	}
+
+	if len(violations) > 0 {
+		return &Error{Violations: violations}
+	}
+	return nil
+}
+
+// containsPersonalInfo reports whether the password contains a part of a name or the
+// local part of an email, ignoring case.
+func containsPersonalInfo(password string, personal []string) bool {
+	password = strings.ToLower(password)
+
+	for _, value := range personal {
+		value = strings.ToLower(strings.TrimSpace(value))
+		if local, _, found := strings.Cut(value, "@"); found {
+			value = local
+		}
+
+		parts := strings.FieldsFunc(value, func(r rune) bool {
+			return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+		})
+		for _, part := range parts {
+			if utf8.RuneCountInString(part) >= minPersonalInfoLength && strings.Contains(password, part) {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,39 @@
+package routes
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/yasharya2901/smart_divide/config"
+	"github.com/yasharya2901/smart_divide/handlers"
+	"github.com/yasharya2901/smart_divide/keyring"
+	"github.com/yasharya2901/smart_divide/mailer"
+	"github.com/yasharya2901/smart_divide/middleware"
+	"github.com/yasharya2901/smart_divide/passwordhash"
+	"github.com/yasharya2901/smart_divide/services"
+	"gorm.io/gorm"
+)
+
+func AdminRoutes(rg *gin.RouterGroup, db *gorm.DB, cfg *config.Config, mail mailer.Mailer, passwords *passwordhash.Manager, attempts services.LoginAttemptStore, keyRing *keyring.KeyRing, auditLog *services.AuditLog) {
+	admin := rg.Group("/admin", middleware.Authenticate(db, keyRing, auditLog), middleware.RequireAdmin(db))
+	var adminHandler = handlers.NewAdminHandler(db, cfg, mail, passwords, attempts, keyRing, auditLog)
+	var keyHandler = handlers.NewKeyHandler(keyRing, auditLog)
+	var auditHandler = handlers.NewAuditHandler(auditLog)
+
+	// User management
+	people := admin.Group("/people")
+	people.GET("/", adminHandler.SearchPeople())
+	people.GET("/:id", adminHandler.GetAccount())
+	people.POST("/:id/lock", adminHandler.LockAccount())
+	people.POST("/:id/unlock", adminHandler.UnlockAccount())
+	people.POST("/:id/logout", adminHandler.ForceLogout())
+	people.PUT("/:id/admin", adminHandler.SetAdmin())
+
+	// Read-only access to an account, for support cases
+	people.POST("/:id/impersonate", adminHandler.Impersonate())
+
+	// Signing key management
+	admin.GET("/keys", keyHandler.GetKeys())
+	admin.POST("/keys/rotate", keyHandler.RotateKeys())
+
+	// Audit log search
+	admin.GET("/security-events", auditHandler.QuerySecurityEvents())
+}
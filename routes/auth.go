@@ -2,15 +2,96 @@ package routes
 
 import (
 	"github.com/gin-gonic/gin"
+	"github.com/go-webauthn/webauthn/webauthn"
+	"github.com/yasharya2901/smart_divide/config"
 	"github.com/yasharya2901/smart_divide/handlers"
+	"github.com/yasharya2901/smart_divide/mailer"
+	"github.com/yasharya2901/smart_divide/middleware"
+	"github.com/yasharya2901/smart_divide/oidc"
+	"github.com/yasharya2901/smart_divide/passwordhash"
+	"github.com/yasharya2901/smart_divide/passwordpolicy"
+	"github.com/yasharya2901/smart_divide/services"
+	"github.com/yasharya2901/smart_divide/sms"
+	"github.com/yasharya2901/smart_divide/utils"
 	"gorm.io/gorm"
 )
 
-func AuthRoutes(rg *gin.RouterGroup, db *gorm.DB) {
+func AuthRoutes(rg *gin.RouterGroup, db *gorm.DB, cfg *config.Config, mail mailer.Mailer, sender sms.Sender, providers map[string]*oidc.Provider, passkeys *webauthn.WebAuthn, attempts services.LoginAttemptStore, accessKeys utils.KeySet, auditLog *services.AuditLog, passwordPolicy *passwordpolicy.Policy, passwords *passwordhash.Manager) {
 	auth := rg.Group("/")
-	var authHandler = handlers.NewAuthHandler(db)
+	var authHandler = handlers.NewAuthHandler(db, cfg, mail, sender, providers, passkeys, attempts, accessKeys, auditLog, passwordPolicy, passwords)
+	var apiKeyHandler = handlers.NewAPIKeyHandler(db, auditLog)
 
 	// Login and Register
 	auth.POST("/login", authHandler.Login())
 	auth.POST("/register", authHandler.Register())
+	auth.POST("/login/2fa", authHandler.CompleteTwoFactorLogin())
+
+	// Token rotation
+	auth.POST("/refresh", authHandler.Refresh())
+
+	// Password rules, so clients can validate before submitting
+	auth.GET("/password-policy", authHandler.GetPasswordPolicy())
+
+	// Password recovery
+	auth.POST("/forgot-password", authHandler.ForgotPassword())
+	auth.POST("/reset-password", authHandler.ResetPassword())
+
+	// Unlocking an account locked by failed logins
+	auth.POST("/unlock", authHandler.UnlockAccount())
+
+	// Phone number login with one-time codes
+	auth.POST("/otp/request", authHandler.RequestLoginOTP())
+	auth.POST("/otp/login", authHandler.LoginWithOTP())
+
+	// Sign in with an OpenID Connect provider
+	auth.GET("/oidc/:provider/login", authHandler.OIDCLogin())
+	auth.GET("/oidc/:provider/callback", authHandler.OIDCCallback())
+
+	// Passwordless login with an emailed link
+	auth.POST("/magic-link", authHandler.RequestMagicLink())
+	auth.POST("/magic-link/login", authHandler.LoginWithMagicLink())
+
+	// Passwordless login with a passkey
+	auth.POST("/passkeys/login/begin", authHandler.BeginPasskeyLogin())
+	auth.POST("/passkeys/login/finish", authHandler.FinishPasskeyLogin())
+
+	// Email verification
+	auth.POST("/verify-email", authHandler.VerifyEmail())
+
+	// Authenticated account routes
+	authenticated := auth.Group("/", middleware.Authenticate(db, accessKeys, auditLog), middleware.RequireSession())
+	authenticated.POST("/logout", authHandler.Logout())
+	authenticated.POST("/change-password", authHandler.ChangePassword())
+	authenticated.DELETE("/account", authHandler.DeleteAccount())
+	authenticated.POST("/resend-verification", authHandler.ResendVerification())
+	authenticated.POST("/contact/verify/request", authHandler.RequestContactVerification())
+	authenticated.POST("/contact/verify", authHandler.VerifyContact())
+	authenticated.GET("/lockouts", authHandler.GetLockouts())
+
+	// Two-factor authentication
+	twoFactor := authenticated.Group("/2fa")
+	twoFactor.POST("/enroll", authHandler.EnrollTwoFactor())
+	twoFactor.POST("/confirm", authHandler.ConfirmTwoFactor())
+	twoFactor.POST("/disable", authHandler.DisableTwoFactor())
+	twoFactor.POST("/recovery-codes", authHandler.RegenerateRecoveryCodes())
+
+	// Passkeys of the logged in person
+	passkeyRoutes := authenticated.Group("/passkeys")
+	passkeyRoutes.GET("/", authHandler.GetPasskeys())
+	passkeyRoutes.POST("/register/begin", authHandler.BeginPasskeyRegistration())
+	passkeyRoutes.POST("/register/finish", authHandler.FinishPasskeyRegistration())
+	passkeyRoutes.PUT("/:id", authHandler.RenamePasskey())
+	passkeyRoutes.DELETE("/:id", authHandler.DeletePasskey())
+
+	// Personal API keys
+	apiKeys := authenticated.Group("/api-keys")
+	apiKeys.GET("/", apiKeyHandler.GetAPIKeys())
+	apiKeys.POST("/", apiKeyHandler.CreateAPIKey())
+	apiKeys.DELETE("/:id", apiKeyHandler.RevokeAPIKey())
+
+	// Session management for the logged in person
+	sessions := authenticated.Group("/sessions")
+	sessions.GET("/", authHandler.GetSessions())
+	sessions.DELETE("/", authHandler.RevokeOtherSessions())
+	sessions.DELETE("/:id", authHandler.RevokeSession())
 }
@@ -2,25 +2,32 @@ package routes
 
 import (
 	"github.com/gin-gonic/gin"
+	"github.com/yasharya2901/smart_divide/config"
 	"github.com/yasharya2901/smart_divide/handlers"
+	"github.com/yasharya2901/smart_divide/middleware"
+	"github.com/yasharya2901/smart_divide/services"
 	"gorm.io/gorm"
 )
 
-func EventRoutes(rg *gin.RouterGroup, db *gorm.DB) {
+func EventRoutes(rg *gin.RouterGroup, db *gorm.DB, cfg *config.Config) {
 	event := rg.Group("/events")
-	var eventHandler = handlers.NewEventHandler(db)
+	var eventHandler = handlers.NewEventHandler(db, cfg)
 
 	// Base event routes
-	event.GET("/", eventHandler.GetEvents())
-	event.POST("/", eventHandler.CreateEvent())
+	event.GET("/", middleware.RequireScope(services.ScopeEventsRead), eventHandler.GetEvents())
+	event.POST("/", middleware.RequireScope(services.ScopeEventsWrite), eventHandler.CreateEvent())
 
 	// Single event routes
-	event.GET("/:id", eventHandler.GetEvent())
-	event.PUT("/:id", eventHandler.UpdateEvent())
-	event.DELETE("/:id", eventHandler.DeleteEvent())
+	event.GET("/:id", middleware.RequireScope(services.ScopeEventsRead), eventHandler.GetEvent())
+	event.PUT("/:id", middleware.RequireScope(services.ScopeEventsWrite), eventHandler.UpdateEvent())
+	event.DELETE("/:id", middleware.RequireScope(services.ScopeEventsWrite), eventHandler.DeleteEvent())
 
 	// People management routes - use different base path
 	people := event.Group("/:id/members")
-	people.POST("/:personId", eventHandler.AddPersonToEvent())
-	people.DELETE("/:personId", eventHandler.RemovePersonFromEvent())
+	people.GET("/", middleware.RequireScope(services.ScopeEventsRead), eventHandler.GetMembers())
+	people.GET("/suggestions", middleware.RequireScope(services.ScopeEventsRead), eventHandler.GetMemberSuggestions())
+	people.POST("/placeholders", middleware.RequireScope(services.ScopeEventsWrite), eventHandler.AddPlaceholder())
+	people.POST("/:personId", middleware.RequireScope(services.ScopeEventsWrite), eventHandler.AddPersonToEvent())
+	people.PUT("/:personId", middleware.RequireScope(services.ScopeEventsWrite), eventHandler.UpdateMemberRole())
+	people.DELETE("/:personId", middleware.RequireScope(services.ScopeEventsWrite), eventHandler.RemovePersonFromEvent())
 }
@@ -3,6 +3,8 @@ package routes
 import (
 	"github.com/gin-gonic/gin"
 	"github.com/yasharya2901/smart_divide/handlers"
+	"github.com/yasharya2901/smart_divide/middleware"
+	"github.com/yasharya2901/smart_divide/services"
 	"gorm.io/gorm"
 )
 
@@ -12,22 +14,25 @@ func ExpenseRoutes(rg *gin.RouterGroup, db *gorm.DB) {
 	expenseHandler := handlers.NewExpenseHandler(db)
 
 	// Base expense routes
-	expenses.GET("/", expenseHandler.GetExpenses())
-	expenses.POST("/", expenseHandler.CreateExpense())
+	expenses.GET("/", middleware.RequireScope(services.ScopeExpensesRead), expenseHandler.GetExpenses())
+	expenses.POST("/", middleware.RequireScope(services.ScopeExpensesWrite), expenseHandler.CreateExpense())
 
 	// Single expense routes
-	expenses.GET("/:id", expenseHandler.GetExpense())
-	expenses.PUT("/:id", expenseHandler.UpdateExpense())
-	expenses.DELETE("/:id", expenseHandler.DeleteExpense())
+	expenses.GET("/:id", middleware.RequireScope(services.ScopeExpensesRead), expenseHandler.GetExpense())
+	expenses.PUT("/:id", middleware.RequireScope(services.ScopeExpensesWrite), expenseHandler.UpdateExpense())
+	expenses.DELETE("/:id", middleware.RequireScope(services.ScopeExpensesWrite), expenseHandler.DeleteExpense())
 
 	// Expense participants management routes
 	participants := expenses.Group("/:id/participants")
-	participants.GET("/", expenseHandler.GetParticipants())
-	participants.POST("/", expenseHandler.AddParticipant())
-	participants.PUT("/:personId", expenseHandler.UpdateParticipant())
-	participants.DELETE("/:personId", expenseHandler.RemoveParticipant())
+	participants.GET("/", middleware.RequireScope(services.ScopeExpensesRead), expenseHandler.GetParticipants())
+	participants.POST("/", middleware.RequireScope(services.ScopeExpensesWrite), expenseHandler.AddParticipant())
+	participants.PUT("/:personId", middleware.RequireScope(services.ScopeExpensesWrite), expenseHandler.UpdateParticipant())
+	participants.DELETE("/:personId", middleware.RequireScope(services.ScopeExpensesWrite), expenseHandler.RemoveParticipant())
+
+	// Split the total evenly between the participants
+	expenses.POST("/:id/split", middleware.RequireScope(services.ScopeExpensesWrite), expenseHandler.SplitExpenseEvenly())
 
 	// Check for payment consistency
-	expenses.GET("/:id/check", expenseHandler.CheckExpenseConsistency())
+	expenses.GET("/:id/check", middleware.RequireScope(services.ScopeExpensesRead), expenseHandler.CheckExpenseConsistency())
 
 }
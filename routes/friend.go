@@ -0,0 +1,24 @@
+package routes
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/yasharya2901/smart_divide/handlers"
+	"github.com/yasharya2901/smart_divide/middleware"
+	"github.com/yasharya2901/smart_divide/services"
+	"gorm.io/gorm"
+)
+
+func FriendRoutes(rg *gin.RouterGroup, db *gorm.DB) {
+	friends := rg.Group("/friends")
+	var friendHandler = handlers.NewFriendHandler(db)
+
+	friends.GET("/", middleware.RequireScope(services.ScopePeopleRead), friendHandler.GetFriends())
+	friends.DELETE("/:personId", middleware.RequireScope(services.ScopePeopleWrite), friendHandler.RemoveFriend())
+
+	// Friend requests sent and received
+	requests := friends.Group("/requests")
+	requests.GET("/", middleware.RequireScope(services.ScopePeopleRead), friendHandler.GetRequests())
+	requests.POST("/", middleware.RequireScope(services.ScopePeopleWrite), friendHandler.SendRequest())
+	requests.POST("/:id/accept", middleware.RequireScope(services.ScopePeopleWrite), friendHandler.AcceptRequest())
+	requests.DELETE("/:id", middleware.RequireScope(services.ScopePeopleWrite), friendHandler.DeleteRequest())
+}
@@ -0,0 +1,24 @@
+package routes
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/yasharya2901/smart_divide/config"
+	"github.com/yasharya2901/smart_divide/handlers"
+	"github.com/yasharya2901/smart_divide/middleware"
+	"github.com/yasharya2901/smart_divide/services"
+	"gorm.io/gorm"
+)
+
+func InvitationRoutes(rg *gin.RouterGroup, db *gorm.DB, cfg *config.Config) {
+	var invitationHandler = handlers.NewInvitationHandler(db, cfg)
+
+	// Invitation links of an event, managed by its owners and admins
+	invitations := rg.Group("/events/:id/invitations")
+	invitations.GET("/", middleware.RequireScope(services.ScopeEventsRead), invitationHandler.GetInvitations())
+	invitations.POST("/", middleware.RequireScope(services.ScopeEventsWrite), invitationHandler.CreateInvitation())
+	invitations.GET("/:invitationId/qr", middleware.RequireScope(services.ScopeEventsRead), invitationHandler.GetInvitationQRCode())
+	invitations.DELETE("/:invitationId", middleware.RequireScope(services.ScopeEventsWrite), invitationHandler.RevokeInvitation())
+
+	// Joining an event with an invitation
+	rg.POST("/invitations/redeem", middleware.RequireScope(services.ScopeEventsWrite), invitationHandler.RedeemInvitation())
+}
@@ -0,0 +1,16 @@
+package routes
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/yasharya2901/smart_divide/handlers"
+	"github.com/yasharya2901/smart_divide/keyring"
+	"github.com/yasharya2901/smart_divide/services"
+)
+
+func WellKnownRoutes(rg *gin.RouterGroup, keyRing *keyring.KeyRing, auditLog *services.AuditLog) {
+	wellKnown := rg.Group("/.well-known")
+	var keyHandler = handlers.NewKeyHandler(keyRing, auditLog)
+
+	// Public keys for verifying access tokens
+	wellKnown.GET("/jwks.json", keyHandler.JWKS())
+}
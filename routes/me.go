@@ -0,0 +1,25 @@
+package routes
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/yasharya2901/smart_divide/handlers"
+	"github.com/yasharya2901/smart_divide/middleware"
+	"github.com/yasharya2901/smart_divide/services"
+	"gorm.io/gorm"
+)
+
+func MeRoutes(rg *gin.RouterGroup, db *gorm.DB, auditLog *services.AuditLog) {
+	// Routes about the logged in person
+	me := rg.Group("/me", middleware.RequireSession())
+	var auditHandler = handlers.NewAuditHandler(auditLog)
+	var accountHandler = handlers.NewAccountHandler(db)
+
+	me.GET("/security-events", auditHandler.GetMySecurityEvents())
+
+	// Personal data
+	me.GET("/balances", accountHandler.GetBalances())
+	me.GET("/export", accountHandler.ExportData())
+
+	// Whether others can find the person by email or number
+	me.PUT("/discoverable", accountHandler.SetDiscoverable())
+}
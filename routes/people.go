@@ -2,18 +2,23 @@ package routes
 
 import (
 	"github.com/gin-gonic/gin"
+	"github.com/yasharya2901/smart_divide/config"
 	"github.com/yasharya2901/smart_divide/handlers"
+	"github.com/yasharya2901/smart_divide/mailer"
+	"github.com/yasharya2901/smart_divide/middleware"
+	"github.com/yasharya2901/smart_divide/passwordhash"
+	"github.com/yasharya2901/smart_divide/services"
 	"gorm.io/gorm"
 )
 
-func PersonRoutes(rg *gin.RouterGroup, db *gorm.DB) {
+func PersonRoutes(rg *gin.RouterGroup, db *gorm.DB, cfg *config.Config, mail mailer.Mailer, passwords *passwordhash.Manager) {
 	// Group for people-related routes
 	people := rg.Group("/people")
-	peopleHandler := handlers.NewPeopleHandler(db)
+	peopleHandler := handlers.NewPeopleHandler(db, cfg, mail, passwords)
 
-	people.GET("/:id", peopleHandler.GetPerson())
-	people.PUT("/:id", peopleHandler.UpdatePerson())
+	people.GET("/:id", middleware.RequireScope(services.ScopePeopleRead), peopleHandler.GetPerson())
+	people.PUT("/:id", middleware.RequireScope(services.ScopePeopleWrite), peopleHandler.UpdatePerson())
 
-	people.POST("/contacts", peopleHandler.GetPeopleByContacts())
-	people.POST("/emails", peopleHandler.GetPeopleByEmails())
+	// Finding people who opted in among the caller's contacts, by hashed email or number
+	people.POST("/discover", middleware.RequireScope(services.ScopePeopleRead), peopleHandler.Discover())
 }
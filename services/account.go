@@ -0,0 +1,506 @@
+package services
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+
+	"strings"
+	"time"
+
+	"github.com/yasharya2901/smart_divide/models"
+	"github.com/yasharya2901/smart_divide/money"
+	"gorm.io/gorm"
+)
+
+// deletedPersonName replaces the name of a deleted account in the history of its events.
+const deletedPersonName = "Deleted user"
+
+// UnsettledBalancesError is returned when deleting an account that still owes or is owed money.
+type UnsettledBalancesError struct {
+	Balances []EventBalance
+}
+
+func (e *UnsettledBalancesError) Error() string {
+	return "the account still has unsettled balances, settle up before deleting it"
+}
+
+// AccountService exports the data of a person and computes their balances.
+type AccountService struct {
+	db *gorm.DB
+}
+
+func NewAccountService(db *gorm.DB) *AccountService {
+	return &AccountService{db: db}
+}
+
+// EventBalance is what a person paid minus what they owe across the expenses of an event.
+// A positive balance is owed to the person, a negative one is owed by them.
+type EventBalance struct {
+	EventID   uint        `json:"event_id"`
+	EventName string      `json:"event_name"`
+	Balance   money.Money `json:"balance"`
+}
+
+// IsSettled reports whether nothing is owed either way.
+func (eb EventBalance) IsSettled() bool {
+	return eb.Balance.IsZero()
+}
+
+// AccountExport is every piece of data stored about a person.
+type AccountExport struct {
+	ExportedAt     time.Time            `json:"exported_at"`
+	Profile        exportProfile        `json:"profile"`
+	Events         []exportEvent        `json:"events"`
+	Expenses       []exportExpense      `json:"expenses"`
+	Splits         []exportSplit        `json:"splits"`
+	Balances       []EventBalance       `json:"balances"`
+	Sessions       []exportSession      `json:"sessions"`
+	APIKeys        []exportAPIKey       `json:"api_keys"`
+	Passkeys       []exportPasskey      `json:"passkeys"`
+	Friends        []Friend             `json:"friends"`
+	SecurityEvents []exportSecurityItem `json:"security_events"`
+}
+
+type exportProfile struct {
+	ID              uint      `json:"id"`
+	Name            string    `json:"name"`
+	Email           string    `json:"email"`
+	EmailVerified   bool      `json:"email_verified"`
+	PendingEmail    string    `json:"pending_email,omitempty"`
+	Contact         string    `json:"contact"`
+	ContactVerified bool      `json:"contact_verified"`
+	TOTPEnabled     bool      `json:"two_factor_enabled"`
+	Discoverable    bool      `json:"discoverable"`
+	CreatedAt       time.Time `json:"created_at"`
+}
+
+type exportEvent struct {
+	ID       uint      `json:"id"`
+	Name     string    `json:"name"`
+	Role     string    `json:"role"`
+	JoinedAt time.Time `json:"joined_at"`
+}
+
+type exportExpense struct {
+	ID          uint        `json:"id"`
+	EventID     uint        `json:"event_id"`
+	Name        string      `json:"name"`
+	TotalAmount money.Money `json:"total_amount"`
+	PaidByID    uint        `json:"paid_by_id"`
+	CreatedByID uint        `json:"created_by_id"`
+	CreatedAt   time.Time   `json:"created_at"`
+}
+
+type exportSplit struct {
+	ID         uint        `json:"id"`
+	ExpenseID  uint        `json:"expense_id"`
+	PaidAmount money.Money `json:"paid_amount"`
+	OwedAmount money.Money `json:"owed_amount"`
+}
+
+type exportSession struct {
+	DeviceName string    `json:"device_name"`
+	UserAgent  string    `json:"user_agent"`
+	IPAddress  string    `json:"ip_address"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastUsedAt time.Time `json:"last_used_at"`
+	Revoked    bool      `json:"revoked"`
+}
+
+type exportAPIKey struct {
+	Name       string     `json:"name"`
+	Scopes     []string   `json:"scopes"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	RevokedAt  *time.Time `json:"revoked_at"`
+}
+
+type exportPasskey struct {
+	Nickname   string     `json:"nickname"`
+	Transports []string   `json:"transports"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+}
+
+type exportSecurityItem struct {
+	Action    string    `json:"action"`
+	Outcome   string    `json:"outcome"`
+	IPAddress string    `json:"ip_address"`
+	UserAgent string    `json:"user_agent"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// GetBalances returns the balance of the person in every event they have splits in.
+func (acs *AccountService) GetBalances(personID uint) ([]EventBalance, error) {
+	var rows []struct {
+		EventID   uint
+		EventName string
+		Currency  string
+		Balance   int64
+	}
+	if err := acs.db.Table("expense_people").
+		Select("expenses.event_id AS event_id, events.name AS event_name, events.currency AS currency, "+
+			"SUM(expense_people.paid_amount_minor - expense_people.owed_amount_minor) AS balance").
+		Joins("JOIN expenses ON expenses.id = expense_people.expense_id AND expenses.deleted_at IS NULL").
+		Joins("JOIN events ON events.id = expenses.event_id AND events.deleted_at IS NULL").
+		Where("expense_people.person_id = ? AND expense_people.deleted_at IS NULL", personID).
+		Group("expenses.event_id, events.name, events.currency").
+		Order("expenses.event_id").
+		Scan(&rows).Error; err != nil {
+		return nil, err
+	}
+
+	balances := make([]EventBalance, len(rows))
+	for i, row := range rows {
+		balances[i] = EventBalance{EventID: row.EventID, EventName: row.EventName, Balance: money.New(row.Balance, row.Currency)}
+	}
+	return balances, nil
+}
+
+// GetUnsettledBalances returns the events in which the person still owes or is owed money.
+func (acs *AccountService) GetUnsettledBalances(personID uint) ([]EventBalance, error) {
+	balances, err := acs.GetBalances(personID)
+	if err != nil {
+		return nil, err
+	}
+
+	unsettled := []EventBalance{}
+	for _, balance := range balances {
+		if !balance.IsSettled() {
+			unsettled = append(unsettled, balance)
+		}
+	}
+	return unsettled, nil
+}
+
+// ExportData collects the profile, events, expenses, splits, balances, sessions, API keys,
+// passkeys, friends and security history of a person.
+func (acs *AccountService) ExportData(personID uint) (*AccountExport, error) {
+	var person models.Person
+	if err := acs.db.First(&person, personID).Error; err != nil {
+		return nil, err
+	}
+
+	export := &AccountExport{
+		ExportedAt: time.Now(),
+		Profile: exportProfile{
+			ID:              person.ID,
+			Name:            person.Name,
+			Email:           person.Email,
+			EmailVerified:   person.EmailVerified,
+			PendingEmail:    person.PendingEmail,
+			Contact:         person.Contact,
+			ContactVerified: person.ContactVerified,
+			TOTPEnabled:     person.TOTPEnabled,
+			Discoverable:    person.Discoverable,
+			CreatedAt:       person.CreatedAt,
+		},
+	}
+
+	if err := acs.db.Table("event_people").
+		Select("events.id AS id, events.name AS name, event_people.role AS role, event_people.created_at AS joined_at").
+		Joins("JOIN events ON events.id = event_people.event_id AND events.deleted_at IS NULL").
+		Where("event_people.person_id = ?", personID).
+		Order("events.id").
+		Scan(&export.Events).Error; err != nil {
+		return nil, err
+	}
+
+	var splits []models.ExpensePerson
+	if err := acs.db.Where("person_id = ?", personID).Order("id").Find(&splits).Error; err != nil {
+		return nil, err
+	}
+	export.Splits = make([]exportSplit, len(splits))
+	for i, split := range splits {
+		export.Splits[i] = exportSplit{ID: split.ID, ExpenseID: split.ExpenseID, PaidAmount: split.PaidAmount, OwedAmount: split.OwedAmount}
+	}
+
+	// Expenses the person paid, added or has a split in
+	var expenses []models.Expense
+	if err := acs.db.Where("paid_by_id = ? OR created_by_id = ? OR id IN (?)", personID, personID,
+		acs.db.Model(&models.ExpensePerson{}).Select("expense_id").Where("person_id = ?", personID)).
+		Order("id").Find(&expenses).Error; err != nil {
+		return nil, err
+	}
+	export.Expenses = make([]exportExpense, len(expenses))
+	for i, expense := range expenses {
+		export.Expenses[i] = exportExpense{
+			ID:          expense.ID,
+			EventID:     expense.EventID,
+			Name:        expense.Name,
+			TotalAmount: expense.TotalAmount,
+			PaidByID:    expense.PaidByID,
+			CreatedByID: expense.CreatedByID,
+			CreatedAt:   expense.CreatedAt,
+		}
+	}
+
+	balances, err := acs.GetBalances(personID)
+	if err != nil {
+		return nil, err
+	}
+	export.Balances = balances
+
+	var sessions []models.Session
+	if err := acs.db.Where("person_id = ?", personID).Order("created_at").Find(&sessions).Error; err != nil {
+		return nil, err
+	}
+	export.Sessions = make([]exportSession, len(sessions))
+	for i, session := range sessions {
+		export.Sessions[i] = exportSession{
+			DeviceName: session.DeviceName,
+			UserAgent:  session.UserAgent,
+			IPAddress:  session.IPAddress,
+			CreatedAt:  session.CreatedAt,
+			LastUsedAt: session.LastUsedAt,
+			Revoked:    session.Revoked,
+		}
+	}
+
+	var apiKeys []models.APIKey
+	if err := acs.db.Where("person_id = ?", personID).Order("created_at").Find(&apiKeys).Error; err != nil {
+		return nil, err
+	}
+	export.APIKeys = make([]exportAPIKey, len(apiKeys))
+	for i := range apiKeys {
+		export.APIKeys[i] = exportAPIKey{
+			Name:       apiKeys[i].Name,
+			Scopes:     ScopeList(&apiKeys[i]),
+			CreatedAt:  apiKeys[i].CreatedAt,
+			LastUsedAt: apiKeys[i].LastUsedAt,
+			RevokedAt:  apiKeys[i].RevokedAt,
+		}
+	}
+
+	var passkeys []models.WebAuthnCredential
+	if err := acs.db.Where("person_id = ?", personID).Order("created_at").Find(&passkeys).Error; err != nil {
+		return nil, err
+	}
+	export.Passkeys = make([]exportPasskey, len(passkeys))
+	for i, passkey := range passkeys {
+		export.Passkeys[i] = exportPasskey{
+			Nickname:   passkey.Nickname,
+			Transports: strings.Fields(passkey.Transports),
+			CreatedAt:  passkey.CreatedAt,
+			LastUsedAt: passkey.LastUsedAt,
+		}
+	}
+
+	friends, err := NewFriendService(acs.db).GetFriends(personID)
+	if err != nil {
+		return nil, err
+	}
+	export.Friends = friends
+
+	var auditEvents []models.AuditEvent
+	if err := acs.db.Where("person_id = ?", personID).Order("created_at").Find(&auditEvents).Error; err != nil {
+		return nil, err
+	}
+	export.SecurityEvents = make([]exportSecurityItem, len(auditEvents))
+	for i, event := range auditEvents {
+		export.SecurityEvents[i] = exportSecurityItem{
+			Action:    event.Action,
+			Outcome:   event.Outcome,
+			IPAddress: event.IPAddress,
+			UserAgent: event.UserAgent,
+			CreatedAt: event.CreatedAt,
+		}
+	}
+
+	return export, nil
+}
+
+// WriteZip writes the export as a ZIP archive with one JSON file per section.
+func (export *AccountExport) WriteZip(w io.Writer) error {
+	archive := zip.NewWriter(w)
+
+	files := []struct {
+		name string
+		data interface{}
+	}{
+		{"profile.json", export.Profile},
+		{"events.json", export.Events},
+		{"expenses.json", export.Expenses},
+		{"splits.json", export.Splits},
+		{"balances.json", export.Balances},
+		{"sessions.json", export.Sessions},
+		{"api_keys.json", export.APIKeys},
+		{"passkeys.json", export.Passkeys},
+		{"friends.json", export.Friends},
+		{"security_events.json", export.SecurityEvents},
+	}
+
+	for _, file := range files {
+		f, err := archive.CreateHeader(&zip.FileHeader{Name: file.name, Method: zip.Deflate, Modified: export.ExportedAt})
+		if err != nil {
+			return err
+		}
+
+		encoder := json.NewEncoder(f)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(file.data); err != nil {
+			return fmt.Errorf("failed to write %s: %w", file.name, err)
+		}
+	}
+
+	return archive.Close()
+}
+
+// DeleteAccount anonymizes the account of a person after they confirm with their password,
+// and their current two-factor code if enabled. The person row is kept, so the expenses and
+// splits other members depend on stay intact, but their name, email and number are erased,
+// every credential is removed and they leave all their events. Deletion is refused while the
+// person has unsettled balances.
+func (as *AuthService) DeleteAccount(personID uint, password, code string, device DeviceInfo) error {
+	person, err := as.peopleService.GetPersonByID(personID)
+	if err != nil {
+		return err
+	}
+
+	// Accounts that only sign in with an identity provider have no password to confirm
+	if person.Password != "" {
+		if valid, _, err := as.passwords.Verify(person.Password, password); err != nil || !valid {
+			err := errors.New("incorrect password")
+			as.audit(AuditAccountDeleted, person.ID, person.Email, device, err)
+			return err
+		}
+	}
+
+	if person.TOTPEnabled {
+		if err := as.verifyTOTP(person, code); err != nil {
+			as.audit(AuditAccountDeleted, person.ID, person.Email, device, err)
+			return err
+		}
+	}
+
+	unsettled, err := NewAccountService(as.db).GetUnsettledBalances(person.ID)
+	if err != nil {
+		return err
+	}
+	if len(unsettled) > 0 {
+		err := &UnsettledBalancesError{Balances: unsettled}
+		as.audit(AuditAccountDeleted, person.ID, person.Email, device, err)
+		return err
+	}
+
+	err = as.db.Transaction(func(tx *gorm.DB) error {
+		if err := leaveAllEvents(tx, person.ID); err != nil {
+			return err
+		}
+
+		if err := NewSessionService(tx).RevokeAllSessions(person.ID); err != nil {
+			return err
+		}
+
+		if err := tx.Model(&models.APIKey{}).Where("person_id = ? AND revoked_at IS NULL", person.ID).
+			Update("revoked_at", time.Now()).Error; err != nil {
+			return err
+		}
+
+		// Credentials and pending tokens are of no use to anyone once the account is gone
+		for _, model := range []interface{}{
+			&models.RecoveryCode{},
+			&models.ExternalIdentity{},
+			&models.PasswordResetToken{},
+			&models.EmailVerificationToken{},
+			&models.AccountUnlockToken{},
+			&models.MagicLinkToken{},
+			&models.WebAuthnCredential{},
+			&models.WebAuthnSession{},
+			&models.ContactLookup{},
+		} {
+			if err := tx.Unscoped().Where("person_id = ?", person.ID).Delete(model).Error; err != nil {
+				return err
+			}
+		}
+
+		// The account leaves the friends lists of others too
+		if err := tx.Unscoped().Where("requester_id = ? OR addressee_id = ?", person.ID, person.ID).
+			Delete(&models.Friendship{}).Error; err != nil {
+			return err
+		}
+
+		return tx.Model(&models.Person{}).Where("id = ?", person.ID).Updates(map[string]interface{}{
+			"name":                deletedPersonName,
+			"email":               gorm.Expr("NULL"),
+			"email_verified":      false,
+			"pending_email":       "",
+			"contact":             "",
+			"contact_verified":    false,
+			"verified_contact":    gorm.Expr("NULL"),
+			"password":            "",
+			"totp_secret":         "",
+			"totp_enabled":        false,
+			"token_version":       gorm.Expr("token_version + 1"),
+			"placeholder_email":   "",
+			"placeholder_contact": "",
+			"is_admin":            false,
+			"disabled_reason":     "",
+			"discoverable":        false,
+			"email_hash":          "",
+			"contact_hash":        "",
+			"anonymized_at":       time.Now(),
+		}).Error
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := as.loginThrottle.Reset(accountAttemptKey(person.Email)); err != nil {
+		return err
+	}
+
+	as.audit(AuditAccountDeleted, person.ID, "", device, nil)
+	return nil
+}
+
+// leaveAllEvents removes the person from their events. Where they were the last owner, the
+// longest standing remaining member with an account becomes the owner, and events left
+// without anyone who can log in are deleted.
+func leaveAllEvents(tx *gorm.DB, personID uint) error {
+	var memberships []models.EventPerson
+	if err := tx.Where("person_id = ?", personID).Find(&memberships).Error; err != nil {
+		return err
+	}
+
+	for _, membership := range memberships {
+		if err := tx.Where("event_id = ? AND person_id = ?", membership.EventID, personID).
+			Delete(&models.EventPerson{}).Error; err != nil {
+			return err
+		}
+
+		var remaining []models.EventPerson
+		if err := tx.Joins("JOIN people ON people.id = event_people.person_id AND people.placeholder = ?", false).
+			Where("event_people.event_id = ?", membership.EventID).
+			Order("event_people.created_at").
+			Find(&remaining).Error; err != nil {
+			return err
+		}
+
+		if len(remaining) == 0 {
+			if err := tx.Delete(&models.Event{}, membership.EventID).Error; err != nil {
+				return err
+			}
+			continue
+		}
+
+		hasOwner := false
+		for _, member := range remaining {
+			if member.Role == RoleOwner {
+				hasOwner = true
+				break
+			}
+		}
+		if !hasOwner {
+			if err := tx.Model(&models.EventPerson{}).
+				Where("event_id = ? AND person_id = ?", membership.EventID, remaining[0].PersonID).
+				Update("role", RoleOwner).Error; err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
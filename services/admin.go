@@ -0,0 +1,230 @@
+package services
+
+import (
+	"errors"
+	"time"
+
+	"github.com/yasharya2901/smart_divide/config"
+	"github.com/yasharya2901/smart_divide/mailer"
+	"github.com/yasharya2901/smart_divide/models"
+	"github.com/yasharya2901/smart_divide/passwordhash"
+	"github.com/yasharya2901/smart_divide/utils"
+	"gorm.io/gorm"
+)
+
+// impersonationTokenExpiry is how long an administrator can inspect an account with one token.
+// Impersonation tokens can't be refreshed.
+const impersonationTokenExpiry = 15 * time.Minute
+
+// ErrAccountDisabled is returned when logging in to an account an administrator locked.
+var ErrAccountDisabled = errors.New("this account has been locked by an administrator")
+
+// AdminAccount is what an administrator sees of an account.
+type AdminAccount struct {
+	Person        *models.Person
+	Events        []models.Event
+	Sessions      []models.Session
+	LockedOutTill *time.Time // Set while logins are refused after too many failures
+}
+
+type AdminService struct {
+	db             *gorm.DB
+	peopleService  *PeopleService
+	eventService   *EventService
+	sessionService *SessionService
+	loginThrottle  *LoginThrottle
+	accessKeys     utils.KeySet
+	auditLog       *AuditLog
+}
+
+func NewAdminService(db *gorm.DB, cfg *config.Config, mail mailer.Mailer, passwords *passwordhash.Manager, attempts LoginAttemptStore, accessKeys utils.KeySet, auditLog *AuditLog) *AdminService {
+	return &AdminService{
+		db:             db,
+		peopleService:  NewPeopleService(db, cfg, mail, passwords),
+		eventService:   NewEventService(db),
+		sessionService: NewSessionService(db),
+		loginThrottle:  NewLoginThrottle(attempts),
+		accessKeys:     accessKeys,
+		auditLog:       auditLog,
+	}
+}
+
+// PromoteAdmins makes the accounts with the given verified emails administrators. It is used
+// to set up the first administrators from ADMIN_EMAILS.
+func (ads *AdminService) PromoteAdmins(emails []string) error {
+	if len(emails) == 0 {
+		return nil
+	}
+
+	return ads.db.Model(&models.Person{}).
+		Where("email IN ? AND email_verified = ? AND is_admin = ?", emails, true, false).
+		Update("is_admin", true).Error
+}
+
+func (ads *AdminService) SearchPeople(query string, limit, offset int) ([]models.Person, error) {
+	return ads.peopleService.SearchPeople(query, limit, offset)
+}
+
+// GetAccount returns a person along with their events, active sessions and login lockout.
+func (ads *AdminService) GetAccount(personID uint) (*AdminAccount, error) {
+	person, err := ads.peopleService.GetPersonByID(personID)
+	if err != nil {
+		return nil, err
+	}
+
+	events, err := ads.eventService.GetEvents(personID)
+	if err != nil {
+		return nil, err
+	}
+
+	sessions, err := ads.sessionService.GetActiveSessions(personID)
+	if err != nil {
+		return nil, err
+	}
+
+	account := &AdminAccount{Person: person, Events: events, Sessions: sessions}
+
+	if person.Email != "" {
+		var locked *LockedError
+		if err := ads.loginThrottle.Check(accountAttemptKey(person.Email)); errors.As(err, &locked) {
+			account.LockedOutTill = &locked.Until
+		} else if err != nil {
+			return nil, err
+		}
+	}
+
+	return account, nil
+}
+
+// LockAccount refuses every login to an account until an administrator unlocks it, logs
+// out every session of the person and revokes their API keys.
+func (ads *AdminService) LockAccount(adminID, personID uint, reason string, device DeviceInfo) error {
+	if adminID == personID {
+		return errors.New("you can't lock your own account")
+	}
+
+	err := ads.db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&models.Person{}).Where("id = ? AND placeholder = ?", personID, false).Updates(map[string]interface{}{
+			"disabled_at":     time.Now(),
+			"disabled_reason": reason,
+			"token_version":   gorm.Expr("token_version + 1"),
+		})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return gorm.ErrRecordNotFound
+		}
+
+		if err := tx.Model(&models.APIKey{}).Where("person_id = ? AND revoked_at IS NULL", personID).
+			Update("revoked_at", time.Now()).Error; err != nil {
+			return err
+		}
+
+		return NewSessionService(tx).RevokeAllSessions(personID)
+	})
+	ads.record(AuditAdminAccountLocked, adminID, personID, reason, device, err)
+	return err
+}
+
+// UnlockAccount lifts a lock set by an administrator, as well as a lockout after too many
+// failed logins.
+func (ads *AdminService) UnlockAccount(adminID, personID uint, device DeviceInfo) error {
+	person, err := ads.peopleService.GetPersonByID(personID)
+	if err != nil {
+		return err
+	}
+
+	err = ads.db.Model(person).Updates(map[string]interface{}{
+		"disabled_at":     nil,
+		"disabled_reason": "",
+	}).Error
+	if err == nil && person.Email != "" {
+		err = ads.loginThrottle.Reset(accountAttemptKey(person.Email))
+	}
+	ads.record(AuditAdminAccountUnlocked, adminID, personID, "", device, err)
+	return err
+}
+
+// ForceLogout ends every session of a person and invalidates every token issued to them.
+func (ads *AdminService) ForceLogout(adminID, personID uint, device DeviceInfo) error {
+	err := ads.db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&models.Person{}).Where("id = ?", personID).
+			Update("token_version", gorm.Expr("token_version + 1"))
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return gorm.ErrRecordNotFound
+		}
+
+		return NewSessionService(tx).RevokeAllSessions(personID)
+	})
+	ads.record(AuditAdminForcedLogout, adminID, personID, "", device, err)
+	return err
+}
+
+// SetAdmin grants or revokes administrator rights.
+func (ads *AdminService) SetAdmin(adminID, personID uint, isAdmin bool, device DeviceInfo) error {
+	if adminID == personID {
+		return errors.New("you can't change your own administrator rights")
+	}
+
+	action := AuditAdminRevoked
+	if isAdmin {
+		action = AuditAdminGranted
+	}
+
+	person, err := ads.peopleService.GetPersonByID(personID)
+	if err != nil {
+		return err
+	}
+
+	// Only verified accounts can become administrators
+	if isAdmin && (person.Placeholder || person.AnonymizedAt != nil || !person.EmailVerified) {
+		err = errors.New("only accounts with a verified email can become administrators")
+	} else {
+		err = ads.db.Model(person).Update("is_admin", isAdmin).Error
+	}
+	ads.record(action, adminID, personID, "", device, err)
+	return err
+}
+
+// Impersonate issues a short-lived, read-only access token for the account of a person, so
+// an administrator can see what the person sees. Every request made with it is recorded.
+func (ads *AdminService) Impersonate(adminID, personID uint, reason string, device DeviceInfo) (string, time.Time, error) {
+	if adminID == personID {
+		return "", time.Time{}, errors.New("you can't impersonate yourself")
+	}
+
+	person, err := ads.peopleService.GetPersonByID(personID)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	switch {
+	case person.Placeholder || person.AnonymizedAt != nil:
+		err = errors.New("only active accounts can be impersonated")
+	case person.IsAdmin:
+		err = errors.New("administrators can't be impersonated")
+	}
+	if err != nil {
+		ads.record(AuditImpersonationStarted, adminID, personID, reason, device, err)
+		return "", time.Time{}, err
+	}
+
+	token, expiresAt, err := utils.GenerateToken(utils.Claims{
+		UserID:         person.ID,
+		Email:          person.Email,
+		Version:        person.TokenVersion,
+		Type:           utils.TokenTypeAccess,
+		ImpersonatorID: adminID,
+	}, impersonationTokenExpiry, ads.accessKeys)
+	ads.record(AuditImpersonationStarted, adminID, personID, reason, device, err)
+	return token, expiresAt, err
+}
+
+// record adds an administrator action to the audit log of the person it was performed on.
+func (ads *AdminService) record(action string, adminID, personID uint, detail string, device DeviceInfo, err error) {
+	ads.auditLog.Record(AuditEntry{PersonID: personID, ActorID: adminID, Action: action, Detail: detail, Device: device, Err: err})
+}
@@ -0,0 +1,213 @@
+package services
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"github.com/yasharya2901/smart_divide/models"
+	"gorm.io/gorm"
+)
+
+// Audited actions.
+const (
+	AuditLogin                   = "login"
+	AuditLoginTwoFactor          = "login_2fa"
+	AuditLoginOTP                = "login_otp"
+	AuditLoginOIDC               = "login_oidc"
+	AuditLoginPasskey            = "login_passkey"
+	AuditLoginMagicLink          = "login_magic_link"
+	AuditMagicLinkRequest        = "magic_link_request"
+	AuditRegister                = "register"
+	AuditLogout                  = "logout"
+	AuditRefreshTokenReuse       = "refresh_token_reuse"
+	AuditPasswordChange          = "password_change"
+	AuditPasswordResetRequest    = "password_reset_request"
+	AuditPasswordReset           = "password_reset"
+	AuditEmailVerified           = "email_verified"
+	AuditAccountLocked           = "account_locked"
+	AuditAccountUnlocked         = "account_unlocked"
+	AuditSessionRevoked          = "session_revoked"
+	AuditOtherSessionsRevoked    = "other_sessions_revoked"
+	AuditTwoFactorEnabled        = "2fa_enabled"
+	AuditTwoFactorDisabled       = "2fa_disabled"
+	AuditRecoveryCodesRegenerate = "recovery_codes_regenerated"
+	AuditAPIKeyCreated           = "api_key_created"
+	AuditAPIKeyRevoked           = "api_key_revoked"
+	AuditPasskeyRegistered       = "passkey_registered"
+	AuditPasskeyRemoved          = "passkey_removed"
+	AuditSigningKeyRotated       = "signing_key_rotated"
+	AuditAccountDeleted          = "account_deleted"
+	AuditAdminAccountLocked      = "admin_account_locked"
+	AuditAdminAccountUnlocked    = "admin_account_unlocked"
+	AuditAdminForcedLogout       = "admin_forced_logout"
+	AuditAdminGranted            = "admin_granted"
+	AuditAdminRevoked            = "admin_revoked"
+	AuditImpersonationStarted    = "impersonation_started"
+	AuditImpersonatedRequest     = "impersonated_request"
+)
+
+// Outcomes of an audited action.
+const (
+	AuditSuccess = "success"
+	AuditFailure = "failure"
+)
+
+// auditBufferSize is how many entries can wait to be written before new ones are dropped.
+const auditBufferSize = 1024
+
+// AuditEntry describes an action to record in the audit log.
+type AuditEntry struct {
+	PersonID uint // Zero if the person is unknown, e.g. a login with an unregistered email
+	Email    string
+	Action   string
+	Err      error // The action failed with this error, nil on success
+	Device   DeviceInfo
+	ActorID  uint   // Administrator acting on the person, zero if the person acted themselves
+	Detail   string // Context of an administrator action
+}
+
+// AuditFilter narrows down an audit log query. Zero values are ignored.
+type AuditFilter struct {
+	PersonID  uint
+	ActorID   uint
+	Email     string
+	Action    string
+	Outcome   string
+	IPAddress string
+	Since     time.Time
+	Until     time.Time
+	Limit     int
+	Offset    int
+}
+
+// AuditLog writes audit events in the background, so recording an action never slows
+// down or fails the request it belongs to. Entries are dropped, and logged, if the
+// buffer is full or the write fails.
+type AuditLog struct {
+	db      *gorm.DB
+	entries chan models.AuditEvent
+	done    chan struct{}
+	once    sync.Once
+}
+
+func NewAuditLog(db *gorm.DB) *AuditLog {
+	al := &AuditLog{
+		db:      db,
+		entries: make(chan models.AuditEvent, auditBufferSize),
+		done:    make(chan struct{}),
+	}
+	go al.run()
+	return al
+}
+
+// Record queues an entry to be written.
+func (al *AuditLog) Record(entry AuditEntry) {
+	event := models.AuditEvent{
+		CreatedAt: time.Now(),
+		Email:     entry.Email,
+		Action:    entry.Action,
+		Outcome:   AuditSuccess,
+		IPAddress: entry.Device.IPAddress,
+		UserAgent: truncate(entry.Device.UserAgent, 512),
+		Detail:    truncate(entry.Detail, 255),
+	}
+	if entry.PersonID != 0 {
+		personID := entry.PersonID
+		event.PersonID = &personID
+	}
+	if entry.ActorID != 0 {
+		actorID := entry.ActorID
+		event.ActorID = &actorID
+	}
+	if entry.Err != nil {
+		event.Outcome = AuditFailure
+		event.Reason = truncate(entry.Err.Error(), 255)
+	}
+
+	select {
+	case al.entries <- event:
+	default:
+		log.Printf("audit log buffer full, dropping %s event", event.Action)
+	}
+}
+
+// Close writes the queued entries and stops the background writer.
+func (al *AuditLog) Close() {
+	al.once.Do(func() {
+		close(al.entries)
+		<-al.done
+	})
+}
+
+func (al *AuditLog) run() {
+	defer close(al.done)
+
+	for event := range al.entries {
+		if err := al.db.Create(&event).Error; err != nil {
+			log.Printf("failed to write %s audit event: %v", event.Action, err)
+		}
+	}
+}
+
+// GetPersonEvents returns the most recent audit events of a person.
+func (al *AuditLog) GetPersonEvents(personID uint, limit, offset int) ([]models.AuditEvent, error) {
+	return al.Query(AuditFilter{PersonID: personID, Limit: limit, Offset: offset})
+}
+
+// Query returns the audit events matching the filter, newest first.
+func (al *AuditLog) Query(filter AuditFilter) ([]models.AuditEvent, error) {
+	query := al.db.Model(&models.AuditEvent{})
+	if filter.PersonID != 0 {
+		query = query.Where("person_id = ?", filter.PersonID)
+	}
+	if filter.ActorID != 0 {
+		query = query.Where("actor_id = ?", filter.ActorID)
+	}
+	if filter.Email != "" {
+		query = query.Where("email = ?", filter.Email)
+	}
+	if filter.Action != "" {
+		query = query.Where("action = ?", filter.Action)
+	}
+	if filter.Outcome != "" {
+		query = query.Where("outcome = ?", filter.Outcome)
+	}
+	if filter.IPAddress != "" {
+		query = query.Where("ip_address = ?", filter.IPAddress)
+	}
+	if !filter.Since.IsZero() {
+		query = query.Where("created_at >= ?", filter.Since)
+	}
+	if !filter.Until.IsZero() {
+		query = query.Where("created_at < ?", filter.Until)
+	}
+
+	var events []models.AuditEvent
+	if err := query.Order("created_at desc, id desc").
+		Limit(clampLimit(filter.Limit)).
+		Offset(filter.Offset).
+		Find(&events).Error; err != nil {
+		return nil, err
+	}
+	return events, nil
+}
+
+// clampLimit keeps page sizes between 1 and 200, defaulting to 50.
+func clampLimit(limit int) int {
+	switch {
+	case limit <= 0:
+		return 50
+	case limit > 200:
+		return 200
+	default:
+		return limit
+	}
+}
+
+func truncate(value string, length int) string {
+	if len(value) > length {
+		return value[:length]
+	}
+	return value
+}
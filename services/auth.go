@@ -2,76 +2,124 @@ package services
 
 import (
 	"errors"
-	"os"
-	"strconv"
+	"log"
 	"time"
 
+	"github.com/yasharya2901/smart_divide/config"
+	"github.com/yasharya2901/smart_divide/mailer"
 	"github.com/yasharya2901/smart_divide/models"
+	"github.com/yasharya2901/smart_divide/passwordhash"
+	"github.com/yasharya2901/smart_divide/passwordpolicy"
+	"github.com/yasharya2901/smart_divide/sms"
 	"github.com/yasharya2901/smart_divide/utils"
 	"gorm.io/gorm"
 )
 
 type AuthService struct {
-	db            *gorm.DB
-	peopleService *PeopleService
+	db                       *gorm.DB
+	cfg                      *config.Config
+	mailer                   mailer.Mailer
+	peopleService            *PeopleService
+	sessionService           *SessionService
+	emailVerificationService *EmailVerificationService
+	otpService               *OTPService
+	loginThrottle            *LoginThrottle
+	accessKeys               utils.KeySet // Signs access and two-factor tokens
+	auditLog                 *AuditLog
+	passwordPolicy           *passwordpolicy.Policy
+	passwords                *passwordhash.Manager
 }
 
-func NewAuthService(db *gorm.DB) *AuthService {
-	return &AuthService{db: db, peopleService: NewPeopleService(db)}
+func NewAuthService(db *gorm.DB, cfg *config.Config, mail mailer.Mailer, sender sms.Sender, attempts LoginAttemptStore, accessKeys utils.KeySet, auditLog *AuditLog, passwordPolicy *passwordpolicy.Policy, passwords *passwordhash.Manager) *AuthService {
+	return &AuthService{
+		db:                       db,
+		cfg:                      cfg,
+		mailer:                   mail,
+		peopleService:            NewPeopleService(db, cfg, mail, passwords),
+		sessionService:           NewSessionService(db),
+		emailVerificationService: NewEmailVerificationService(db, cfg, mail),
+		otpService:               NewOTPService(db, sender),
+		loginThrottle:            NewLoginThrottle(attempts),
+		accessKeys:               accessKeys,
+		auditLog:                 auditLog,
+		passwordPolicy:           passwordPolicy,
+		passwords:                passwords,
+	}
+}
+
+// PasswordPolicy returns the rules new passwords must follow.
+func (as *AuthService) PasswordPolicy() *passwordpolicy.Policy {
+	return as.passwordPolicy
 }
 
-func (as *AuthService) Login(email, password string) (string, string, error) {
+// LoginResult is the outcome of a first factor login. Either the token pair is set, or
+// two-factor authentication is required and TwoFactorToken must be exchanged with a code.
+type LoginResult struct {
+	AccessToken       string `json:"access_token,omitempty"`
+	RefreshToken      string `json:"refresh_token,omitempty"`
+	TwoFactorRequired bool   `json:"two_factor_required"`
+	TwoFactorToken    string `json:"two_factor_token,omitempty"`
+}
+
+func (as *AuthService) Login(email, password string, device DeviceInfo) (*LoginResult, error) {
 	// Login a user
-	var person models.Person
-	result := as.peopleService.db.Where("email = ?", email).First(&person)
-	if result.Error != nil {
-		return "", "", errors.New("invalid email")
+	if err := as.checkLoginAllowed(email, device); err != nil {
+		as.audit(AuditLogin, 0, email, device, err)
+		return nil, err
 	}
 
-	if valid, err := utils.ComparePasswords(person.Password, password); err != nil || !valid {
-		return "", "", errors.New("invalid password")
-	}
+	// The same error is returned for unknown emails and wrong passwords, so accounts can't be enumerated
+	invalidCredentials := errors.New("invalid email or password")
 
-	// Expiry time for the refresh and access token
-	refreshTokenExpiry, err := strconv.Atoi(os.Getenv("JWT_REFRESH_TOKEN_EXPIRY"))
-	if err != nil {
-		return "", "", err
+	var person models.Person
+	if err := as.peopleService.db.Where("email = ?", email).Limit(1).Find(&person).Error; err != nil {
+		return nil, err
 	}
 
-	accessTokenExpiry, err := strconv.Atoi(os.Getenv("JWT_ACCESS_TOKEN_EXPIRY"))
-	if err != nil {
-		return "", "", err
+	if person.ID == 0 {
+		as.compareDummyPassword(password)
+		as.audit(AuditLogin, 0, email, device, errors.New("unknown email"))
+		if err := as.recordLoginFailure(nil, email, device); err != nil {
+			return nil, err
+		}
+		return nil, invalidCredentials
 	}
 
-	// Generate an access token
-	accessToken, _, err := utils.GenerateToken(person.ID, person.Email, time.Minute*time.Duration(accessTokenExpiry), os.Getenv("JWT_ACCESS_SECRET"))
-	if err != nil {
-		return "", "", err
+	valid, needsRehash, err := as.passwords.Verify(person.Password, password)
+	if err != nil || !valid {
+		as.audit(AuditLogin, person.ID, email, device, errors.New("invalid password"))
+		if err := as.recordLoginFailure(&person, email, device); err != nil {
+			return nil, err
+		}
+		return nil, invalidCredentials
 	}
 
-	// If the user already has a refresh token, return it
-	if person.RefreshToken != "" {
-		return accessToken, person.RefreshToken, nil
+	if err := as.loginThrottle.Reset(accountAttemptKey(email)); err != nil {
+		return nil, err
 	}
 
-	refreshToken, expiryTime, err := utils.GenerateToken(person.ID, person.Email, time.Hour*24*time.Duration(refreshTokenExpiry), os.Getenv("JWT_REFRESH_SECRET"))
-	if err != nil {
-		return "", "", err
+	// Hashes made with an older algorithm or cost are upgraded while the password is at hand
+	if needsRehash {
+		if err := as.rehashPassword(&person, password); err != nil {
+			log.Println("failed to upgrade password hash:", err)
+		}
 	}
 
-	as.peopleService.UpdatePerson(person.ID, "", "", "", refreshToken, expiryTime)
-	return accessToken, refreshToken, nil
-
+	result, err := as.completeLogin(&person, device)
+	as.audit(AuditLogin, person.ID, email, device, err)
+	return result, err
 }
 
-func (as *AuthService) Register(name, phoneNumber, email, password string) (string, string, error) {
+func (as *AuthService) Register(name, phoneNumber, email, password string, device DeviceInfo) (string, string, error) {
 	// Register a user
 
 	// Check if the email is already registered
 	var person models.Person
 	result := as.peopleService.db.Where("email = ?", email).First(&person)
 	if result.Error == nil {
-		return "", "", errors.New("email already registered")
+		err := errors.New("email already registered")
+		as.audit(AuditRegister, 0, email, device, err)
+		return "", "", err
 	}
 
 	if result.Error != gorm.ErrRecordNotFound {
@@ -88,78 +136,241 @@ func (as *AuthService) Register(name, phoneNumber, email, password string) (stri
 		return "", "", result.Error
 	}
 
+	if err := as.passwordPolicy.Check(password, name, email); err != nil {
+		return "", "", err
+	}
+
 	// Hash the password
-	hashedPassword, err := utils.HashPassword(password)
+	hashedPassword, err := as.passwords.Hash(password)
 	if err != nil {
 		return "", "", err
 	}
 
 	// Create a person
 	person = models.Person{
-		Name:     name,
-		Contact:  phoneNumber,
-		Email:    email,
-		Password: hashedPassword,
+		Name:        name,
+		Contact:     phoneNumber,
+		Email:       email,
+		Password:    hashedPassword,
+		EmailHash:   emailHash(email),
+		ContactHash: contactHash(phoneNumber),
 	}
 
 	if err := as.peopleService.db.Create(&person).Error; err != nil {
 		return "", "", err
 	}
+	as.audit(AuditRegister, person.ID, email, device, nil)
+
+	// Placeholders added with this email or number are merged into the account once it is verified.
+	// A failed verification email shouldn't fail the registration, it can be resent later
+	if err := as.emailVerificationService.SendVerification(&person, email); err != nil {
+		log.Println("failed to send verification email:", err)
+	}
 
 	// Login the user
-	accessToken, refreshToken, err := as.Login(email, password)
-	return accessToken, refreshToken, err
+	return as.startSession(&person, device)
 }
 
-func (as *AuthService) ChangePassword(email, oldPassword, newPassword string) error {
-	// Change password of a person
-	var person models.Person
+// ChangePassword replaces the password of a person and invalidates every access and refresh
+// token issued to them. A new session is started for the device the change was made from.
+func (as *AuthService) ChangePassword(personID uint, oldPassword, newPassword string, device DeviceInfo) (string, string, error) {
+	person, err := as.peopleService.GetPersonByID(personID)
+	if err != nil {
+		return "", "", err
+	}
+
+	if valid, _, err := as.passwords.Verify(person.Password, oldPassword); err != nil || !valid {
+		err := errors.New("incorrect password")
+		as.audit(AuditPasswordChange, person.ID, person.Email, device, err)
+		return "", "", err
+	}
+
+	if err := as.passwordPolicy.Check(newPassword, person.Name, person.Email); err != nil {
+		return "", "", err
+	}
 
-	hashedNewPassword, err := utils.HashPassword(newPassword)
+	hashedNewPassword, err := as.passwords.Hash(newPassword)
 	if err != nil {
-		return err
+		return "", "", err
 	}
 
-	if err := as.peopleService.db.Where("email = ?", email).First(&person).Error; err != nil {
-		return err
+	err = as.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(person).Updates(map[string]interface{}{
+			"password":      hashedNewPassword,
+			"token_version": gorm.Expr("token_version + 1"),
+		}).Error; err != nil {
+			return err
+		}
+
+		return NewSessionService(tx).RevokeAllSessions(person.ID)
+	})
+	if err != nil {
+		return "", "", err
 	}
+	as.audit(AuditPasswordChange, person.ID, person.Email, device, nil)
 
-	passwordMatched, err := utils.ComparePasswords(person.Password, oldPassword)
+	// Reload the person to pick up the new token version
+	person, err = as.peopleService.GetPersonByID(personID)
 	if err != nil {
-		return err
+		return "", "", err
+	}
+
+	return as.startSession(person, device)
+}
+
+// RegenerateAccessToken exchanges a refresh token for a new access token and a new refresh token.
+// The presented refresh token is rotated and can't be used again. Presenting an already rotated
+// token revokes its session, since it means the token has leaked.
+func (as *AuthService) RegenerateAccessToken(refreshToken string, device DeviceInfo) (string, string, error) {
+	session, err := as.getSessionForRefreshToken(refreshToken)
+	if err != nil {
+		return "", "", err
+	}
+
+	// The session is active but the token is not the latest one, so it was reused
+	if session.RefreshTokenHash != utils.HashToken(refreshToken) {
+		if err := as.sessionService.RevokeSession(session.PersonID, session.ID); err != nil {
+			return "", "", err
+		}
+		err := errors.New("refresh token reuse detected, please login again")
+		as.audit(AuditRefreshTokenReuse, session.PersonID, "", device, err)
+		return "", "", err
+	}
+
+	person, err := as.peopleService.GetPersonByID(session.PersonID)
+	if err != nil {
+		return "", "", errors.New("invalid refresh token")
 	}
 
-	if !passwordMatched {
-		return errors.New("incorrect password")
+	newRefreshToken, err := as.rotateRefreshToken(person, session)
+	if err != nil {
+		return "", "", err
+	}
+
+	accessToken, err := as.generateAccessToken(person, session.ID)
+	if err != nil {
+		return "", "", err
 	}
 
-	person.Password = hashedNewPassword
-	if err := as.peopleService.db.Save(&person).Error; err != nil {
+	return accessToken, newRefreshToken, nil
+}
+
+func (as *AuthService) Logout(personID, sessionID uint, device DeviceInfo) error {
+	// Logout the session the access token was issued for
+	err := as.sessionService.RevokeSession(personID, sessionID)
+	as.audit(AuditLogout, personID, "", device, err)
+	return err
+}
+
+// RevokeSession logs out one of the sessions of a person.
+func (as *AuthService) RevokeSession(personID, sessionID uint, device DeviceInfo) error {
+	err := as.sessionService.RevokeSession(personID, sessionID)
+	as.audit(AuditSessionRevoked, personID, "", device, err)
+	return err
+}
+
+// RevokeOtherSessions logs out every session of a person except the current one.
+func (as *AuthService) RevokeOtherSessions(personID, currentSessionID uint, device DeviceInfo) error {
+	err := as.sessionService.RevokeOtherSessions(personID, currentSessionID)
+	as.audit(AuditOtherSessionsRevoked, personID, "", device, err)
+	return err
+}
+
+// rehashPassword replaces the stored hash of a person with one made by the preferred hasher.
+// The update is skipped if the password was changed in the meantime.
+func (as *AuthService) rehashPassword(person *models.Person, password string) error {
+	hashedPassword, err := as.passwords.Hash(password)
+	if err != nil {
 		return err
 	}
 
-	return nil
+	return as.db.Model(&models.Person{}).
+		Where("id = ? AND password = ?", person.ID, person.Password).
+		Update("password", hashedPassword).Error
 }
 
-func (as *AuthService) RegenerateAccessToken(refreshToken string) (string, error) {
-	// Regenerate an access token
-	claims, err := utils.ValidateToken(refreshToken, os.Getenv("JWT_REFRESH_SECRET"))
+// audit records an action in the audit log, as failed if err is set.
+func (as *AuthService) audit(action string, personID uint, email string, device DeviceInfo, err error) {
+	as.auditLog.Record(AuditEntry{PersonID: personID, Email: email, Action: action, Err: err, Device: device})
+}
+
+// completeLogin starts a session once the first factor succeeded, unless the person
+// enabled two-factor authentication, in which case a short lived two-factor token is returned.
+func (as *AuthService) completeLogin(person *models.Person, device DeviceInfo) (*LoginResult, error) {
+	if person.DisabledAt != nil {
+		return nil, ErrAccountDisabled
+	}
+
+	if person.TOTPEnabled {
+		twoFactorToken, _, err := utils.GenerateToken(utils.Claims{UserID: person.ID, Email: person.Email, Version: person.TokenVersion, Type: utils.TokenTypeTwoFactor}, twoFactorTokenExpiry, as.accessKeys)
+		if err != nil {
+			return nil, err
+		}
+		return &LoginResult{TwoFactorRequired: true, TwoFactorToken: twoFactorToken}, nil
+	}
+
+	accessToken, refreshToken, err := as.startSession(person, device)
 	if err != nil {
-		return "", err
+		return nil, err
+	}
+
+	return &LoginResult{AccessToken: accessToken, RefreshToken: refreshToken}, nil
+}
+
+// startSession creates a session for the device and returns its first access and refresh token.
+func (as *AuthService) startSession(person *models.Person, device DeviceInfo) (string, string, error) {
+	// Every way of logging in ends here, so a locked account can't get a session
+	if person.DisabledAt != nil {
+		return "", "", ErrAccountDisabled
 	}
 
-	// If the refresh token is valid, check if the user exists
-	_, err = as.peopleService.GetPersonByID(claims.UserID)
+	session, err := as.sessionService.CreateSession(person.ID, device, time.Now().Add(as.cfg.JWT.RefreshTokenExpiry.Duration()))
 	if err != nil {
-		return "", err
+		return "", "", err
 	}
 
-	accessTokenExpiry, err := strconv.Atoi(os.Getenv("JWT_ACCESS_TOKEN_EXPIRY"))
+	refreshToken, err := as.rotateRefreshToken(person, session)
 	if err != nil {
-		return "", err
+		return "", "", err
 	}
 
-	accessToken, _, err := utils.GenerateToken(claims.UserID, claims.Email, time.Minute*time.Duration(accessTokenExpiry), os.Getenv("JWT_ACCESS_SECRET"))
+	accessToken, err := as.generateAccessToken(person, session.ID)
+	if err != nil {
+		return "", "", err
+	}
+
+	return accessToken, refreshToken, nil
+}
+
+// getSessionForRefreshToken validates a refresh token and returns its session if it is still active.
+func (as *AuthService) getSessionForRefreshToken(refreshToken string) (*models.Session, error) {
+	claims, err := utils.ValidateToken(refreshToken, utils.HMACKey(as.cfg.JWT.RefreshSecret))
+	if err != nil || claims.Type != utils.TokenTypeRefresh || claims.SessionID == 0 {
+		return nil, errors.New("invalid refresh token")
+	}
+
+	session, err := as.sessionService.GetSessionByID(claims.SessionID)
+	if err != nil || session.PersonID != claims.UserID || session.Revoked || session.ExpiresAt.Before(time.Now()) {
+		return nil, errors.New("invalid refresh token")
+	}
+
+	return session, nil
+}
+
+// SignedTokenLifetime is the longest lifetime of a token signed with the access keys: access,
+// two-factor and impersonation tokens. A retired key must verify tokens at least this long.
+func SignedTokenLifetime(cfg *config.Config) time.Duration {
+	lifetime := cfg.JWT.AccessTokenExpiry.Duration()
+	for _, expiry := range []time.Duration{twoFactorTokenExpiry, impersonationTokenExpiry} {
+		if expiry > lifetime {
+			lifetime = expiry
+		}
+	}
+	return lifetime
+}
+
+func (as *AuthService) generateAccessToken(person *models.Person, sessionID uint) (string, error) {
+	accessToken, _, err := utils.GenerateToken(utils.Claims{UserID: person.ID, Email: person.Email, SessionID: sessionID, Version: person.TokenVersion, Type: utils.TokenTypeAccess}, as.cfg.JWT.AccessTokenExpiry.Duration(), as.accessKeys)
 	if err != nil {
 		return "", err
 	}
@@ -167,24 +378,32 @@ func (as *AuthService) RegenerateAccessToken(refreshToken string) (string, error
 	return accessToken, nil
 }
 
-func (as *AuthService) Logout(refreshToken string) error {
-	// Logout a user
-	claims, err := utils.ValidateToken(refreshToken, os.Getenv("JWT_REFRESH_SECRET"))
+// rotateRefreshToken issues a new refresh token for the session and stores its hash.
+// The update only applies if the stored hash is unchanged since the session was read,
+// so two concurrent refreshes with the same token can't both succeed.
+func (as *AuthService) rotateRefreshToken(person *models.Person, session *models.Session) (string, error) {
+	refreshToken, expiryTime, err := utils.GenerateToken(utils.Claims{UserID: person.ID, Email: person.Email, SessionID: session.ID, Version: person.TokenVersion, Type: utils.TokenTypeRefresh}, as.cfg.JWT.RefreshTokenExpiry.Duration(), utils.HMACKey(as.cfg.JWT.RefreshSecret))
 	if err != nil {
-		return err
+		return "", err
 	}
 
-	// If the refresh token is valid, check if the user exists
-	person, err := as.peopleService.GetPersonByID(claims.UserID)
-	if err != nil {
-		return err
+	result := as.db.Model(&models.Session{}).
+		Where("id = ? AND revoked = ? AND refresh_token_hash = ?", session.ID, false, session.RefreshTokenHash).
+		Updates(map[string]interface{}{
+			"refresh_token_hash": utils.HashToken(refreshToken),
+			"last_used_at":       time.Now(),
+			"expires_at":         expiryTime,
+		})
+	if result.Error != nil {
+		return "", result.Error
 	}
 
-	person.RefreshToken = ""
-	person.RefreshTokenExpiryDate = nil
-	if err := as.peopleService.db.Save(&person).Error; err != nil {
-		return err
+	if result.RowsAffected == 0 {
+		if err := as.sessionService.RevokeSession(person.ID, session.ID); err != nil {
+			return "", err
+		}
+		return "", errors.New("refresh token reuse detected, please login again")
 	}
 
-	return nil
+	return refreshToken, nil
 }
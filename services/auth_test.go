@@ -0,0 +1,162 @@
+package services
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/yasharya2901/smart_divide/config"
+	"github.com/yasharya2901/smart_divide/models"
+)
+
+func TestSignedTokenLifetime(t *testing.T) {
+	tests := []struct {
+		accessTokenExpiry time.Duration
+		want              time.Duration
+	}{
+		{time.Minute, impersonationTokenExpiry},
+		{10 * time.Minute, impersonationTokenExpiry},
+		{time.Hour, time.Hour},
+	}
+	for _, tt := range tests {
+		cfg := testConfig()
+		cfg.JWT.AccessTokenExpiry = config.Duration(tt.accessTokenExpiry)
+		if got := SignedTokenLifetime(cfg); got != tt.want {
+			t.Errorf("with %s access tokens the lifetime is %s, want %s", tt.accessTokenExpiry, got, tt.want)
+		}
+		if got := SignedTokenLifetime(cfg); got < twoFactorTokenExpiry {
+			t.Errorf("lifetime %s is shorter than two-factor tokens", got)
+		}
+	}
+}
+
+func TestRefreshTokenRotation(t *testing.T) {
+	db := newTestDB(t)
+	as, _ := newTestAuthService(t, db, testConfig())
+	person := createPerson(t, db, "alice")
+
+	_, first, err := as.startSession(person, DeviceInfo{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	accessToken, second, err := as.RegenerateAccessToken(first, DeviceInfo{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if accessToken == "" || second == first {
+		t.Fatal("refresh token not rotated")
+	}
+	_, third, err := as.RegenerateAccessToken(second, DeviceInfo{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The first token was rotated, so presenting it again means it leaked
+	if _, _, err := as.RegenerateAccessToken(first, DeviceInfo{}); err == nil {
+		t.Fatal("rotated refresh token accepted")
+	}
+	if _, _, err := as.RegenerateAccessToken(third, DeviceInfo{}); err == nil {
+		t.Fatal("session still usable after refresh token reuse")
+	}
+
+	var session models.Session
+	if err := db.Where("person_id = ?", person.ID).First(&session).Error; err != nil {
+		t.Fatal(err)
+	}
+	if !session.Revoked {
+		t.Error("session not revoked after refresh token reuse")
+	}
+	var reuse int64
+	as.auditLog.Close()
+	db.Model(&models.AuditEvent{}).Where("action = ? AND person_id = ?", AuditRefreshTokenReuse, person.ID).Count(&reuse)
+	if reuse != 1 {
+		t.Errorf("%d reuse audit events", reuse)
+	}
+}
+
+func TestRefreshTokenReuseRevokesOnlyItsSession(t *testing.T) {
+	db := newTestDB(t)
+	as, _ := newTestAuthService(t, db, testConfig())
+	person := createPerson(t, db, "alice")
+
+	_, laptop, err := as.startSession(person, DeviceInfo{DeviceName: "Laptop"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, phone, err := as.startSession(person, DeviceInfo{DeviceName: "Phone"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, _, err := as.RegenerateAccessToken(laptop, DeviceInfo{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := as.RegenerateAccessToken(laptop, DeviceInfo{}); err == nil {
+		t.Fatal("rotated refresh token accepted")
+	}
+
+	if _, _, err := as.RegenerateAccessToken(phone, DeviceInfo{}); err != nil {
+		t.Errorf("reuse on another device logged out the phone: %v", err)
+	}
+}
+
+func TestRefreshTokenConcurrentUse(t *testing.T) {
+	db := newTestDB(t)
+	as, _ := newTestAuthService(t, db, testConfig())
+	person := createPerson(t, db, "alice")
+
+	_, refreshToken, err := as.startSession(person, DeviceInfo{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Of several requests racing with the same token at most one gets a new token
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var issued []string
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, newToken, err := as.RegenerateAccessToken(refreshToken, DeviceInfo{}); err == nil {
+				mu.Lock()
+				issued = append(issued, newToken)
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	if len(issued) > 1 {
+		t.Fatalf("one refresh token rotated %d times", len(issued))
+	}
+}
+
+func TestRefreshTokenInvalid(t *testing.T) {
+	db := newTestDB(t)
+	as, _ := newTestAuthService(t, db, testConfig())
+	person := createPerson(t, db, "alice")
+
+	accessToken, refreshToken, err := as.startSession(person, DeviceInfo{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, _, err := as.RegenerateAccessToken(accessToken, DeviceInfo{}); err == nil {
+		t.Error("access token accepted as a refresh token")
+	}
+	if _, _, err := as.RegenerateAccessToken(refreshToken+"x", DeviceInfo{}); err == nil {
+		t.Error("tampered refresh token accepted")
+	}
+
+	var session models.Session
+	if err := db.Where("person_id = ?", person.ID).First(&session).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := as.Logout(person.ID, session.ID, DeviceInfo{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := as.RegenerateAccessToken(refreshToken, DeviceInfo{}); err == nil {
+		t.Error("refresh token of a logged out session accepted")
+	}
+}
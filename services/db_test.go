@@ -0,0 +1,118 @@
+package services
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/glebarez/sqlite"
+	"github.com/yasharya2901/smart_divide/config"
+	"github.com/yasharya2901/smart_divide/keyring"
+	"github.com/yasharya2901/smart_divide/mailer"
+	"github.com/yasharya2901/smart_divide/models"
+	"github.com/yasharya2901/smart_divide/passwordhash"
+	"github.com/yasharya2901/smart_divide/passwordpolicy"
+	"github.com/yasharya2901/smart_divide/sms"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// newTestDB returns a migrated SQLite database in a temporary file, which unlike an
+// in-memory one can be shared by concurrent connections.
+func newTestDB(t *testing.T) *gorm.DB {
+	t.Helper()
+
+	dsn := filepath.Join(t.TempDir(), "test.db") + "?_pragma=busy_timeout(5000)&_pragma=foreign_keys(0)"
+	db, err := gorm.Open(sqlite.Open(dsn), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if sqlDB, err := db.DB(); err == nil {
+			sqlDB.Close()
+		}
+	})
+
+	if err := db.AutoMigrate(&models.EventPerson{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.SetupJoinTable(&models.Event{}, "People", &models.EventPerson{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.SetupJoinTable(&models.Person{}, "Events", &models.EventPerson{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.AutoMigrate(
+		&models.Event{},
+		&models.Expense{},
+		&models.Person{},
+		&models.ExpensePerson{},
+		&models.Session{},
+		&models.PasswordResetToken{},
+		&models.EmailVerificationToken{},
+		&models.PhoneOTP{},
+		&models.RecoveryCode{},
+		&models.ExternalIdentity{},
+		&models.OIDCLoginState{},
+		&models.APIKey{},
+		&models.LoginAttempt{},
+		&models.LockoutEvent{},
+		&models.AccountUnlockToken{},
+		&models.SigningKey{},
+		&models.AuditEvent{},
+		&models.WebAuthnCredential{},
+		&models.WebAuthnSession{},
+		&models.MagicLinkToken{},
+		&models.EventInvitation{},
+		&models.Friendship{},
+		&models.ContactLookup{},
+	); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+// createPerson adds an account with a verified email.
+func createPerson(t *testing.T, db *gorm.DB, name string) *models.Person {
+	t.Helper()
+
+	person := models.Person{Name: name, Email: name + "@example.com", EmailVerified: true}
+	if err := db.Create(&person).Error; err != nil {
+		t.Fatal(err)
+	}
+	return &person
+}
+
+// testConfig returns the default config with secrets set and cheap password hashing.
+func testConfig() *config.Config {
+	cfg := config.Default()
+	cfg.JWT.AccessSecret = "test-access-secret-test-access-secret"
+	cfg.JWT.RefreshSecret = "test-refresh-secret-test-refresh-secret"
+	cfg.Password.BreachedList = "none"
+	cfg.PasswordHash.Hasher = "bcrypt"
+	cfg.PasswordHash.BcryptCost = 4
+	return cfg
+}
+
+// newTestAuthService returns an auth service on db that sends mail to a memory mailer.
+func newTestAuthService(t *testing.T, db *gorm.DB, cfg *config.Config) (*AuthService, *mailer.MemoryMailer) {
+	t.Helper()
+
+	accessKeys, err := keyring.New(db, cfg.JWT.SigningAlgorithm, cfg.JWT.AccessSecret, cfg.JWT.AccessTokenExpiry.Duration())
+	if err != nil {
+		t.Fatal(err)
+	}
+	policy, err := passwordpolicy.New(cfg.Password)
+	if err != nil {
+		t.Fatal(err)
+	}
+	passwords, err := passwordhash.NewFromConfig(cfg.PasswordHash)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	auditLog := NewAuditLog(db)
+	t.Cleanup(auditLog.Close)
+
+	mail := mailer.NewMemoryMailer()
+	return NewAuthService(db, cfg, mail, sms.NewMemorySender(), NewMemoryLoginAttemptStore(), accessKeys, auditLog, policy, passwords), mail
+}
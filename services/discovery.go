@@ -0,0 +1,198 @@
+package services
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/yasharya2901/smart_divide/models"
+	"github.com/yasharya2901/smart_divide/utils"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	maxHashesPerLookup  = 250
+	contactLookupWindow = 24 * time.Hour
+	maxHashesPerWindow  = 1000
+)
+
+// LookupRateLimitedError is returned when a person looked up too many contacts recently.
+type LookupRateLimitedError struct {
+	Until time.Time
+}
+
+func (e *LookupRateLimitedError) Error() string {
+	return "too many contacts looked up, please try again later"
+}
+
+// DiscoveredPerson is an account matching a looked up hash. Only the name is shared, the
+// caller already knows the email or number they hashed.
+type DiscoveredPerson struct {
+	ID   uint   `json:"id"`
+	Name string `json:"name"`
+	Hash string `json:"hash"`
+}
+
+type DiscoveryService struct {
+	db *gorm.DB
+}
+
+func NewDiscoveryService(db *gorm.DB) *DiscoveryService {
+	return &DiscoveryService{db: db}
+}
+
+// NormalizeEmail returns the form of an email that is hashed for discovery.
+func NormalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+// NormalizeContact returns the form of a contact number that is hashed for discovery, the
+// leading plus and the digits.
+func NormalizeContact(contact string) string {
+	var b strings.Builder
+	for i, r := range strings.TrimSpace(contact) {
+		if (r >= '0' && r <= '9') || (r == '+' && i == 0) {
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// emailHash and contactHash are the hex encoded SHA-256 hashes clients send to find
+// people, or an empty string for an empty value.
+func emailHash(email string) string {
+	if email = NormalizeEmail(email); email == "" {
+		return ""
+	}
+	return utils.HashToken(email)
+}
+
+func contactHash(contact string) string {
+	if contact = NormalizeContact(contact); contact == "" {
+		return ""
+	}
+	return utils.HashToken(contact)
+}
+
+// SetDiscoverable turns discovery of the person by email and number on or off. The hashes
+// are refreshed when it is turned on, so accounts from before discovery existed can opt in.
+func (ds *DiscoveryService) SetDiscoverable(personID uint, discoverable bool) error {
+	var person models.Person
+	if err := ds.db.First(&person, personID).Error; err != nil {
+		return err
+	}
+
+	updates := map[string]interface{}{"discoverable": discoverable}
+	if discoverable {
+		updates["email_hash"] = emailHash(person.Email)
+		updates["contact_hash"] = contactHash(person.Contact)
+	}
+	return ds.db.Model(&person).Updates(updates).Error
+}
+
+// Discover returns the discoverable people whose verified email or contact number hashes to
+// one of the given hashes, leaving out the caller. Each person counts the hashes they look
+// up, and is refused once they looked up too many within a day.
+func (ds *DiscoveryService) Discover(personID uint, emailHashes, contactHashes []string) ([]DiscoveredPerson, error) {
+	emailHashes = normalizeHashes(emailHashes)
+	contactHashes = normalizeHashes(contactHashes)
+	count := len(emailHashes) + len(contactHashes)
+	if count == 0 {
+		return []DiscoveredPerson{}, nil
+	}
+	if count > maxHashesPerLookup {
+		return nil, fmt.Errorf("too many hashes, at most %d can be looked up at once", maxHashesPerLookup)
+	}
+
+	if err := ds.recordLookup(personID, count); err != nil {
+		return nil, err
+	}
+
+	matches := ds.db.Where("1 = 0")
+	if len(emailHashes) > 0 {
+		matches = matches.Or("email_hash IN ? AND email_verified = ?", emailHashes, true)
+	}
+	if len(contactHashes) > 0 {
+		matches = matches.Or("contact_hash IN ? AND contact_verified = ?", contactHashes, true)
+	}
+
+	var people []models.Person
+	if err := ds.db.Where("discoverable = ? AND placeholder = ? AND anonymized_at IS NULL AND disabled_at IS NULL AND id <> ?", true, false, personID).
+		Where(matches).Find(&people).Error; err != nil {
+		return nil, err
+	}
+
+	wanted := make(map[string]bool, count)
+	for _, hash := range append(emailHashes, contactHashes...) {
+		wanted[hash] = true
+	}
+
+	// The hashes are checked against the current email and number, so a stale hash can't
+	// reveal a previous one
+	found := []DiscoveredPerson{}
+	for _, person := range people {
+		if hash := emailHash(person.Email); person.EmailVerified && hash != "" && wanted[hash] {
+			found = append(found, DiscoveredPerson{ID: person.ID, Name: person.Name, Hash: hash})
+		}
+		if hash := contactHash(person.Contact); person.ContactVerified && hash != "" && wanted[hash] {
+			found = append(found, DiscoveredPerson{ID: person.ID, Name: person.Name, Hash: hash})
+		}
+	}
+	return found, nil
+}
+
+// recordLookup stores a lookup of count hashes, or returns a *LookupRateLimitedError if
+// it would go over the daily limit of the person.
+func (ds *DiscoveryService) recordLookup(personID uint, count int) error {
+	return ds.db.Transaction(func(tx *gorm.DB) error {
+		// Locking the person makes concurrent lookups wait for each other, otherwise they
+		// would all count the same lookups and go over the limit together
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Select("id").
+			First(&models.Person{}, personID).Error; err != nil {
+			return err
+		}
+
+		var recent []models.ContactLookup
+		if err := tx.Where("person_id = ? AND created_at > ?", personID, time.Now().Add(-contactLookupWindow)).
+			Order("created_at").Find(&recent).Error; err != nil {
+			return err
+		}
+
+		total := count
+		for _, lookup := range recent {
+			total += lookup.Hashes
+		}
+
+		// The limit frees up as the oldest lookups leave the window
+		if total > maxHashesPerWindow {
+			until := time.Now().Add(contactLookupWindow)
+			for _, lookup := range recent {
+				total -= lookup.Hashes
+				if total <= maxHashesPerWindow {
+					until = lookup.CreatedAt.Add(contactLookupWindow)
+					break
+				}
+			}
+			return &LookupRateLimitedError{Until: until}
+		}
+
+		return tx.Create(&models.ContactLookup{PersonID: personID, Hashes: count}).Error
+	})
+}
+
+// normalizeHashes lowercases the hashes and drops duplicates and anything that is not a
+// hex encoded SHA-256 hash.
+func normalizeHashes(hashes []string) []string {
+	seen := make(map[string]bool, len(hashes))
+	var res []string
+	for _, hash := range hashes {
+		hash = strings.ToLower(strings.TrimSpace(hash))
+		if len(hash) != 64 || strings.Trim(hash, "0123456789abcdef") != "" || seen[hash] {
+			continue
+		}
+		seen[hash] = true
+		res = append(res, hash)
+	}
+	return res
+}
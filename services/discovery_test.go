@@ -0,0 +1,106 @@
+package services
+
+import (
+	"errors"
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/yasharya2901/smart_divide/models"
+	"github.com/yasharya2901/smart_divide/utils"
+)
+
+// testHashes returns n distinct hashes that match nobody.
+func testHashes(n int) []string {
+	hashes := make([]string, n)
+	for i := range hashes {
+		hashes[i] = utils.HashToken("nobody" + strconv.Itoa(i))
+	}
+	return hashes
+}
+
+func TestDiscover(t *testing.T) {
+	db := newTestDB(t)
+	ds := NewDiscoveryService(db)
+	caller := createPerson(t, db, "caller")
+	friend := createPerson(t, db, "friend")
+	hidden := createPerson(t, db, "hidden")
+	if err := ds.SetDiscoverable(friend.ID, true); err != nil {
+		t.Fatal(err)
+	}
+	if err := ds.SetDiscoverable(caller.ID, true); err != nil {
+		t.Fatal(err)
+	}
+
+	found, err := ds.Discover(caller.ID, []string{
+		emailHash(" Friend@Example.com"),
+		emailHash(hidden.Email),
+		emailHash(caller.Email),
+		"not a hash",
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(found) != 1 || found[0].ID != friend.ID || found[0].Hash != emailHash(friend.Email) {
+		t.Errorf("found %+v, want only the friend", found)
+	}
+
+	// A changed email no longer matches its old hash
+	oldHash := emailHash(friend.Email)
+	if err := db.Model(friend).Update("email", "new@example.com").Error; err != nil {
+		t.Fatal(err)
+	}
+	if found, err := ds.Discover(caller.ID, []string{oldHash}, nil); err != nil || len(found) != 0 {
+		t.Errorf("old email found %+v, %v", found, err)
+	}
+}
+
+func TestDiscoverLimit(t *testing.T) {
+	db := newTestDB(t)
+	ds := NewDiscoveryService(db)
+	caller := createPerson(t, db, "caller")
+
+	if _, err := ds.Discover(caller.ID, testHashes(maxHashesPerLookup+1), nil); err == nil {
+		t.Error("lookup of too many hashes accepted")
+	}
+
+	for looked := 0; looked+maxHashesPerLookup <= maxHashesPerWindow; looked += maxHashesPerLookup {
+		if _, err := ds.Discover(caller.ID, testHashes(maxHashesPerLookup), nil); err != nil {
+			t.Fatalf("lookup after %d hashes: %v", looked, err)
+		}
+	}
+	var limited *LookupRateLimitedError
+	if _, err := ds.Discover(caller.ID, testHashes(1), nil); !errors.As(err, &limited) {
+		t.Errorf("lookup over the limit returned %v", err)
+	}
+
+	// The limit is per person
+	if _, err := ds.Discover(createPerson(t, db, "other").ID, testHashes(1), nil); err != nil {
+		t.Errorf("another person was limited: %v", err)
+	}
+}
+
+func TestDiscoverConcurrentLookups(t *testing.T) {
+	db := newTestDB(t)
+	ds := NewDiscoveryService(db)
+	caller := createPerson(t, db, "caller")
+
+	var wg sync.WaitGroup
+	for i := 0; i < 3*maxHashesPerWindow/maxHashesPerLookup; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ds.Discover(caller.ID, testHashes(maxHashesPerLookup), nil)
+		}()
+	}
+	wg.Wait()
+
+	var total int64
+	if err := db.Model(&models.ContactLookup{}).Where("person_id = ?", caller.ID).
+		Select("COALESCE(SUM(hashes), 0)").Scan(&total).Error; err != nil {
+		t.Fatal(err)
+	}
+	if total == 0 || total > maxHashesPerWindow {
+		t.Errorf("%d hashes looked up concurrently, the limit is %d", total, maxHashesPerWindow)
+	}
+}
@@ -0,0 +1,147 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/yasharya2901/smart_divide/config"
+	"github.com/yasharya2901/smart_divide/mailer"
+	"github.com/yasharya2901/smart_divide/models"
+	"github.com/yasharya2901/smart_divide/utils"
+	"gorm.io/gorm"
+)
+
+const emailVerificationTokenExpiry = 24 * time.Hour
+
+type EmailVerificationService struct {
+	db     *gorm.DB
+	cfg    *config.Config
+	mailer mailer.Mailer
+}
+
+func NewEmailVerificationService(db *gorm.DB, cfg *config.Config, mail mailer.Mailer) *EmailVerificationService {
+	return &EmailVerificationService{db: db, cfg: cfg, mailer: mail}
+}
+
+// SendVerification emails a verification link for the given address of a person.
+// The address is either the current email of the person or their pending new email.
+func (evs *EmailVerificationService) SendVerification(person *models.Person, email string) error {
+	token, err := utils.GenerateRandomToken(32)
+	if err != nil {
+		return err
+	}
+
+	err = evs.db.Transaction(func(tx *gorm.DB) error {
+		// Only the most recently sent link stays valid
+		if err := tx.Model(&models.EmailVerificationToken{}).
+			Where("person_id = ? AND used_at IS NULL", person.ID).
+			Update("expires_at", time.Now()).Error; err != nil {
+			return err
+		}
+
+		return tx.Create(&models.EmailVerificationToken{
+			PersonID:  person.ID,
+			Email:     email,
+			TokenHash: utils.HashToken(token),
+			ExpiresAt: time.Now().Add(emailVerificationTokenExpiry),
+		}).Error
+	})
+	if err != nil {
+		return err
+	}
+
+	body := fmt.Sprintf("Hi %s,\n\nPlease confirm your email address for Smart Divide using the link below. It expires in %d hours.\n\n%s",
+		person.Name, int(emailVerificationTokenExpiry.Hours()), tokenLink(evs.cfg.Links.EmailVerification, token))
+
+	return evs.mailer.Send(email, "Confirm your email address", body)
+}
+
+// ResendVerification sends a new link for the pending email of a person, or for their
+// current email if it is not verified yet.
+func (evs *EmailVerificationService) ResendVerification(personID uint) error {
+	var person models.Person
+	if err := evs.db.First(&person, personID).Error; err != nil {
+		return err
+	}
+
+	switch {
+	case person.PendingEmail != "":
+		return evs.SendVerification(&person, person.PendingEmail)
+	case !person.EmailVerified:
+		return evs.SendVerification(&person, person.Email)
+	default:
+		return errors.New("email is already verified")
+	}
+}
+
+// VerifyEmail confirms the address a verification token was sent to. If it was a pending
+// email change, the new address replaces the current email of the person.
+func (evs *EmailVerificationService) VerifyEmail(token string) (*models.Person, error) {
+	var verificationToken models.EmailVerificationToken
+	if err := evs.db.Where("token_hash = ? AND used_at IS NULL AND expires_at > ?", utils.HashToken(token), time.Now()).
+		First(&verificationToken).Error; err != nil {
+		return nil, errors.New("invalid or expired verification token")
+	}
+
+	var person models.Person
+	err := evs.db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&models.EmailVerificationToken{}).
+			Where("id = ? AND used_at IS NULL", verificationToken.ID).
+			Update("used_at", time.Now())
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return errors.New("invalid or expired verification token")
+		}
+
+		if err := tx.First(&person, verificationToken.PersonID).Error; err != nil {
+			return err
+		}
+
+		if person.Email != verificationToken.Email {
+			// The pending email may have been replaced by a newer change since the link was sent
+			if person.PendingEmail != verificationToken.Email {
+				return errors.New("invalid or expired verification token")
+			}
+
+			var count int64
+			if err := tx.Model(&models.Person{}).Where("email = ? AND id <> ?", verificationToken.Email, person.ID).Count(&count).Error; err != nil {
+				return err
+			}
+			if count > 0 {
+				return errors.New("email already registered")
+			}
+
+			person.Email = verificationToken.Email
+			person.EmailHash = emailHash(person.Email)
+			person.PendingEmail = ""
+		}
+
+		person.EmailVerified = true
+		if err := tx.Save(&person).Error; err != nil {
+			return err
+		}
+
+		// Placeholders added with this email now belong to the person
+		return NewPlaceholderService(tx).ClaimPlaceholders(person.ID, person.Email, "")
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &person, nil
+}
+
+// VerifyEmail confirms an email address with a verification token and records it in the audit log.
+func (as *AuthService) VerifyEmail(token string, device DeviceInfo) (*models.Person, error) {
+	person, err := as.emailVerificationService.VerifyEmail(token)
+	if err != nil {
+		as.audit(AuditEmailVerified, 0, "", device, err)
+		return nil, err
+	}
+
+	as.audit(AuditEmailVerified, person.ID, person.Email, device, nil)
+	return person, nil
+}
@@ -1,7 +1,11 @@
 package services
 
 import (
+	"errors"
+	"fmt"
+
 	"github.com/yasharya2901/smart_divide/models"
+	"github.com/yasharya2901/smart_divide/money"
 	"gorm.io/gorm"
 )
 
@@ -13,21 +17,40 @@ func NewEventService(db *gorm.DB) *EventService {
 	return &EventService{db: db}
 }
 
-func (ec *EventService) CreateEvent(name string) (*models.Event, error) {
-	// Create an event
+// EventMember is a person in an event along with their role.
+type EventMember struct {
+	models.Person
+	Role string
+}
+
+func (ec *EventService) CreateEvent(name, currency string, ownerID uint) (*models.Event, error) {
+	// Create an event, the creator becomes its owner
+	currency = money.NormalizeCurrency(currency)
+	if !money.ValidCurrency(currency) {
+		return nil, fmt.Errorf("invalid currency %q", currency)
+	}
+
 	event := models.Event{
-		Name: name,
+		Name:     name,
+		Currency: currency,
 	}
-	if err := ec.db.Create(&event).Error; err != nil {
+	err := ec.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&event).Error; err != nil {
+			return err
+		}
+		return tx.Create(&models.EventPerson{EventID: event.ID, PersonID: ownerID, Role: RoleOwner}).Error
+	})
+	if err != nil {
 		return nil, err
 	}
 	return &event, nil
 }
 
-func (ec *EventService) GetEvents() ([]models.Event, error) {
-	// Get all events
+func (ec *EventService) GetEvents(personID uint) ([]models.Event, error) {
+	// Get all events the person is a member of
 	var events []models.Event
-	if err := ec.db.Find(&events).Error; err != nil {
+	if err := ec.db.Joins("JOIN event_people ON event_people.event_id = events.id").
+		Where("event_people.person_id = ?", personID).Find(&events).Error; err != nil {
 		return nil, err
 	}
 	return events, nil
@@ -74,33 +97,96 @@ func (ec *EventService) DeleteEvent(id uint) error {
 	return nil
 }
 
-func (ec *EventService) AddPersonToEvent(eventID, personID uint) error {
-	// Add a person to an event
-	var event models.Event
+func (ec *EventService) GetMembers(eventID uint) ([]EventMember, error) {
+	// Get the people of an event with their roles
+	var memberships []models.EventPerson
+	if err := ec.db.Where("event_id = ?", eventID).Find(&memberships).Error; err != nil {
+		return nil, err
+	}
+
+	roles := make(map[uint]string, len(memberships))
+	personIDs := make([]uint, len(memberships))
+	for i, membership := range memberships {
+		roles[membership.PersonID] = membership.Role
+		personIDs[i] = membership.PersonID
+	}
+
+	var people []models.Person
+	if err := ec.db.Where("id IN ?", personIDs).Find(&people).Error; err != nil {
+		return nil, err
+	}
+
+	members := make([]EventMember, len(people))
+	for i, person := range people {
+		members[i] = EventMember{Person: person, Role: roles[person.ID]}
+	}
+	return members, nil
+}
 
-	if err := ec.db.Preload("People").First(&event, eventID).Error; err != nil {
+// GetRole returns the role of a person in an event, or an empty string if they are not a member.
+func (ec *EventService) GetRole(eventID, personID uint) (string, error) {
+	var memberships []models.EventPerson
+	if err := ec.db.Where("event_id = ? AND person_id = ?", eventID, personID).Limit(1).Find(&memberships).Error; err != nil {
+		return "", err
+	}
+	if len(memberships) == 0 {
+		return "", nil
+	}
+	return memberships[0].Role, nil
+}
+
+// Authorize checks that the person is a member of the event whose role grants the permission.
+func (ec *EventService) Authorize(eventID, personID uint, permission Permission) error {
+	if _, err := ec.GetEventByID(eventID, false); err != nil {
 		return err
 	}
 
-	var person models.Person
-	if err := ec.db.First(&person, personID).Error; err != nil {
+	role, err := ec.GetRole(eventID, personID)
+	if err != nil {
 		return err
 	}
 
-	event.People = append(event.People, person)
+	if !RoleHasPermission(role, permission) {
+		return ErrForbidden
+	}
+	return nil
+}
+
+// AuthorizeRoleChange checks that the actor may give the person the new role in the event.
+// An empty role means adding the person or removing them from the event. Anyone may leave
+// an event on their own.
+func (ec *EventService) AuthorizeRoleChange(eventID, actorID, personID uint, newRole string) error {
+	if _, err := ec.GetEventByID(eventID, false); err != nil {
+		return err
+	}
 
-	if err := ec.db.Save(&event).Error; err != nil {
+	actorRole, err := ec.GetRole(eventID, actorID)
+	if err != nil {
 		return err
 	}
 
+	currentRole, err := ec.GetRole(eventID, personID)
+	if err != nil {
+		return err
+	}
+
+	if actorID == personID && newRole == "" && currentRole != "" {
+		return nil
+	}
+
+	if !canAssignRole(actorRole, currentRole, newRole) {
+		return ErrForbidden
+	}
 	return nil
 }
 
-func (ec *EventService) RemovePersonFromEvent(eventID, personID uint) error {
-	// Remove a person from an event
-	var event models.Event
+func (ec *EventService) AddPersonToEvent(eventID, personID uint, role string) error {
+	// Add a person to an event with a role
+	if !IsValidRole(role) {
+		return errors.New("invalid role")
+	}
 
-	if err := ec.db.Preload("People").First(&event, eventID).Error; err != nil {
+	if _, err := ec.GetEventByID(eventID, false); err != nil {
 		return err
 	}
 
@@ -108,17 +194,100 @@ func (ec *EventService) RemovePersonFromEvent(eventID, personID uint) error {
 	if err := ec.db.First(&person, personID).Error; err != nil {
 		return err
 	}
+	if err := checkPlaceholderRole(&person, role); err != nil {
+		return err
+	}
+
+	currentRole, err := ec.GetRole(eventID, personID)
+	if err != nil {
+		return err
+	}
+	if currentRole != "" {
+		return errors.New("person is already a member of the event")
+	}
+
+	return ec.db.Create(&models.EventPerson{EventID: eventID, PersonID: personID, Role: role}).Error
+}
+
+func (ec *EventService) UpdateMemberRole(eventID, personID uint, role string) error {
+	// Change the role of a member of an event
+	if !IsValidRole(role) {
+		return errors.New("invalid role")
+	}
+
+	var person models.Person
+	if err := ec.db.First(&person, personID).Error; err != nil {
+		return err
+	}
+	if err := checkPlaceholderRole(&person, role); err != nil {
+		return err
+	}
+
+	return ec.db.Transaction(func(tx *gorm.DB) error {
+		if role != RoleOwner {
+			if err := ensureOwnerRemains(tx, eventID, personID); err != nil {
+				return err
+			}
+		}
+
+		result := tx.Model(&models.EventPerson{}).
+			Where("event_id = ? AND person_id = ?", eventID, personID).
+			Update("role", role)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return gorm.ErrRecordNotFound
+		}
+		return nil
+	})
+}
 
-	for i, p := range event.People {
-		if p.ID == person.ID {
-			event.People = append(event.People[:i], event.People[i+1:]...)
-			break
+func (ec *EventService) RemovePersonFromEvent(eventID, personID uint) error {
+	// Remove a person from an event
+	return ec.db.Transaction(func(tx *gorm.DB) error {
+		if err := ensureOwnerRemains(tx, eventID, personID); err != nil {
+			return err
+		}
+
+		result := tx.Where("event_id = ? AND person_id = ?", eventID, personID).Delete(&models.EventPerson{})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return gorm.ErrRecordNotFound
 		}
+		return nil
+	})
+}
+
+// checkPlaceholderRole fails if a placeholder would get a role that manages the event,
+// since a placeholder can't log in to use it.
+func checkPlaceholderRole(person *models.Person, role string) error {
+	if person.Placeholder && role != RoleMember && role != RoleViewer {
+		return errors.New("placeholder members can only be members or viewers")
 	}
+	return nil
+}
 
-	if err := ec.db.Save(&event).Error; err != nil {
+// ensureOwnerRemains fails if the person is the last owner of the event.
+func ensureOwnerRemains(tx *gorm.DB, eventID, personID uint) error {
+	var otherOwners int64
+	if err := tx.Model(&models.EventPerson{}).
+		Where("event_id = ? AND role = ? AND person_id <> ?", eventID, RoleOwner, personID).
+		Count(&otherOwners).Error; err != nil {
 		return err
 	}
 
+	var isOwner int64
+	if err := tx.Model(&models.EventPerson{}).
+		Where("event_id = ? AND role = ? AND person_id = ?", eventID, RoleOwner, personID).
+		Count(&isOwner).Error; err != nil {
+		return err
+	}
+
+	if isOwner > 0 && otherOwners == 0 {
+		return errors.New("an event needs at least one owner")
+	}
 	return nil
 }
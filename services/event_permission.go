@@ -0,0 +1,75 @@
+package services
+
+import (
+	"errors"
+	"slices"
+)
+
+// Roles a person can have in an event.
+const (
+	RoleOwner  = "owner"
+	RoleAdmin  = "admin"
+	RoleMember = "member"
+	RoleViewer = "viewer"
+)
+
+var EventRoles = []string{RoleOwner, RoleAdmin, RoleMember, RoleViewer}
+
+// Permission is an action on an event or its expenses.
+type Permission string
+
+const (
+	PermissionViewEvent      Permission = "event:view"
+	PermissionUpdateEvent    Permission = "event:update"
+	PermissionDeleteEvent    Permission = "event:delete"
+	PermissionManageMembers  Permission = "members:manage"
+	PermissionCreateExpense  Permission = "expense:create"
+	PermissionEditAnyExpense Permission = "expense:edit_any"
+	PermissionEditOwnExpense Permission = "expense:edit_own" // Expenses the person created or paid
+)
+
+var rolePermissions = map[string][]Permission{
+	RoleOwner: {
+		PermissionViewEvent, PermissionUpdateEvent, PermissionDeleteEvent, PermissionManageMembers,
+		PermissionCreateExpense, PermissionEditAnyExpense, PermissionEditOwnExpense,
+	},
+	RoleAdmin: {
+		PermissionViewEvent, PermissionUpdateEvent, PermissionManageMembers,
+		PermissionCreateExpense, PermissionEditAnyExpense, PermissionEditOwnExpense,
+	},
+	RoleMember: {
+		PermissionViewEvent, PermissionCreateExpense, PermissionEditOwnExpense,
+	},
+	RoleViewer: {
+		PermissionViewEvent,
+	},
+}
+
+// ErrForbidden is returned when a person's role in an event doesn't allow an action.
+var ErrForbidden = errors.New("you don't have permission to do this")
+
+// RoleHasPermission reports whether the role grants the permission.
+func RoleHasPermission(role string, permission Permission) bool {
+	return slices.Contains(rolePermissions[role], permission)
+}
+
+// IsValidRole reports whether role is one of EventRoles.
+func IsValidRole(role string) bool {
+	return slices.Contains(EventRoles, role)
+}
+
+// canAssignRole reports whether a member with actorRole may move another member from
+// fromRole to toRole. An empty role means the person is not a member. Owners can do
+// anything, admins can only manage members and viewers.
+func canAssignRole(actorRole, fromRole, toRole string) bool {
+	if !RoleHasPermission(actorRole, PermissionManageMembers) {
+		return false
+	}
+
+	if actorRole == RoleOwner {
+		return true
+	}
+
+	managed := []string{"", RoleMember, RoleViewer}
+	return slices.Contains(managed, fromRole) && slices.Contains(managed, toRole)
+}
@@ -0,0 +1,216 @@
+package services
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/yasharya2901/smart_divide/models"
+	"github.com/yasharya2901/smart_divide/money"
+)
+
+func TestRolePermissions(t *testing.T) {
+	allowed := map[Permission][]string{
+		PermissionViewEvent:      {RoleOwner, RoleAdmin, RoleMember, RoleViewer},
+		PermissionUpdateEvent:    {RoleOwner, RoleAdmin},
+		PermissionDeleteEvent:    {RoleOwner},
+		PermissionManageMembers:  {RoleOwner, RoleAdmin},
+		PermissionCreateExpense:  {RoleOwner, RoleAdmin, RoleMember},
+		PermissionEditAnyExpense: {RoleOwner, RoleAdmin},
+		PermissionEditOwnExpense: {RoleOwner, RoleAdmin, RoleMember},
+	}
+	for permission, roles := range allowed {
+		for _, role := range append(EventRoles, "", "superuser") {
+			want := false
+			for _, r := range roles {
+				want = want || r == role
+			}
+			if got := RoleHasPermission(role, permission); got != want {
+				t.Errorf("role %q has %s: %v, want %v", role, permission, got, want)
+			}
+		}
+	}
+}
+
+func TestCanAssignRole(t *testing.T) {
+	tests := []struct {
+		actor, from, to string
+		want            bool
+	}{
+		// Owners can make any change
+		{RoleOwner, "", RoleOwner, true},
+		{RoleOwner, RoleAdmin, RoleViewer, true},
+		{RoleOwner, RoleOwner, "", true},
+
+		// Admins manage members and viewers only
+		{RoleAdmin, "", RoleMember, true},
+		{RoleAdmin, "", RoleViewer, true},
+		{RoleAdmin, RoleViewer, RoleMember, true},
+		{RoleAdmin, RoleMember, "", true},
+		{RoleAdmin, "", RoleAdmin, false},
+		{RoleAdmin, RoleMember, RoleAdmin, false},
+		{RoleAdmin, RoleAdmin, RoleMember, false},
+		{RoleAdmin, RoleOwner, "", false},
+		{RoleAdmin, RoleMember, RoleOwner, false},
+
+		// Nobody else manages members
+		{RoleMember, "", RoleMember, false},
+		{RoleMember, RoleViewer, "", false},
+		{RoleViewer, "", RoleViewer, false},
+		{"", "", RoleMember, false},
+	}
+	for _, tt := range tests {
+		if got := canAssignRole(tt.actor, tt.from, tt.to); got != tt.want {
+			t.Errorf("%q moving %q to %q: %v, want %v", tt.actor, tt.from, tt.to, got, tt.want)
+		}
+	}
+}
+
+// newRoleEvent creates an event with a person of each role, by role, and one outsider under "".
+func newRoleEvent(t *testing.T, ec *EventService) (*models.Event, map[string]*models.Person) {
+	t.Helper()
+
+	people := map[string]*models.Person{}
+	for _, role := range append(EventRoles, "") {
+		people[role] = createPerson(t, ec.db, "person-"+role)
+	}
+
+	event, err := ec.CreateEvent("Trip", "USD", people[RoleOwner].ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, role := range []string{RoleAdmin, RoleMember, RoleViewer} {
+		if err := ec.AddPersonToEvent(event.ID, people[role].ID, role); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return event, people
+}
+
+func TestAuthorize(t *testing.T) {
+	ec := NewEventService(newTestDB(t))
+	event, people := newRoleEvent(t, ec)
+
+	for role, person := range people {
+		for _, permission := range []Permission{PermissionViewEvent, PermissionUpdateEvent, PermissionDeleteEvent, PermissionManageMembers} {
+			err := ec.Authorize(event.ID, person.ID, permission)
+			if RoleHasPermission(role, permission) {
+				if err != nil {
+					t.Errorf("%q denied %s: %v", role, permission, err)
+				}
+			} else if !errors.Is(err, ErrForbidden) {
+				t.Errorf("%q allowed %s: %v", role, permission, err)
+			}
+		}
+	}
+
+	if err := ec.Authorize(event.ID+1, people[RoleOwner].ID, PermissionViewEvent); err == nil || errors.Is(err, ErrForbidden) {
+		t.Errorf("unknown event returned %v, want not found", err)
+	}
+}
+
+func TestAuthorizeExpense(t *testing.T) {
+	db := newTestDB(t)
+	ec := NewExpenseService(db)
+	event, people := newRoleEvent(t, ec.eventService)
+
+	ownExpense, err := ec.CreateExpense("Taxi", money.Decimal("12.50"), event.ID, people[RoleMember].ID, people[RoleMember].ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherExpense, err := ec.CreateExpense("Hotel", money.Decimal("200"), event.ID, people[RoleOwner].ID, people[RoleOwner].ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		role     string
+		expense  *models.Expense
+		view     bool
+		editable bool
+	}{
+		{RoleOwner, otherExpense, true, true},
+		{RoleAdmin, ownExpense, true, true},
+		{RoleMember, ownExpense, true, true},
+		{RoleMember, otherExpense, true, false},
+		{RoleViewer, ownExpense, true, false},
+		{"", ownExpense, false, false},
+	}
+	for _, tt := range tests {
+		person := people[tt.role]
+		if err := ec.AuthorizeExpense(tt.expense.ID, person.ID, false); (err == nil) != tt.view {
+			t.Errorf("%q viewing %s: %v", tt.role, tt.expense.Name, err)
+		}
+		if err := ec.AuthorizeExpense(tt.expense.ID, person.ID, true); (err == nil) != tt.editable {
+			t.Errorf("%q editing %s: %v", tt.role, tt.expense.Name, err)
+		}
+	}
+}
+
+func TestAuthorizeRoleChange(t *testing.T) {
+	ec := NewEventService(newTestDB(t))
+	event, people := newRoleEvent(t, ec)
+	newcomer := createPerson(t, ec.db, "newcomer")
+
+	tests := []struct {
+		name   string
+		actor  string
+		person uint
+		role   string
+		want   bool
+	}{
+		{"owner makes an admin", RoleOwner, people[RoleMember].ID, RoleAdmin, true},
+		{"admin adds a member", RoleAdmin, newcomer.ID, RoleMember, true},
+		{"admin removes a viewer", RoleAdmin, people[RoleViewer].ID, "", true},
+		{"admin removes the owner", RoleAdmin, people[RoleOwner].ID, "", false},
+		{"member adds a member", RoleMember, newcomer.ID, RoleMember, false},
+		{"viewer leaves", RoleViewer, people[RoleViewer].ID, "", true},
+		{"viewer promotes themselves", RoleViewer, people[RoleViewer].ID, RoleMember, false},
+		{"outsider joins", "", people[""].ID, RoleMember, false},
+	}
+	for _, tt := range tests {
+		err := ec.AuthorizeRoleChange(event.ID, people[tt.actor].ID, tt.person, tt.role)
+		if (err == nil) != tt.want {
+			t.Errorf("%s: %v", tt.name, err)
+		}
+	}
+}
+
+func TestEventKeepsAnOwner(t *testing.T) {
+	ec := NewEventService(newTestDB(t))
+	event, people := newRoleEvent(t, ec)
+	owner := people[RoleOwner].ID
+
+	if err := ec.UpdateMemberRole(event.ID, owner, RoleAdmin); err == nil {
+		t.Error("last owner demoted")
+	}
+	if err := ec.RemovePersonFromEvent(event.ID, owner); err == nil {
+		t.Error("last owner removed")
+	}
+
+	// With a second owner the first may go
+	if err := ec.UpdateMemberRole(event.ID, people[RoleAdmin].ID, RoleOwner); err != nil {
+		t.Fatal(err)
+	}
+	if err := ec.RemovePersonFromEvent(event.ID, owner); err != nil {
+		t.Errorf("owner could not leave with another owner left: %v", err)
+	}
+}
+
+func TestPlaceholderRoles(t *testing.T) {
+	ec := NewEventService(newTestDB(t))
+	event, _ := newRoleEvent(t, ec)
+
+	placeholder := models.Person{Name: "Placeholder", Placeholder: true}
+	if err := ec.db.Create(&placeholder).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := ec.AddPersonToEvent(event.ID, placeholder.ID, RoleAdmin); err == nil {
+		t.Error("placeholder added as an admin")
+	}
+	if err := ec.AddPersonToEvent(event.ID, placeholder.ID, RoleMember); err != nil {
+		t.Fatal(err)
+	}
+	if err := ec.UpdateMemberRole(event.ID, placeholder.ID, RoleOwner); err == nil {
+		t.Error("placeholder made an owner")
+	}
+}
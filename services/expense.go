@@ -4,24 +4,41 @@ import (
 	"errors"
 
 	"github.com/yasharya2901/smart_divide/models"
+	"github.com/yasharya2901/smart_divide/money"
 	"gorm.io/gorm"
 )
 
 type ExpenseService struct {
-	db *gorm.DB
+	db           *gorm.DB
+	eventService *EventService
 }
 
 func NewExpenseService(db *gorm.DB) *ExpenseService {
-	return &ExpenseService{db: db}
+	return &ExpenseService{db: db, eventService: NewEventService(db)}
 }
 
-func (ec *ExpenseService) CreateExpense(name string, totalAmount float64, eventID, paidByUserId uint) (*models.Expense, error) {
-	// Create an expense
+func (ec *ExpenseService) CreateExpense(name string, totalAmount money.Decimal, eventID, paidByUserId, createdByID uint) (*models.Expense, error) {
+	// Create an expense, in the currency of its event
+	if err := ec.ensureEventMember(eventID, paidByUserId); err != nil {
+		return nil, err
+	}
+
+	currency, err := ec.eventCurrency(eventID)
+	if err != nil {
+		return nil, err
+	}
+
+	total, err := totalAmount.Parse(currency)
+	if err != nil {
+		return nil, err
+	}
+
 	expense := models.Expense{
 		Name:        name,
-		TotalAmount: totalAmount,
+		TotalAmount: total,
 		EventID:     eventID,
 		PaidByID:    paidByUserId,
+		CreatedByID: createdByID,
 	}
 	if err := ec.db.Create(&expense).Error; err != nil {
 		return nil, err
@@ -29,10 +46,11 @@ func (ec *ExpenseService) CreateExpense(name string, totalAmount float64, eventI
 	return &expense, nil
 }
 
-func (ec *ExpenseService) GetExpenses() ([]models.Expense, error) {
-	// Get all expenses
+func (ec *ExpenseService) GetExpenses(personID uint) ([]models.Expense, error) {
+	// Get all expenses of the events the person is a member of
 	var expenses []models.Expense
-	if err := ec.db.Find(&expenses).Error; err != nil {
+	if err := ec.db.Joins("JOIN event_people ON event_people.event_id = expenses.event_id").
+		Where("event_people.person_id = ?", personID).Find(&expenses).Error; err != nil {
 		return nil, err
 	}
 	return expenses, nil
@@ -47,7 +65,7 @@ func (ec *ExpenseService) GetExpenseByID(id uint) (*models.Expense, error) {
 	return &expense, nil
 }
 
-func (ec *ExpenseService) UpdateExpense(id uint, name string, totalAmount float64, paidById uint) (*models.Expense, error) {
+func (ec *ExpenseService) UpdateExpense(id uint, name string, totalAmount money.Decimal, paidById uint) (*models.Expense, error) {
 	// Update an expense
 	var expense models.Expense
 
@@ -59,11 +77,18 @@ func (ec *ExpenseService) UpdateExpense(id uint, name string, totalAmount float6
 		expense.Name = name
 	}
 
-	if totalAmount != 0 {
-		expense.TotalAmount = totalAmount
+	if totalAmount != "" {
+		total, err := totalAmount.Parse(expense.TotalAmount.Currency)
+		if err != nil {
+			return nil, err
+		}
+		expense.TotalAmount = total
 	}
 
 	if paidById != 0 {
+		if err := ec.ensureEventMember(expense.EventID, paidById); err != nil {
+			return nil, err
+		}
 		expense.PaidByID = paidById
 	}
 
@@ -77,9 +102,20 @@ func (ec *ExpenseService) UpdateExpense(id uint, name string, totalAmount float6
 
 func (ec *ExpenseService) AddExpensePerson(expenseId, personId uint) (*models.ExpensePerson, error) {
 	// Add a person to an expense
+	expense, err := ec.GetExpenseByID(expenseId)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := ec.ensureEventMember(expense.EventID, personId); err != nil {
+		return nil, err
+	}
+
 	expensePerson := models.ExpensePerson{
-		ExpenseID: expenseId,
-		PersonID:  personId,
+		ExpenseID:  expenseId,
+		PersonID:   personId,
+		PaidAmount: money.Zero(expense.TotalAmount.Currency),
+		OwedAmount: money.Zero(expense.TotalAmount.Currency),
 	}
 	if err := ec.db.Create(&expensePerson).Error; err != nil {
 		return nil, err
@@ -96,19 +132,28 @@ func (ec *ExpenseService) GetExpensePeople(expenseId uint) ([]models.ExpensePers
 	return expensePeople, nil
 }
 
-func (ec *ExpenseService) UpdateExpensePerson(expenseId, personId uint, paidAmount float64, owedAmount float64) (*models.ExpensePerson, error) {
-	// Update an expense person
+func (ec *ExpenseService) UpdateExpensePerson(expenseId, personId uint, paidAmount, owedAmount money.Decimal) (*models.ExpensePerson, error) {
+	// Update an expense person, amounts left empty are unchanged
+	expense, err := ec.GetExpenseByID(expenseId)
+	if err != nil {
+		return nil, err
+	}
+
 	var expensePerson models.ExpensePerson
 	if err := ec.db.Where("expense_id = ? AND person_id = ?", expenseId, personId).First(&expensePerson).Error; err != nil {
 		return nil, err
 	}
 
-	if paidAmount != 0 {
-		expensePerson.PaidAmount = paidAmount
+	if paidAmount != "" {
+		if expensePerson.PaidAmount, err = paidAmount.Parse(expense.TotalAmount.Currency); err != nil {
+			return nil, err
+		}
 	}
 
-	if owedAmount != 0 {
-		expensePerson.OwedAmount = owedAmount
+	if owedAmount != "" {
+		if expensePerson.OwedAmount, err = owedAmount.Parse(expense.TotalAmount.Currency); err != nil {
+			return nil, err
+		}
 	}
 
 	if err := ec.db.Save(&expensePerson).Error; err != nil {
@@ -118,6 +163,44 @@ func (ec *ExpenseService) UpdateExpensePerson(expenseId, personId uint, paidAmou
 	return &expensePerson, nil
 }
 
+// SplitExpenseEvenly sets the owed amounts of the people of an expense to equal shares of its
+// total. Minor units that don't divide evenly go one each to the people added first, so the
+// shares always add up to the total.
+func (ec *ExpenseService) SplitExpenseEvenly(expenseId uint) ([]models.ExpensePerson, error) {
+	expense, err := ec.GetExpenseByID(expenseId)
+	if err != nil {
+		return nil, err
+	}
+
+	var expensePeople []models.ExpensePerson
+	err = ec.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("expense_id = ?", expenseId).Order("id").Find(&expensePeople).Error; err != nil {
+			return err
+		}
+		if len(expensePeople) == 0 {
+			return errors.New("expense has no people to split between")
+		}
+
+		shares, err := expense.TotalAmount.Split(len(expensePeople))
+		if err != nil {
+			return err
+		}
+
+		for i := range expensePeople {
+			expensePeople[i].OwedAmount = shares[i]
+			if err := tx.Save(&expensePeople[i]).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return expensePeople, nil
+}
+
 func (ec *ExpenseService) DeleteExpensePerson(expenseId, personId uint) error {
 	// Delete an expense person
 	var expensePerson models.ExpensePerson
@@ -157,14 +240,70 @@ func (ec *ExpenseService) CheckExpenseConsistency(expenseId uint) error {
 		return err
 	}
 
-	var totalOwedAmount float64
-	if err := ec.db.Model(&models.ExpensePerson{}).Where("expense_id = ?", expenseId).Select("sum(owed_amount)").Row().Scan(&totalOwedAmount); err != nil {
+	// Amounts are whole minor units, so the sum is exact
+	var totalOwedAmount int64
+	if err := ec.db.Model(&models.ExpensePerson{}).Where("expense_id = ?", expenseId).Select("COALESCE(SUM(owed_amount_minor), 0)").Row().Scan(&totalOwedAmount); err != nil {
 		return err
 	}
 
-	if totalOwedAmount != expense.TotalAmount {
+	if totalOwedAmount != expense.TotalAmount.Amount {
 		return errors.New("total owed amount does not match total amount")
 	}
 
 	return nil
 }
+
+// AuthorizeExpense checks that the person may perform an action on an expense, based on
+// their role in the expense's event. Editing needs PermissionEditAnyExpense, or
+// PermissionEditOwnExpense for expenses the person created or paid.
+func (ec *ExpenseService) AuthorizeExpense(expenseID, personID uint, edit bool) error {
+	expense, err := ec.GetExpenseByID(expenseID)
+	if err != nil {
+		return err
+	}
+
+	role, err := ec.eventService.GetRole(expense.EventID, personID)
+	if err != nil {
+		return err
+	}
+
+	if !edit {
+		if RoleHasPermission(role, PermissionViewEvent) {
+			return nil
+		}
+		return ErrForbidden
+	}
+
+	if RoleHasPermission(role, PermissionEditAnyExpense) {
+		return nil
+	}
+
+	ownExpense := expense.CreatedByID == personID || expense.PaidByID == personID
+	if ownExpense && RoleHasPermission(role, PermissionEditOwnExpense) {
+		return nil
+	}
+
+	return ErrForbidden
+}
+
+// eventCurrency returns the currency the amounts of an event are in.
+func (ec *ExpenseService) eventCurrency(eventID uint) (string, error) {
+	var event models.Event
+	if err := ec.db.Select("id", "currency").First(&event, eventID).Error; err != nil {
+		return "", err
+	}
+	return event.Currency, nil
+}
+
+// ensureEventMember fails if the person is not a member of the event.
+func (ec *ExpenseService) ensureEventMember(eventID, personID uint) error {
+	role, err := ec.eventService.GetRole(eventID, personID)
+	if err != nil {
+		return err
+	}
+
+	if role == "" {
+		return errors.New("person is not a member of the event")
+	}
+	return nil
+}
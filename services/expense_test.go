@@ -0,0 +1,60 @@
+package services
+
+import (
+	"testing"
+
+	"github.com/yasharya2901/smart_divide/money"
+)
+
+func TestSplitExpenseEvenly(t *testing.T) {
+	db := newTestDB(t)
+	ec := NewExpenseService(db)
+
+	alice, bob, carol := createPerson(t, db, "alice"), createPerson(t, db, "bob"), createPerson(t, db, "carol")
+	event, err := ec.eventService.CreateEvent("Trip", "USD", alice.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, person := range []uint{bob.ID, carol.ID} {
+		if err := ec.eventService.AddPersonToEvent(event.ID, person, RoleMember); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	expense, err := ec.CreateExpense("Dinner", money.Decimal("100.00"), event.ID, alice.ID, alice.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ec.SplitExpenseEvenly(expense.ID); err == nil {
+		t.Error("expense without people split")
+	}
+
+	for _, person := range []uint{alice.ID, bob.ID, carol.ID} {
+		if _, err := ec.AddExpensePerson(expense.ID, person); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	people, err := ec.SplitExpenseEvenly(expense.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, want := range []string{"33.34", "33.33", "33.33"} {
+		if owed := people[i].OwedAmount; owed.String() != want || owed.Currency != "USD" {
+			t.Errorf("person %d owes %s %s, want %s", people[i].PersonID, owed, owed.Currency, want)
+		}
+	}
+	if err := ec.CheckExpenseConsistency(expense.ID); err != nil {
+		t.Errorf("even split is inconsistent: %v", err)
+	}
+
+	stored, err := ec.GetExpensePeople(expense.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range stored {
+		if !stored[i].OwedAmount.Equal(people[i].OwedAmount) {
+			t.Errorf("stored %s, returned %s", stored[i].OwedAmount, people[i].OwedAmount)
+		}
+	}
+}
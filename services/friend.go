@@ -0,0 +1,226 @@
+package services
+
+import (
+	"errors"
+	"time"
+
+	"github.com/yasharya2901/smart_divide/models"
+	"gorm.io/gorm"
+)
+
+type FriendService struct {
+	db *gorm.DB
+}
+
+func NewFriendService(db *gorm.DB) *FriendService {
+	return &FriendService{db: db}
+}
+
+// Friend is a person on the friends list of another.
+type Friend struct {
+	ID    uint      `json:"id"`
+	Name  string    `json:"name"`
+	Since time.Time `json:"since"`
+}
+
+// FriendRequest is a pending request sent or received by a person.
+type FriendRequest struct {
+	ID        uint      `json:"id"`
+	PersonID  uint      `json:"person_id"` // The other person
+	Name      string    `json:"name"`
+	Incoming  bool      `json:"incoming"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// SendRequest sends a friend request from the person to another. If the other person
+// already sent one the other way, it is accepted instead.
+func (fs *FriendService) SendRequest(personID, friendID uint) (*models.Friendship, error) {
+	if personID == friendID {
+		return nil, errors.New("you can't add yourself as a friend")
+	}
+
+	var friend models.Person
+	if err := fs.db.Where("id = ? AND placeholder = ? AND anonymized_at IS NULL", friendID, false).First(&friend).Error; err != nil {
+		return nil, err
+	}
+
+	existing, err := fs.findFriendship(personID, friendID)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case existing == nil:
+	case existing.AcceptedAt != nil:
+		return nil, errors.New("you are already friends")
+	case existing.RequesterID == personID:
+		return nil, errors.New("friend request already sent")
+	default:
+		return fs.AcceptRequest(personID, existing.ID)
+	}
+
+	friendship := models.Friendship{RequesterID: personID, AddresseeID: friendID}
+	if err := fs.db.Create(&friendship).Error; err != nil {
+		return nil, err
+	}
+	return &friendship, nil
+}
+
+// AcceptRequest accepts a pending friend request sent to the person.
+func (fs *FriendService) AcceptRequest(personID, requestID uint) (*models.Friendship, error) {
+	now := time.Now()
+	result := fs.db.Model(&models.Friendship{}).
+		Where("id = ? AND addressee_id = ? AND accepted_at IS NULL", requestID, personID).
+		Update("accepted_at", now)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return nil, gorm.ErrRecordNotFound
+	}
+
+	var friendship models.Friendship
+	if err := fs.db.First(&friendship, requestID).Error; err != nil {
+		return nil, err
+	}
+	return &friendship, nil
+}
+
+// DeleteRequest declines a pending friend request sent to the person, or cancels one they sent.
+func (fs *FriendService) DeleteRequest(personID, requestID uint) error {
+	result := fs.db.Unscoped().
+		Where("id = ? AND (requester_id = ? OR addressee_id = ?) AND accepted_at IS NULL", requestID, personID, personID).
+		Delete(&models.Friendship{})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+// RemoveFriend ends the friendship between the person and a friend, for both of them.
+func (fs *FriendService) RemoveFriend(personID, friendID uint) error {
+	result := fs.db.Unscoped().
+		Where("((requester_id = ? AND addressee_id = ?) OR (requester_id = ? AND addressee_id = ?)) AND accepted_at IS NOT NULL",
+			personID, friendID, friendID, personID).
+		Delete(&models.Friendship{})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+// GetFriends returns the friends of the person, by name.
+func (fs *FriendService) GetFriends(personID uint) ([]Friend, error) {
+	var friendships []models.Friendship
+	if err := fs.db.Where("(requester_id = ? OR addressee_id = ?) AND accepted_at IS NOT NULL", personID, personID).
+		Find(&friendships).Error; err != nil {
+		return nil, err
+	}
+
+	since := make(map[uint]time.Time, len(friendships))
+	friendIDs := make([]uint, len(friendships))
+	for i, friendship := range friendships {
+		friendIDs[i] = otherPerson(friendship, personID)
+		since[friendIDs[i]] = *friendship.AcceptedAt
+	}
+
+	var people []models.Person
+	if err := fs.db.Where("id IN ?", friendIDs).Order("name").Find(&people).Error; err != nil {
+		return nil, err
+	}
+
+	friends := make([]Friend, len(people))
+	for i, person := range people {
+		friends[i] = Friend{ID: person.ID, Name: person.Name, Since: since[person.ID]}
+	}
+	return friends, nil
+}
+
+// GetRequests returns the pending friend requests sent and received by the person, newest first.
+func (fs *FriendService) GetRequests(personID uint) ([]FriendRequest, error) {
+	var friendships []models.Friendship
+	if err := fs.db.Where("(requester_id = ? OR addressee_id = ?) AND accepted_at IS NULL", personID, personID).
+		Order("id desc").Find(&friendships).Error; err != nil {
+		return nil, err
+	}
+
+	otherIDs := make([]uint, len(friendships))
+	for i, friendship := range friendships {
+		otherIDs[i] = otherPerson(friendship, personID)
+	}
+
+	var people []models.Person
+	if err := fs.db.Where("id IN ?", otherIDs).Find(&people).Error; err != nil {
+		return nil, err
+	}
+	names := make(map[uint]string, len(people))
+	for _, person := range people {
+		names[person.ID] = person.Name
+	}
+
+	requests := make([]FriendRequest, len(friendships))
+	for i, friendship := range friendships {
+		requests[i] = FriendRequest{
+			ID:        friendship.ID,
+			PersonID:  otherIDs[i],
+			Name:      names[otherIDs[i]],
+			Incoming:  friendship.AddresseeID == personID,
+			CreatedAt: friendship.CreatedAt,
+		}
+	}
+	return requests, nil
+}
+
+// SuggestMembers returns the friends of the person who are not in the event yet, to be
+// offered when adding members.
+func (fs *FriendService) SuggestMembers(eventID, personID uint) ([]Friend, error) {
+	friends, err := fs.GetFriends(personID)
+	if err != nil {
+		return nil, err
+	}
+
+	var memberIDs []uint
+	if err := fs.db.Model(&models.EventPerson{}).Where("event_id = ?", eventID).
+		Pluck("person_id", &memberIDs).Error; err != nil {
+		return nil, err
+	}
+	members := make(map[uint]bool, len(memberIDs))
+	for _, id := range memberIDs {
+		members[id] = true
+	}
+
+	suggestions := []Friend{}
+	for _, friend := range friends {
+		if !members[friend.ID] {
+			suggestions = append(suggestions, friend)
+		}
+	}
+	return suggestions, nil
+}
+
+// findFriendship returns the friendship or pending request between two people in either
+// direction, or nil if there is none.
+func (fs *FriendService) findFriendship(personID, otherID uint) (*models.Friendship, error) {
+	var friendships []models.Friendship
+	if err := fs.db.Where("(requester_id = ? AND addressee_id = ?) OR (requester_id = ? AND addressee_id = ?)",
+		personID, otherID, otherID, personID).Limit(1).Find(&friendships).Error; err != nil {
+		return nil, err
+	}
+	if len(friendships) == 0 {
+		return nil, nil
+	}
+	return &friendships[0], nil
+}
+
+// otherPerson returns the person on the other side of a friendship.
+func otherPerson(friendship models.Friendship, personID uint) uint {
+	if friendship.RequesterID == personID {
+		return friendship.AddresseeID
+	}
+	return friendship.RequesterID
+}
@@ -0,0 +1,237 @@
+package services
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/yasharya2901/smart_divide/config"
+	"github.com/yasharya2901/smart_divide/models"
+	"github.com/yasharya2901/smart_divide/utils"
+	"gorm.io/gorm"
+)
+
+const invitationInvalidMessage = "invalid or expired invitation"
+
+// invitationKeyLabel separates the invitation signing key derived from the refresh token
+// secret from any other use of that secret.
+const invitationKeyLabel = "smart_divide event invitations"
+
+type InvitationService struct {
+	db           *gorm.DB
+	cfg          *config.Config
+	eventService *EventService
+}
+
+func NewInvitationService(db *gorm.DB, cfg *config.Config) *InvitationService {
+	return &InvitationService{db: db, cfg: cfg, eventService: NewEventService(db)}
+}
+
+// Invitation is an invitation along with its link.
+type Invitation struct {
+	models.EventInvitation
+	Token string
+	Link  string
+}
+
+// CreateInvitation creates an invitation to the event giving role to people who join with
+// it. A maxUses of 0 means no limit, and a nil expiresAt means it doesn't expire. The actor
+// may only invite people with a role they could give them directly.
+func (is *InvitationService) CreateInvitation(eventID, actorID uint, role string, maxUses int, expiresAt *time.Time) (*Invitation, error) {
+	if !IsValidRole(role) {
+		return nil, errors.New("invalid role")
+	}
+	if maxUses < 0 {
+		return nil, errors.New("max uses can't be negative")
+	}
+	if expiresAt != nil && !expiresAt.After(time.Now()) {
+		return nil, errors.New("expiry date must be in the future")
+	}
+
+	if err := is.eventService.AuthorizeRoleChange(eventID, actorID, 0, role); err != nil {
+		return nil, err
+	}
+
+	nonce, err := utils.GenerateRandomToken(32)
+	if err != nil {
+		return nil, err
+	}
+
+	invitation := models.EventInvitation{
+		EventID:     eventID,
+		CreatedByID: actorID,
+		Nonce:       nonce,
+		Role:        role,
+		MaxUses:     maxUses,
+		ExpiresAt:   expiresAt,
+	}
+	if err := is.db.Create(&invitation).Error; err != nil {
+		return nil, err
+	}
+
+	return is.withLink(invitation), nil
+}
+
+// GetInvitations returns the invitations of an event that can still be used, newest first.
+// Only those giving a role the actor could give directly are returned, as anyone holding
+// the link of another can join with its role.
+func (is *InvitationService) GetInvitations(eventID, actorID uint) ([]Invitation, error) {
+	if err := is.eventService.Authorize(eventID, actorID, PermissionManageMembers); err != nil {
+		return nil, err
+	}
+
+	var invitations []models.EventInvitation
+	if err := is.active(is.db).Where("event_id = ?", eventID).
+		Order("id desc").Find(&invitations).Error; err != nil {
+		return nil, err
+	}
+
+	res := []Invitation{}
+	for _, invitation := range invitations {
+		if err := is.eventService.AuthorizeRoleChange(eventID, actorID, 0, invitation.Role); err != nil {
+			if errors.Is(err, ErrForbidden) {
+				continue
+			}
+			return nil, err
+		}
+		res = append(res, *is.withLink(invitation))
+	}
+	return res, nil
+}
+
+// GetInvitation returns an invitation of an event that can still be used, if it gives a
+// role the actor could give directly.
+func (is *InvitationService) GetInvitation(eventID, invitationID, actorID uint) (*Invitation, error) {
+	if err := is.eventService.Authorize(eventID, actorID, PermissionManageMembers); err != nil {
+		return nil, err
+	}
+
+	var invitation models.EventInvitation
+	if err := is.active(is.db).Where("event_id = ?", eventID).First(&invitation, invitationID).Error; err != nil {
+		return nil, err
+	}
+
+	if err := is.eventService.AuthorizeRoleChange(eventID, actorID, 0, invitation.Role); err != nil {
+		return nil, err
+	}
+
+	return is.withLink(invitation), nil
+}
+
+// RevokeInvitation stops an invitation of an event from being used. People who already
+// joined with it stay in the event. The actor may only revoke invitations giving a role
+// they could give directly.
+func (is *InvitationService) RevokeInvitation(eventID, invitationID, actorID uint) error {
+	if err := is.eventService.Authorize(eventID, actorID, PermissionManageMembers); err != nil {
+		return err
+	}
+
+	var invitation models.EventInvitation
+	if err := is.db.Where("event_id = ? AND revoked_at IS NULL", eventID).First(&invitation, invitationID).Error; err != nil {
+		return err
+	}
+
+	if err := is.eventService.AuthorizeRoleChange(eventID, actorID, 0, invitation.Role); err != nil {
+		return err
+	}
+
+	result := is.db.Model(&models.EventInvitation{}).
+		Where("id = ? AND revoked_at IS NULL", invitation.ID).
+		Update("revoked_at", time.Now())
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+// RedeemInvitation adds the person to the event of the invitation with its role and
+// returns the event. Using an invitation to an event the person is already in is an error
+// and doesn't count as a use.
+func (is *InvitationService) RedeemInvitation(token string, personID uint) (*models.Event, error) {
+	invitation, err := is.verify(token)
+	if err != nil {
+		return nil, err
+	}
+
+	role, err := is.eventService.GetRole(invitation.EventID, personID)
+	if err != nil {
+		return nil, err
+	}
+	if role != "" {
+		return nil, errors.New("you are already a member of the event")
+	}
+
+	err = is.db.Transaction(func(tx *gorm.DB) error {
+		// Counting the use only if the invitation is still usable keeps concurrent
+		// redemptions within the limit
+		result := is.active(tx.Model(&models.EventInvitation{})).
+			Where("id = ?", invitation.ID).
+			Update("uses", gorm.Expr("uses + 1"))
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return errors.New(invitationInvalidMessage)
+		}
+
+		return NewEventService(tx).AddPersonToEvent(invitation.EventID, personID, invitation.Role)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return is.eventService.GetEventByID(invitation.EventID, false)
+}
+
+// verify returns the invitation of a token if its signature is valid and it can still be used.
+func (is *InvitationService) verify(token string) (*models.EventInvitation, error) {
+	invalid := errors.New(invitationInvalidMessage)
+
+	idPart, _, found := strings.Cut(token, ".")
+	if !found {
+		return nil, invalid
+	}
+	id, err := strconv.ParseUint(idPart, 10, 64)
+	if err != nil {
+		return nil, invalid
+	}
+
+	var invitation models.EventInvitation
+	if err := is.active(is.db).Where("id = ?", id).Limit(1).Find(&invitation).Error; err != nil {
+		return nil, err
+	}
+	if invitation.ID == 0 || !hmac.Equal([]byte(token), []byte(is.sign(invitation))) {
+		return nil, invalid
+	}
+
+	return &invitation, nil
+}
+
+// active narrows a query to invitations that are not revoked, expired or used up.
+func (is *InvitationService) active(db *gorm.DB) *gorm.DB {
+	return db.Where("revoked_at IS NULL AND (expires_at IS NULL OR expires_at > ?) AND (max_uses = 0 OR uses < max_uses)", time.Now())
+}
+
+// sign returns the token of an invitation, its ID followed by a signature of the ID and nonce.
+func (is *InvitationService) sign(invitation models.EventInvitation) string {
+	key := hmac.New(sha256.New, []byte(is.cfg.JWT.RefreshSecret))
+	key.Write([]byte(invitationKeyLabel))
+
+	mac := hmac.New(sha256.New, key.Sum(nil))
+	id := strconv.FormatUint(uint64(invitation.ID), 10)
+	mac.Write([]byte(id + "." + invitation.Nonce))
+
+	return id + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func (is *InvitationService) withLink(invitation models.EventInvitation) *Invitation {
+	token := is.sign(invitation)
+	return &Invitation{EventInvitation: invitation, Token: token, Link: tokenLink(is.cfg.Links.Invitation, token)}
+}
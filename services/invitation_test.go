@@ -0,0 +1,243 @@
+package services
+
+import (
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/yasharya2901/smart_divide/models"
+)
+
+func newTestInvitationService(t *testing.T) (*InvitationService, *models.Event, *models.Person) {
+	t.Helper()
+
+	db := newTestDB(t)
+	is := NewInvitationService(db, testConfig())
+	owner := createPerson(t, db, "owner")
+	event, err := is.eventService.CreateEvent("Trip", "USD", owner.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return is, event, owner
+}
+
+// tamper changes the first character of s.
+func tamper(s string) string {
+	if s[0] == 'A' {
+		return "B" + s[1:]
+	}
+	return "A" + s[1:]
+}
+
+func TestInvitationTokens(t *testing.T) {
+	is, event, owner := newTestInvitationService(t)
+
+	invitation, err := is.CreateInvitation(event.ID, owner.ID, RoleMember, 0, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if verified, err := is.verify(invitation.Token); err != nil || verified.ID != invitation.ID {
+		t.Fatalf("own token rejected: %v", err)
+	}
+
+	other, err := is.CreateInvitation(event.ID, owner.ID, RoleMember, 0, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, signature, _ := strings.Cut(invitation.Token, ".")
+	_, otherSignature, _ := strings.Cut(other.Token, ".")
+
+	forged := []struct {
+		name  string
+		token string
+	}{
+		{"empty", ""},
+		{"id only", id},
+		{"no signature", id + "."},
+		{"signature of another invitation", id + "." + otherSignature},
+		{"tampered signature", id + "." + tamper(signature)},
+		{"other id", other.Token[:strings.Index(other.Token, ".")] + "." + signature},
+		{"unknown id", "999." + signature},
+		{"not a number", "x." + signature},
+	}
+	for _, tt := range forged {
+		if _, err := is.verify(tt.token); err == nil {
+			t.Errorf("%s: token %q accepted", tt.name, tt.token)
+		}
+	}
+
+	// A token signed with another secret is rejected
+	otherSecret := NewInvitationService(is.db, testConfig())
+	otherSecret.cfg.JWT.RefreshSecret = "another-refresh-secret-another-refresh"
+	if _, err := is.verify(otherSecret.sign(invitation.EventInvitation)); err == nil {
+		t.Error("token signed with another secret accepted")
+	}
+}
+
+func TestInvitationRedeem(t *testing.T) {
+	is, event, owner := newTestInvitationService(t)
+	guest := createPerson(t, is.db, "guest")
+
+	invitation, err := is.CreateInvitation(event.ID, owner.ID, RoleViewer, 0, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	joined, err := is.RedeemInvitation(invitation.Token, guest.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if joined.ID != event.ID {
+		t.Errorf("joined event %d, want %d", joined.ID, event.ID)
+	}
+	if role, err := is.eventService.GetRole(event.ID, guest.ID); err != nil || role != RoleViewer {
+		t.Errorf("joined as %q, %v", role, err)
+	}
+
+	if _, err := is.RedeemInvitation(invitation.Token, guest.ID); err == nil {
+		t.Error("joined the same event twice")
+	}
+}
+
+func TestInvitationLimits(t *testing.T) {
+	is, event, owner := newTestInvitationService(t)
+
+	expiresAt := time.Now().Add(time.Hour)
+	limited, err := is.CreateInvitation(event.ID, owner.ID, RoleMember, 1, &expiresAt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := is.RedeemInvitation(limited.Token, createPerson(t, is.db, "first").ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := is.RedeemInvitation(limited.Token, createPerson(t, is.db, "second").ID); err == nil {
+		t.Error("invitation used more often than allowed")
+	}
+
+	revoked, err := is.CreateInvitation(event.ID, owner.ID, RoleMember, 0, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := is.RevokeInvitation(event.ID, revoked.ID, owner.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := is.RedeemInvitation(revoked.Token, createPerson(t, is.db, "third").ID); err == nil {
+		t.Error("revoked invitation used")
+	}
+
+	expired, err := is.CreateInvitation(event.ID, owner.ID, RoleMember, 0, &expiresAt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := is.db.Model(&models.EventInvitation{}).Where("id = ?", expired.ID).Update("expires_at", time.Now().Add(-time.Second)).Error; err != nil {
+		t.Fatal(err)
+	}
+	if _, err := is.RedeemInvitation(expired.Token, createPerson(t, is.db, "fourth").ID); err == nil {
+		t.Error("expired invitation used")
+	}
+
+	past := time.Now().Add(-time.Hour)
+	if _, err := is.CreateInvitation(event.ID, owner.ID, RoleMember, 0, &past); err == nil {
+		t.Error("invitation created already expired")
+	}
+}
+
+func TestInvitationConcurrentRedemptions(t *testing.T) {
+	is, event, owner := newTestInvitationService(t)
+
+	const maxUses = 3
+	invitation, err := is.CreateInvitation(event.ID, owner.ID, RoleMember, maxUses, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var guests []uint
+	for i := 0; i < 4*maxUses; i++ {
+		guests = append(guests, createPerson(t, is.db, "guest"+string(rune('a'+i))).ID)
+	}
+
+	var wg sync.WaitGroup
+	for _, guest := range guests {
+		wg.Add(1)
+		go func(guest uint) {
+			defer wg.Done()
+			is.RedeemInvitation(invitation.Token, guest)
+		}(guest)
+	}
+	wg.Wait()
+
+	var joined int64
+	is.db.Model(&models.EventPerson{}).Where("event_id = ? AND person_id <> ?", event.ID, owner.ID).Count(&joined)
+	if joined == 0 || joined > maxUses {
+		t.Errorf("%d people joined with an invitation for %d", joined, maxUses)
+	}
+}
+
+func TestInvitationRoles(t *testing.T) {
+	is, event, owner := newTestInvitationService(t)
+	member := createPerson(t, is.db, "member")
+	if err := is.eventService.AddPersonToEvent(event.ID, member.ID, RoleMember); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := is.CreateInvitation(event.ID, owner.ID, "superuser", 0, nil); err == nil {
+		t.Error("invitation with an unknown role created")
+	}
+	if _, err := is.CreateInvitation(event.ID, member.ID, RoleAdmin, 0, nil); err == nil {
+		t.Error("member invited an administrator")
+	}
+	if _, err := is.CreateInvitation(event.ID, owner.ID, RoleMember, -1, nil); err == nil {
+		t.Error("invitation with negative uses created")
+	}
+}
+
+func TestInvitationsHiddenFromLowerRoles(t *testing.T) {
+	is, event, owner := newTestInvitationService(t)
+	admin := createPerson(t, is.db, "admin")
+	if err := is.eventService.AddPersonToEvent(event.ID, admin.ID, RoleAdmin); err != nil {
+		t.Fatal(err)
+	}
+
+	ownerInvitation, err := is.CreateInvitation(event.ID, owner.ID, RoleOwner, 0, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	memberInvitation, err := is.CreateInvitation(event.ID, owner.ID, RoleMember, 0, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	invitations, err := is.GetInvitations(event.ID, admin.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(invitations) != 1 || invitations[0].ID != memberInvitation.ID {
+		t.Errorf("admin listed %v, want only the member invitation", invitations)
+	}
+	if invitations, err := is.GetInvitations(event.ID, owner.ID); err != nil || len(invitations) != 2 {
+		t.Errorf("owner listed %d invitations, %v", len(invitations), err)
+	}
+
+	if _, err := is.GetInvitation(event.ID, ownerInvitation.ID, admin.ID); !errors.Is(err, ErrForbidden) {
+		t.Errorf("admin got the owner invitation: %v", err)
+	}
+	if err := is.RevokeInvitation(event.ID, ownerInvitation.ID, admin.ID); !errors.Is(err, ErrForbidden) {
+		t.Errorf("admin revoked the owner invitation: %v", err)
+	}
+	if _, err := is.GetInvitation(event.ID, memberInvitation.ID, admin.ID); err != nil {
+		t.Errorf("admin could not get the member invitation: %v", err)
+	}
+	if err := is.RevokeInvitation(event.ID, memberInvitation.ID, admin.ID); err != nil {
+		t.Errorf("admin could not revoke the member invitation: %v", err)
+	}
+
+	member := createPerson(t, is.db, "member")
+	if err := is.eventService.AddPersonToEvent(event.ID, member.ID, RoleMember); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := is.GetInvitations(event.ID, member.ID); !errors.Is(err, ErrForbidden) {
+		t.Errorf("member listed invitations: %v", err)
+	}
+}
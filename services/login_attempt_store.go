@@ -0,0 +1,166 @@
+package services
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/yasharya2901/smart_divide/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// AttemptRecord counts the failed logins for an account or an IP address.
+type AttemptRecord struct {
+	Failures      int
+	LastFailureAt time.Time
+	LockedUntil   time.Time
+}
+
+// LoginAttemptStore persists failed login counters. Increment and Lock are atomic, so
+// concurrent failed logins are all counted.
+type LoginAttemptStore interface {
+	// Get returns the record for a key, or nil if there is none.
+	Get(key string) (*AttemptRecord, error)
+	// Increment counts a failure for a key and returns the updated record. The count starts
+	// over when the previous failure is older than window.
+	Increment(key string, window time.Duration) (*AttemptRecord, error)
+	// Lock refuses logins for a key until a time, unless it is already locked for longer.
+	Lock(key string, until time.Time) error
+	Delete(key string) error
+}
+
+// NewLoginAttemptStore creates the named store. Supported stores are "db", which is shared
+// by every server instance, and "memory".
+func NewLoginAttemptStore(db *gorm.DB, store string) (LoginAttemptStore, error) {
+	switch store {
+	case "db":
+		return NewDBLoginAttemptStore(db), nil
+	case "memory":
+		return NewMemoryLoginAttemptStore(), nil
+	default:
+		return nil, fmt.Errorf("unknown login attempt store %q", store)
+	}
+}
+
+// MemoryLoginAttemptStore keeps counters in memory, for single instance deployments and tests.
+type MemoryLoginAttemptStore struct {
+	mu      sync.Mutex
+	records map[string]AttemptRecord
+}
+
+func NewMemoryLoginAttemptStore() *MemoryLoginAttemptStore {
+	return &MemoryLoginAttemptStore{records: make(map[string]AttemptRecord)}
+}
+
+func (s *MemoryLoginAttemptStore) Get(key string) (*AttemptRecord, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	record, ok := s.records[key]
+	if !ok {
+		return nil, nil
+	}
+	return &record, nil
+}
+
+func (s *MemoryLoginAttemptStore) Increment(key string, window time.Duration) (*AttemptRecord, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	record := s.records[key]
+	if now.Sub(record.LastFailureAt) > window {
+		record.Failures = 0
+	}
+	record.Failures++
+	record.LastFailureAt = now
+	s.records[key] = record
+	return &record, nil
+}
+
+func (s *MemoryLoginAttemptStore) Lock(key string, until time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	record := s.records[key]
+	if until.After(record.LockedUntil) {
+		record.LockedUntil = until
+		s.records[key] = record
+	}
+	return nil
+}
+
+func (s *MemoryLoginAttemptStore) Delete(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.records, key)
+	return nil
+}
+
+// DBLoginAttemptStore keeps counters in the login_attempts table.
+type DBLoginAttemptStore struct {
+	db *gorm.DB
+}
+
+func NewDBLoginAttemptStore(db *gorm.DB) *DBLoginAttemptStore {
+	return &DBLoginAttemptStore{db: db}
+}
+
+func (s *DBLoginAttemptStore) Get(key string) (*AttemptRecord, error) {
+	var attempts []models.LoginAttempt
+	if err := s.db.Where("`key` = ?", key).Limit(1).Find(&attempts).Error; err != nil {
+		return nil, err
+	}
+	if len(attempts) == 0 {
+		return nil, nil
+	}
+
+	attempt := attempts[0]
+	return &AttemptRecord{
+		Failures:      attempt.Failures,
+		LastFailureAt: attempt.LastFailureAt,
+		LockedUntil:   attempt.LockedUntil,
+	}, nil
+}
+
+// Increment upserts the counter in a single statement, and reads it back in the same
+// transaction so the caller sees the count its own failure produced.
+func (s *DBLoginAttemptStore) Increment(key string, window time.Duration) (*AttemptRecord, error) {
+	now := time.Now()
+	var record *AttemptRecord
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.OnConflict{
+			Columns: []clause.Column{{Name: "key"}},
+			// MySQL applies the assignments in order, so failures must come before the
+			// last_failure_at it is computed from
+			DoUpdates: clause.Set{
+				{Column: clause.Column{Name: "failures"}, Value: gorm.Expr("CASE WHEN last_failure_at < ? THEN 1 ELSE failures + 1 END", now.Add(-window))},
+				{Column: clause.Column{Name: "last_failure_at"}, Value: now},
+				{Column: clause.Column{Name: "updated_at"}, Value: now},
+				{Column: clause.Column{Name: "deleted_at"}, Value: nil},
+			},
+		}).Create(&models.LoginAttempt{Key: key, Failures: 1, LastFailureAt: now}).Error; err != nil {
+			return err
+		}
+
+		var err error
+		record, err = NewDBLoginAttemptStore(tx).Get(key)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return record, nil
+}
+
+func (s *DBLoginAttemptStore) Lock(key string, until time.Time) error {
+	return s.db.Model(&models.LoginAttempt{}).
+		Where("`key` = ? AND locked_until < ?", key, until).
+		Update("locked_until", until).Error
+}
+
+func (s *DBLoginAttemptStore) Delete(key string) error {
+	return s.db.Unscoped().Where("`key` = ?", key).Delete(&models.LoginAttempt{}).Error
+}
@@ -0,0 +1,213 @@
+package services
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/yasharya2901/smart_divide/models"
+	"github.com/yasharya2901/smart_divide/utils"
+	"gorm.io/gorm"
+)
+
+const (
+	accountLockoutThreshold = 5
+	ipLockoutThreshold      = 20
+	baseLockoutDuration     = time.Minute
+	maxLockoutDuration      = 24 * time.Hour
+	failureResetWindow      = 24 * time.Hour // Failures are forgotten after this long without a new one
+	accountUnlockExpiry     = time.Hour
+)
+
+// LockedError is returned while logins for an account or IP address are refused.
+type LockedError struct {
+	Until time.Time
+}
+
+func (e *LockedError) Error() string {
+	return "too many failed login attempts, please try again later"
+}
+
+// LoginThrottle counts failed logins per key and locks a key out once it reaches a
+// threshold. Every further failure doubles the lockout, up to maxLockoutDuration.
+type LoginThrottle struct {
+	store LoginAttemptStore
+}
+
+func NewLoginThrottle(store LoginAttemptStore) *LoginThrottle {
+	return &LoginThrottle{store: store}
+}
+
+// Check fails with a LockedError if any of the keys is locked.
+func (lt *LoginThrottle) Check(keys ...string) error {
+	for _, key := range keys {
+		record, err := lt.store.Get(key)
+		if err != nil {
+			return err
+		}
+		if record != nil && record.LockedUntil.After(time.Now()) {
+			return &LockedError{Until: record.LockedUntil}
+		}
+	}
+	return nil
+}
+
+// RecordFailure counts a failed login for the key. It returns the updated record and
+// whether this failure locked the key.
+func (lt *LoginThrottle) RecordFailure(key string, threshold int) (*AttemptRecord, bool, error) {
+	record, err := lt.store.Increment(key, failureResetWindow)
+	if err != nil {
+		return nil, false, err
+	}
+	if record.Failures < threshold {
+		return record, false, nil
+	}
+
+	until := record.LastFailureAt.Add(lockoutDuration(record.Failures - threshold))
+	if err := lt.store.Lock(key, until); err != nil {
+		return nil, false, err
+	}
+	if until.After(record.LockedUntil) {
+		record.LockedUntil = until
+	}
+	return record, true, nil
+}
+
+// Reset forgets the failures of a key.
+func (lt *LoginThrottle) Reset(key string) error {
+	return lt.store.Delete(key)
+}
+
+func lockoutDuration(extraFailures int) time.Duration {
+	if extraFailures > 20 {
+		return maxLockoutDuration
+	}
+
+	duration := baseLockoutDuration << extraFailures
+	if duration > maxLockoutDuration {
+		return maxLockoutDuration
+	}
+	return duration
+}
+
+func accountAttemptKey(email string) string {
+	return "account:" + strings.ToLower(strings.TrimSpace(email))
+}
+
+func ipAttemptKey(ip string) string {
+	return "ip:" + ip
+}
+
+var (
+	dummyPasswordHash     string
+	dummyPasswordHashOnce sync.Once
+)
+
+// compareDummyPassword spends the same time as a real password check, so failed logins
+// for unknown emails can't be told apart by their response time.
+func (as *AuthService) compareDummyPassword(password string) {
+	dummyPasswordHashOnce.Do(func() {
+		dummyPasswordHash, _ = as.passwords.Hash("smart-divide-dummy-password")
+	})
+	as.passwords.Verify(dummyPasswordHash, password)
+}
+
+// checkLoginAllowed fails if the account or the IP address is locked out.
+func (as *AuthService) checkLoginAllowed(email string, device DeviceInfo) error {
+	return as.loginThrottle.Check(accountAttemptKey(email), ipAttemptKey(device.IPAddress))
+}
+
+// recordLoginFailure counts a failed login for the account and the IP address. When the
+// account gets locked, the lockout is recorded and the owner is emailed an unlock link.
+func (as *AuthService) recordLoginFailure(person *models.Person, email string, device DeviceInfo) error {
+	if _, _, err := as.loginThrottle.RecordFailure(ipAttemptKey(device.IPAddress), ipLockoutThreshold); err != nil {
+		return err
+	}
+
+	record, locked, err := as.loginThrottle.RecordFailure(accountAttemptKey(email), accountLockoutThreshold)
+	if err != nil || !locked || person == nil {
+		return err
+	}
+
+	if err := as.db.Create(&models.LockoutEvent{
+		PersonID:    person.ID,
+		IPAddress:   device.IPAddress,
+		UserAgent:   device.UserAgent,
+		Failures:    record.Failures,
+		LockedUntil: record.LockedUntil,
+	}).Error; err != nil {
+		return err
+	}
+	as.audit(AuditAccountLocked, person.ID, email, device, nil)
+
+	// Only the first lockout of a series sends an email, to avoid flooding the owner
+	if record.Failures == accountLockoutThreshold {
+		return as.sendUnlockEmail(person)
+	}
+	return nil
+}
+
+func (as *AuthService) sendUnlockEmail(person *models.Person) error {
+	token, err := utils.GenerateRandomToken(32)
+	if err != nil {
+		return err
+	}
+
+	if err := as.db.Create(&models.AccountUnlockToken{
+		PersonID:  person.ID,
+		TokenHash: utils.HashToken(token),
+		ExpiresAt: time.Now().Add(accountUnlockExpiry),
+	}).Error; err != nil {
+		return err
+	}
+
+	body := fmt.Sprintf("Hi %s,\n\nYour Smart Divide account was temporarily locked after several failed login attempts. If this was you, use the link below to unlock it. If it wasn't, consider changing your password.\n\n%s",
+		person.Name, tokenLink(as.cfg.Links.AccountUnlock, token))
+
+	return as.mailer.Send(person.Email, "Your account was locked", body)
+}
+
+// UnlockAccount clears the lockout of an account using an emailed unlock token.
+func (as *AuthService) UnlockAccount(token string, device DeviceInfo) error {
+	var unlockToken models.AccountUnlockToken
+	if err := as.db.Where("token_hash = ? AND used_at IS NULL AND expires_at > ?", utils.HashToken(token), time.Now()).
+		First(&unlockToken).Error; err != nil {
+		return fmt.Errorf("invalid or expired unlock token")
+	}
+
+	person, err := as.peopleService.GetPersonByID(unlockToken.PersonID)
+	if err != nil {
+		return err
+	}
+
+	err = as.db.Transaction(func(tx *gorm.DB) error {
+		now := time.Now()
+		if err := tx.Model(&unlockToken).Update("used_at", now).Error; err != nil {
+			return err
+		}
+
+		return tx.Model(&models.LockoutEvent{}).
+			Where("person_id = ? AND unlocked_at IS NULL AND locked_until > ?", person.ID, now).
+			Update("unlocked_at", now).Error
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := as.loginThrottle.Reset(accountAttemptKey(person.Email)); err != nil {
+		return err
+	}
+
+	as.audit(AuditAccountUnlocked, person.ID, person.Email, device, nil)
+	return nil
+}
+
+// GetLockoutEvents returns the lockouts of an account, newest first.
+func (as *AuthService) GetLockoutEvents(personID uint) ([]models.LockoutEvent, error) {
+	var events []models.LockoutEvent
+	if err := as.db.Where("person_id = ?", personID).Order("created_at desc").Find(&events).Error; err != nil {
+		return nil, err
+	}
+	return events, nil
+}
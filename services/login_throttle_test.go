@@ -0,0 +1,143 @@
+package services
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/yasharya2901/smart_divide/models"
+)
+
+// attemptStores returns each login attempt store, fresh for the test.
+func attemptStores(t *testing.T) map[string]LoginAttemptStore {
+	return map[string]LoginAttemptStore{
+		"memory": NewMemoryLoginAttemptStore(),
+		"db":     NewDBLoginAttemptStore(newTestDB(t)),
+	}
+}
+
+func TestLoginThrottleLocksAtThreshold(t *testing.T) {
+	for name, store := range attemptStores(t) {
+		t.Run(name, func(t *testing.T) {
+			throttle := NewLoginThrottle(store)
+			key := accountAttemptKey("Someone@Example.com ")
+
+			for i := 1; i < accountLockoutThreshold; i++ {
+				record, locked, err := throttle.RecordFailure(key, accountLockoutThreshold)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if locked || record.Failures != i {
+					t.Fatalf("failure %d: locked %v with %d failures", i, locked, record.Failures)
+				}
+			}
+			if err := throttle.Check(accountAttemptKey("someone@example.com")); err != nil {
+				t.Fatalf("locked before the threshold: %v", err)
+			}
+
+			record, locked, err := throttle.RecordFailure(key, accountLockoutThreshold)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !locked {
+				t.Fatal("not locked at the threshold")
+			}
+			if d := time.Until(record.LockedUntil); d <= 0 || d > baseLockoutDuration {
+				t.Errorf("locked for %s, want %s", d, baseLockoutDuration)
+			}
+
+			var lockedErr *LockedError
+			if err := throttle.Check(ipAttemptKey("203.0.113.1"), key); !errors.As(err, &lockedErr) {
+				t.Fatalf("Check returned %v, want a LockedError", err)
+			}
+
+			// Every further failure doubles the lockout
+			record, _, err = throttle.RecordFailure(key, accountLockoutThreshold)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if d := time.Until(record.LockedUntil); d <= baseLockoutDuration || d > 2*baseLockoutDuration {
+				t.Errorf("locked for %s after another failure, want %s", d, 2*baseLockoutDuration)
+			}
+
+			if err := throttle.Reset(key); err != nil {
+				t.Fatal(err)
+			}
+			if err := throttle.Check(key); err != nil {
+				t.Errorf("still locked after a reset: %v", err)
+			}
+		})
+	}
+}
+
+func TestLoginThrottleCountsConcurrentFailures(t *testing.T) {
+	for name, store := range attemptStores(t) {
+		t.Run(name, func(t *testing.T) {
+			throttle := NewLoginThrottle(store)
+			key := ipAttemptKey("203.0.113.1")
+
+			const failures = 3 * ipLockoutThreshold
+			var wg sync.WaitGroup
+			for i := 0; i < failures; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					if _, _, err := throttle.RecordFailure(key, ipLockoutThreshold); err != nil {
+						t.Error(err)
+					}
+				}()
+			}
+			wg.Wait()
+
+			record, err := store.Get(key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if record.Failures != failures {
+				t.Errorf("%d failures counted, want %d", record.Failures, failures)
+			}
+			if err := throttle.Check(key); err == nil {
+				t.Error("not locked after concurrent failures")
+			}
+		})
+	}
+}
+
+func TestLoginThrottleForgetsOldFailures(t *testing.T) {
+	db := newTestDB(t)
+	throttle := NewLoginThrottle(NewDBLoginAttemptStore(db))
+	key := accountAttemptKey("someone@example.com")
+
+	for i := 0; i < accountLockoutThreshold-1; i++ {
+		if _, _, err := throttle.RecordFailure(key, accountLockoutThreshold); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := db.Model(&models.LoginAttempt{}).Where("`key` = ?", key).
+		Update("last_failure_at", time.Now().Add(-failureResetWindow-time.Minute)).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	record, locked, err := throttle.RecordFailure(key, accountLockoutThreshold)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if locked || record.Failures != 1 {
+		t.Errorf("locked %v with %d failures, want a new count", locked, record.Failures)
+	}
+}
+
+func TestLockoutDuration(t *testing.T) {
+	for extra, want := range map[int]time.Duration{
+		0:   baseLockoutDuration,
+		1:   2 * baseLockoutDuration,
+		5:   32 * baseLockoutDuration,
+		11:  maxLockoutDuration,
+		100: maxLockoutDuration,
+	} {
+		if got := lockoutDuration(extra); got != want {
+			t.Errorf("lockoutDuration(%d) = %s, want %s", extra, got, want)
+		}
+	}
+}
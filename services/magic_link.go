@@ -0,0 +1,184 @@
+package services
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/yasharya2901/smart_divide/models"
+	"github.com/yasharya2901/smart_divide/utils"
+	"gorm.io/gorm"
+)
+
+const (
+	magicLinkExpiry         = 15 * time.Minute
+	magicLinkRateWindow     = time.Hour
+	magicLinkMaxPerWindow   = 5
+	magicLinkInvalidMessage = "invalid or expired login link"
+)
+
+// RateLimitedError is returned when too many magic links were requested for an address.
+type RateLimitedError struct {
+	Until time.Time
+}
+
+func (e *RateLimitedError) Error() string {
+	return "too many login links requested, please try again later"
+}
+
+// RequestMagicLink emails a single-use login link to the person with the given email. It
+// returns a secret for the requesting device, which must be presented with the emailed token
+// unless MAGIC_LINK_BIND_DEVICE is "false". Unknown emails get a secret too but no email,
+// and a link that could not be emailed still returns one, so callers can't tell which
+// emails are registered.
+func (as *AuthService) RequestMagicLink(email string, device DeviceInfo) (string, error) {
+	// Issuance is limited per address, registered or not
+	var recent []models.MagicLinkToken
+	if err := as.db.Where("email = ? AND created_at > ?", email, time.Now().Add(-magicLinkRateWindow)).
+		Order("created_at").Find(&recent).Error; err != nil {
+		return "", err
+	}
+	if len(recent) >= magicLinkMaxPerWindow {
+		err := &RateLimitedError{Until: recent[len(recent)-magicLinkMaxPerWindow].CreatedAt.Add(magicLinkRateWindow)}
+		as.audit(AuditMagicLinkRequest, 0, email, device, err)
+		return "", err
+	}
+
+	var person models.Person
+	if err := as.db.Where("email = ?", email).Limit(1).Find(&person).Error; err != nil {
+		return "", err
+	}
+
+	token, err := utils.GenerateRandomToken(32)
+	if err != nil {
+		return "", err
+	}
+	deviceToken, err := utils.GenerateRandomToken(32)
+	if err != nil {
+		return "", err
+	}
+
+	magicLink := models.MagicLinkToken{
+		Email:      email,
+		TokenHash:  utils.HashToken(token),
+		DeviceHash: utils.HashToken(deviceToken),
+		IPAddress:  device.IPAddress,
+		ExpiresAt:  time.Now().Add(magicLinkExpiry),
+	}
+	if person.ID != 0 {
+		magicLink.PersonID = &person.ID
+	}
+
+	err = as.db.Transaction(func(tx *gorm.DB) error {
+		// Only the most recently requested link stays valid
+		if err := tx.Model(&models.MagicLinkToken{}).
+			Where("email = ? AND used_at IS NULL AND expires_at > ?", email, time.Now()).
+			Update("expires_at", time.Now()).Error; err != nil {
+			return err
+		}
+
+		return tx.Create(&magicLink).Error
+	})
+	if err != nil {
+		return "", err
+	}
+
+	if person.ID == 0 {
+		as.audit(AuditMagicLinkRequest, 0, email, device, errors.New("unknown email"))
+		return deviceToken, nil
+	}
+
+	as.audit(AuditMagicLinkRequest, person.ID, email, device, nil)
+
+	body := fmt.Sprintf("Hi %s,\n\nUse the link below to log in to Smart Divide. It expires in %d minutes and can only be used once, on the device you requested it from.\n\n%s\n\nIf you didn't ask to log in, you can ignore this email.",
+		person.Name, int(magicLinkExpiry.Minutes()), tokenLink(as.cfg.Links.MagicLink, token))
+
+	// A failed email must not change the response, or it would tell that the email is registered
+	if err := as.mailer.Send(email, "Your login link", body); err != nil {
+		log.Println("failed to send magic link email:", err)
+	}
+
+	return deviceToken, nil
+}
+
+// LoginWithMagicLink logs in the person a magic link was sent to. Opening the link proves
+// ownership of the email, so an unverified email becomes verified.
+func (as *AuthService) LoginWithMagicLink(token, deviceToken string, device DeviceInfo) (*LoginResult, error) {
+	var magicLink models.MagicLinkToken
+	if err := as.db.Where("token_hash = ? AND used_at IS NULL AND expires_at > ?", utils.HashToken(token), time.Now()).
+		Limit(1).Find(&magicLink).Error; err != nil {
+		return nil, err
+	}
+	if magicLink.ID == 0 || magicLink.PersonID == nil {
+		err := errors.New(magicLinkInvalidMessage)
+		as.audit(AuditLoginMagicLink, 0, magicLink.Email, device, err)
+		return nil, err
+	}
+
+	if as.cfg.Auth.MagicLinkBindDevice &&
+		subtle.ConstantTimeCompare([]byte(utils.HashToken(deviceToken)), []byte(magicLink.DeviceHash)) != 1 {
+		err := errors.New("the login link must be opened on the device it was requested from")
+		as.audit(AuditLoginMagicLink, *magicLink.PersonID, magicLink.Email, device, err)
+		return nil, err
+	}
+
+	// A locked account stays locked whichever way the person logs in
+	if err := as.loginThrottle.Check(accountAttemptKey(magicLink.Email)); err != nil {
+		as.audit(AuditLoginMagicLink, *magicLink.PersonID, magicLink.Email, device, err)
+		return nil, err
+	}
+
+	var person models.Person
+	err := as.db.Transaction(func(tx *gorm.DB) error {
+		// Mark the token as used first, so a concurrent request with the same token fails
+		result := tx.Model(&models.MagicLinkToken{}).
+			Where("id = ? AND used_at IS NULL", magicLink.ID).
+			Update("used_at", time.Now())
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return errors.New(magicLinkInvalidMessage)
+		}
+
+		// The email of the person may have changed since the link was sent
+		if err := tx.Where("id = ? AND email = ?", *magicLink.PersonID, magicLink.Email).First(&person).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return errors.New(magicLinkInvalidMessage)
+			}
+			return err
+		}
+
+		if person.EmailVerified {
+			return nil
+		}
+
+		// Whoever registered the unverified account may not own the address, so the
+		// password they set and their sessions are dropped, as for OpenID Connect logins
+		if err := tx.Model(&person).Updates(map[string]interface{}{
+			"email_verified": true,
+			"password":       "",
+			"token_version":  gorm.Expr("token_version + 1"),
+		}).Error; err != nil {
+			return err
+		}
+		if err := NewSessionService(tx).RevokeAllSessions(person.ID); err != nil {
+			return err
+		}
+		if err := tx.First(&person, person.ID).Error; err != nil {
+			return err
+		}
+
+		return NewPlaceholderService(tx).ClaimPlaceholders(person.ID, person.Email, "")
+	})
+	if err != nil {
+		as.audit(AuditLoginMagicLink, *magicLink.PersonID, magicLink.Email, device, err)
+		return nil, err
+	}
+
+	result, err := as.completeLogin(&person, device)
+	as.audit(AuditLoginMagicLink, person.ID, person.Email, device, err)
+	return result, err
+}
@@ -0,0 +1,102 @@
+package services
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+// failingMailer fails to send every email, like an unreachable SMTP server.
+type failingMailer struct{}
+
+func (failingMailer) Send(to, subject, body string) error {
+	return errors.New("connection refused")
+}
+
+// emailedToken returns the bare token of the last email, sent with no link configured.
+func emailedToken(t *testing.T, body string) string {
+	t.Helper()
+
+	paragraphs := strings.Split(body, "\n\n")
+	if len(paragraphs) < 3 {
+		t.Fatalf("no token in email %q", body)
+	}
+	return paragraphs[2]
+}
+
+func TestMagicLinkLogin(t *testing.T) {
+	db := newTestDB(t)
+	as, mail := newTestAuthService(t, db, testConfig())
+	createPerson(t, db, "alice")
+
+	deviceToken, err := as.RequestMagicLink("alice@example.com", DeviceInfo{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	messages := mail.Messages()
+	if len(messages) != 1 || messages[0].To != "alice@example.com" {
+		t.Fatalf("sent %+v", messages)
+	}
+	token := emailedToken(t, messages[0].Body)
+
+	if _, err := as.LoginWithMagicLink(token, "another-device", DeviceInfo{}); err == nil {
+		t.Error("link opened on another device")
+	}
+	result, err := as.LoginWithMagicLink(token, deviceToken, DeviceInfo{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.AccessToken == "" {
+		t.Fatalf("login returned %+v", result)
+	}
+	if _, err := as.LoginWithMagicLink(token, deviceToken, DeviceInfo{}); err == nil {
+		t.Error("link used twice")
+	}
+}
+
+func TestRequestMagicLinkDoesNotRevealAccounts(t *testing.T) {
+	db := newTestDB(t)
+	as, mail := newTestAuthService(t, db, testConfig())
+	createPerson(t, db, "alice")
+
+	for _, mailer := range []struct {
+		name  string
+		fails bool
+	}{{"mail sent", false}, {"mail failed", true}} {
+		t.Run(mailer.name, func(t *testing.T) {
+			if mailer.fails {
+				as.mailer = failingMailer{}
+				defer func() { as.mailer = mail }()
+			}
+
+			for _, email := range []string{"alice@example.com", "nobody@example.com"} {
+				deviceToken, err := as.RequestMagicLink(email, DeviceInfo{})
+				if err != nil || deviceToken == "" {
+					t.Errorf("%s: device token %q, error %v", email, deviceToken, err)
+				}
+			}
+		})
+	}
+
+	for _, message := range mail.Messages() {
+		if message.To != "alice@example.com" {
+			t.Errorf("link emailed to unknown address %s", message.To)
+		}
+	}
+}
+
+func TestRequestMagicLinkRateLimit(t *testing.T) {
+	db := newTestDB(t)
+	as, _ := newTestAuthService(t, db, testConfig())
+
+	for i := 0; i < magicLinkMaxPerWindow; i++ {
+		if _, err := as.RequestMagicLink("nobody@example.com", DeviceInfo{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var rateLimited *RateLimitedError
+	if _, err := as.RequestMagicLink("nobody@example.com", DeviceInfo{}); !errors.As(err, &rateLimited) {
+		t.Fatalf("returned %v, want a RateLimitedError", err)
+	}
+}
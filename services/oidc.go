@@ -0,0 +1,168 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/yasharya2901/smart_divide/models"
+	"github.com/yasharya2901/smart_divide/oidc"
+	"github.com/yasharya2901/smart_divide/utils"
+	"gorm.io/gorm"
+)
+
+const oidcLoginStateExpiry = 10 * time.Minute
+
+type OIDCService struct {
+	db          *gorm.DB
+	providers   map[string]*oidc.Provider
+	authService *AuthService
+}
+
+func NewOIDCService(db *gorm.DB, providers map[string]*oidc.Provider, authService *AuthService) *OIDCService {
+	return &OIDCService{db: db, providers: providers, authService: authService}
+}
+
+// StartLogin stores a new login state for the provider and returns the URL to redirect the user to.
+func (oc *OIDCService) StartLogin(ctx context.Context, providerName string) (string, error) {
+	provider, ok := oc.providers[providerName]
+	if !ok {
+		return "", errors.New("unknown identity provider")
+	}
+
+	state, err := utils.GenerateRandomToken(32)
+	if err != nil {
+		return "", err
+	}
+	nonce, err := utils.GenerateRandomToken(32)
+	if err != nil {
+		return "", err
+	}
+	codeVerifier, err := utils.GenerateRandomToken(48)
+	if err != nil {
+		return "", err
+	}
+
+	if err := oc.db.Create(&models.OIDCLoginState{
+		StateHash:    utils.HashToken(state),
+		Provider:     providerName,
+		CodeVerifier: codeVerifier,
+		Nonce:        nonce,
+		ExpiresAt:    time.Now().Add(oidcLoginStateExpiry),
+	}).Error; err != nil {
+		return "", err
+	}
+
+	return provider.AuthCodeURL(ctx, state, nonce, oidc.CodeChallenge(codeVerifier))
+}
+
+// CompleteLogin handles the provider callback. The external identity is linked to an existing
+// person by verified email, or a new person without a password is created for it.
+func (oc *OIDCService) CompleteLogin(ctx context.Context, providerName, code, state string, device DeviceInfo) (*LoginResult, error) {
+	provider, ok := oc.providers[providerName]
+	if !ok {
+		return nil, errors.New("unknown identity provider")
+	}
+
+	// The state can only be used once
+	var loginState models.OIDCLoginState
+	if err := oc.db.Where("state_hash = ? AND provider = ? AND expires_at > ?", utils.HashToken(state), providerName, time.Now()).
+		First(&loginState).Error; err != nil {
+		return nil, errors.New("invalid or expired login state")
+	}
+	if err := oc.db.Unscoped().Delete(&loginState).Error; err != nil {
+		return nil, err
+	}
+
+	token, err := provider.Exchange(ctx, code, loginState.CodeVerifier)
+	if err != nil {
+		return nil, err
+	}
+
+	claims, err := provider.VerifyIDToken(ctx, token.IDToken, loginState.Nonce)
+	if err != nil {
+		oc.authService.audit(AuditLoginOIDC, 0, "", device, err)
+		return nil, err
+	}
+
+	person, err := oc.findOrLinkPerson(providerName, claims)
+	if err != nil {
+		oc.authService.audit(AuditLoginOIDC, 0, claims.Email, device, err)
+		return nil, err
+	}
+
+	result, err := oc.authService.completeLogin(person, device)
+	oc.authService.audit(AuditLoginOIDC, person.ID, person.Email, device, err)
+	return result, err
+}
+
+func (oc *OIDCService) findOrLinkPerson(providerName string, claims *oidc.IDTokenClaims) (*models.Person, error) {
+	var identity models.ExternalIdentity
+	err := oc.db.Where("provider = ? AND subject = ?", providerName, claims.Subject).First(&identity).Error
+	if err == nil {
+		var person models.Person
+		if err := oc.db.First(&person, identity.PersonID).Error; err != nil {
+			return nil, err
+		}
+		return &person, nil
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+
+	// Linking by email is only safe if the provider verified the address
+	if claims.Email == "" || !claims.EmailVerified {
+		return nil, errors.New("the identity provider did not return a verified email")
+	}
+
+	var person models.Person
+	err = oc.db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Where("email = ?", claims.Email).First(&person).Error
+		switch {
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			name := claims.Name
+			if name == "" {
+				name = claims.Email
+			}
+			person = models.Person{Name: name, Email: claims.Email, EmailVerified: true, EmailHash: emailHash(claims.Email)}
+			if err := tx.Create(&person).Error; err != nil {
+				return err
+			}
+		case err != nil:
+			return err
+		case !person.EmailVerified:
+			// Whoever registered the unverified account may not own the address, so the
+			// password they set and their sessions are dropped before linking
+			if err := tx.Model(&person).Updates(map[string]interface{}{
+				"email_verified": true,
+				"password":       "",
+				"token_version":  gorm.Expr("token_version + 1"),
+			}).Error; err != nil {
+				return err
+			}
+			if err := NewSessionService(tx).RevokeAllSessions(person.ID); err != nil {
+				return err
+			}
+			if err := tx.First(&person, person.ID).Error; err != nil {
+				return err
+			}
+		}
+
+		// The provider verified the email, so placeholders added with it belong to the person
+		if err := NewPlaceholderService(tx).ClaimPlaceholders(person.ID, claims.Email, ""); err != nil {
+			return err
+		}
+
+		return tx.Create(&models.ExternalIdentity{
+			PersonID: person.ID,
+			Provider: providerName,
+			Subject:  claims.Subject,
+			Email:    claims.Email,
+		}).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &person, nil
+}
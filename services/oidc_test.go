@@ -0,0 +1,148 @@
+package services
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/yasharya2901/smart_divide/models"
+	"github.com/yasharya2901/smart_divide/oidc"
+	"github.com/yasharya2901/smart_divide/oidc/oidctest"
+)
+
+func newTestOIDCService(t *testing.T) (*OIDCService, *oidctest.Issuer) {
+	t.Helper()
+
+	db := newTestDB(t)
+	authService, _ := newTestAuthService(t, db, testConfig())
+	issuer := oidctest.NewIssuer(t, "/", "smart-divide")
+	providers := map[string]*oidc.Provider{
+		"test": oidc.NewProvider(oidc.Config{
+			Name:        "test",
+			Issuer:      issuer.Issuer,
+			ClientID:    issuer.ClientID,
+			RedirectURL: "https://app.example.com/callback",
+		}),
+	}
+	return NewOIDCService(db, providers, authService), issuer
+}
+
+// startOIDCLogin starts a login and signs in at the provider, returning the code and state
+// of the callback.
+func startOIDCLogin(t *testing.T, oc *OIDCService, issuer *oidctest.Issuer, claims jwt.MapClaims) (string, string) {
+	t.Helper()
+
+	authURL, err := oc.StartLogin(context.Background(), "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	code, state, err := issuer.Authorize(authURL, claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return code, state
+}
+
+func TestOIDCLogin(t *testing.T) {
+	oc, issuer := newTestOIDCService(t)
+	ctx := context.Background()
+
+	code, state := startOIDCLogin(t, oc, issuer, nil)
+	result, err := oc.CompleteLogin(ctx, "test", code, state, DeviceInfo{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.AccessToken == "" || result.RefreshToken == "" {
+		t.Fatalf("login returned %+v", result)
+	}
+
+	var identity models.ExternalIdentity
+	if err := oc.db.Where("provider = ? AND subject = ?", "test", "user-1").First(&identity).Error; err != nil {
+		t.Fatal(err)
+	}
+	var person models.Person
+	if err := oc.db.First(&person, identity.PersonID).Error; err != nil {
+		t.Fatal(err)
+	}
+	if person.Email != "user@example.com" || !person.EmailVerified {
+		t.Errorf("created person %+v", person)
+	}
+
+	// The same identity logs in to the same person again
+	code, state = startOIDCLogin(t, oc, issuer, nil)
+	if _, err := oc.CompleteLogin(ctx, "test", code, state, DeviceInfo{}); err != nil {
+		t.Fatal(err)
+	}
+	var count int64
+	oc.db.Model(&models.Person{}).Count(&count)
+	if count != 1 {
+		t.Errorf("%d people after logging in twice", count)
+	}
+}
+
+func TestOIDCLoginState(t *testing.T) {
+	oc, issuer := newTestOIDCService(t)
+	ctx := context.Background()
+
+	code, _ := startOIDCLogin(t, oc, issuer, nil)
+	if _, err := oc.CompleteLogin(ctx, "test", code, "forged-state", DeviceInfo{}); err == nil {
+		t.Error("unknown state accepted")
+	}
+
+	code, state := startOIDCLogin(t, oc, issuer, nil)
+	if _, err := oc.CompleteLogin(ctx, "test", code, state, DeviceInfo{}); err != nil {
+		t.Fatal(err)
+	}
+	code, _ = startOIDCLogin(t, oc, issuer, nil)
+	if _, err := oc.CompleteLogin(ctx, "test", code, state, DeviceInfo{}); err == nil {
+		t.Error("state used twice")
+	}
+
+	code, state = startOIDCLogin(t, oc, issuer, nil)
+	if err := oc.db.Model(&models.OIDCLoginState{}).Where("1 = 1").Update("expires_at", time.Now().Add(-time.Second)).Error; err != nil {
+		t.Fatal(err)
+	}
+	if _, err := oc.CompleteLogin(ctx, "test", code, state, DeviceInfo{}); err == nil {
+		t.Error("expired state accepted")
+	}
+}
+
+func TestOIDCLoginRejectsIDToken(t *testing.T) {
+	tests := []struct {
+		name   string
+		claims jwt.MapClaims
+	}{
+		{"nonce of another login", jwt.MapClaims{"nonce": "replayed-nonce"}},
+		{"wrong audience", jwt.MapClaims{"aud": "another-client"}},
+		{"expired", jwt.MapClaims{"exp": time.Now().Add(-time.Minute).Unix()}},
+		{"unverified email", jwt.MapClaims{"email_verified": false}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			oc, issuer := newTestOIDCService(t)
+
+			code, state := startOIDCLogin(t, oc, issuer, tt.claims)
+			if _, err := oc.CompleteLogin(context.Background(), "test", code, state, DeviceInfo{}); err == nil {
+				t.Fatal("login accepted")
+			}
+
+			var count int64
+			oc.db.Model(&models.Person{}).Count(&count)
+			if count != 0 {
+				t.Errorf("%d people created", count)
+			}
+		})
+	}
+}
+
+func TestOIDCLoginSendsCodeVerifier(t *testing.T) {
+	oc, issuer := newTestOIDCService(t)
+
+	// A code stolen from another login can't be redeemed, as its verifier is unknown
+	stolen, _ := startOIDCLogin(t, oc, issuer, nil)
+	_, state := startOIDCLogin(t, oc, issuer, nil)
+	if _, err := oc.CompleteLogin(context.Background(), "test", stolen, state, DeviceInfo{}); err == nil {
+		t.Fatal("code redeemed with the verifier of another login")
+	}
+}
@@ -0,0 +1,161 @@
+package services
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"math/big"
+	"time"
+
+	"github.com/yasharya2901/smart_divide/models"
+	"github.com/yasharya2901/smart_divide/sms"
+	"github.com/yasharya2901/smart_divide/utils"
+	"gorm.io/gorm"
+)
+
+const (
+	OTPPurposeLogin         = "login"
+	OTPPurposeVerifyContact = "verify_contact"
+
+	otpLength         = 6
+	otpExpiry         = 5 * time.Minute
+	otpMaxAttempts    = 5
+	otpRequestWindow  = 15 * time.Minute
+	otpMaxRequests    = 3
+	otpInvalidMessage = "invalid or expired code"
+)
+
+type OTPService struct {
+	db     *gorm.DB
+	sender sms.Sender
+}
+
+func NewOTPService(db *gorm.DB, sender sms.Sender) *OTPService {
+	return &OTPService{db: db, sender: sender}
+}
+
+// ErrTooManyOTPRequests is returned when too many codes were requested for a phone number.
+var ErrTooManyOTPRequests = errors.New("too many codes requested, please try again later")
+
+// SendOTP texts a new one-time code for the purpose to a phone number.
+// Only a few codes can be requested per number in a time window.
+func (ot *OTPService) SendOTP(contact, purpose string) error {
+	otp, err := ot.reserveOTP(contact, purpose)
+	if err != nil {
+		return err
+	}
+
+	return ot.issueOTP(otp)
+}
+
+// reserveOTP counts a request for a code against the limit of the phone number, and
+// records it as a code that can't be used until issueOTP sets one.
+func (ot *OTPService) reserveOTP(contact, purpose string) (*models.PhoneOTP, error) {
+	if !utils.ValidatePhoneNumber(contact) {
+		return nil, errors.New("invalid phone number")
+	}
+
+	var recent int64
+	if err := ot.db.Model(&models.PhoneOTP{}).
+		Where("contact = ? AND created_at > ?", contact, time.Now().Add(-otpRequestWindow)).
+		Count(&recent).Error; err != nil {
+		return nil, err
+	}
+	if recent >= otpMaxRequests {
+		return nil, ErrTooManyOTPRequests
+	}
+
+	otp := models.PhoneOTP{
+		Contact:   contact,
+		Purpose:   purpose,
+		ExpiresAt: time.Now(),
+	}
+	if err := ot.db.Create(&otp).Error; err != nil {
+		return nil, err
+	}
+	return &otp, nil
+}
+
+// issueOTP sets a new code on a reserved one and texts it.
+func (ot *OTPService) issueOTP(otp *models.PhoneOTP) error {
+	code, err := generateOTPCode()
+	if err != nil {
+		return err
+	}
+
+	err = ot.db.Transaction(func(tx *gorm.DB) error {
+		// Only the latest code for a purpose stays valid
+		if err := tx.Model(&models.PhoneOTP{}).
+			Where("contact = ? AND purpose = ? AND consumed_at IS NULL AND id <> ?", otp.Contact, otp.Purpose, otp.ID).
+			Update("expires_at", time.Now()).Error; err != nil {
+			return err
+		}
+
+		return tx.Model(otp).Updates(map[string]interface{}{
+			"code_hash":  hashOTPCode(otp.Contact, code),
+			"expires_at": time.Now().Add(otpExpiry),
+		}).Error
+	})
+	if err != nil {
+		return err
+	}
+
+	return ot.sender.Send(otp.Contact, fmt.Sprintf("Your Smart Divide code is %s. It expires in %d minutes.", code, int(otpExpiry.Minutes())))
+}
+
+// VerifyOTP checks a code for a phone number and consumes it.
+// A code is discarded after too many wrong attempts.
+func (ot *OTPService) VerifyOTP(contact, purpose, code string) error {
+	var otp models.PhoneOTP
+	if err := ot.db.Where("contact = ? AND purpose = ? AND consumed_at IS NULL AND expires_at > ?", contact, purpose, time.Now()).
+		Order("created_at desc").First(&otp).Error; err != nil {
+		return errors.New(otpInvalidMessage)
+	}
+
+	// Counting the attempt and checking the limit in one statement keeps parallel guesses
+	// from going over it
+	attempt := ot.db.Model(&models.PhoneOTP{}).
+		Where("id = ? AND attempts < ?", otp.ID, otpMaxAttempts).
+		Update("attempts", gorm.Expr("attempts + 1"))
+	if attempt.Error != nil {
+		return attempt.Error
+	}
+	if attempt.RowsAffected == 0 {
+		return errors.New("too many attempts, please request a new code")
+	}
+
+	if subtle.ConstantTimeCompare([]byte(otp.CodeHash), []byte(hashOTPCode(contact, code))) != 1 {
+		return errors.New(otpInvalidMessage)
+	}
+
+	result := ot.db.Model(&models.PhoneOTP{}).
+		Where("id = ? AND consumed_at IS NULL", otp.ID).
+		Update("consumed_at", time.Now())
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return errors.New(otpInvalidMessage)
+	}
+
+	return nil
+}
+
+func generateOTPCode() (string, error) {
+	max := big.NewInt(1)
+	for i := 0; i < otpLength; i++ {
+		max.Mul(max, big.NewInt(10))
+	}
+
+	n, err := rand.Int(rand.Reader, max)
+	if err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("%0*d", otpLength, n), nil
+}
+
+func hashOTPCode(contact, code string) string {
+	return utils.HashToken(contact + ":" + code)
+}
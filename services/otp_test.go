@@ -0,0 +1,132 @@
+package services
+
+import (
+	"regexp"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/yasharya2901/smart_divide/models"
+	"github.com/yasharya2901/smart_divide/sms"
+)
+
+const testContact = "+919876543210"
+
+// sendTestOTP sends a code to testContact and returns it, as read from the text message.
+func sendTestOTP(t *testing.T, otps *OTPService, sender *sms.MemorySender) string {
+	t.Helper()
+
+	if err := otps.SendOTP(testContact, OTPPurposeLogin); err != nil {
+		t.Fatal(err)
+	}
+	messages := sender.Messages()
+	code := regexp.MustCompile(`\d{6}`).FindString(messages[len(messages)-1].Body)
+	if code == "" {
+		t.Fatalf("no code in %q", messages[len(messages)-1].Body)
+	}
+	return code
+}
+
+// wrongCode returns a code that differs from code.
+func wrongCode(code string) string {
+	if code == "000000" {
+		return "111111"
+	}
+	return "000000"
+}
+
+func TestVerifyOTP(t *testing.T) {
+	db := newTestDB(t)
+	sender := sms.NewMemorySender()
+	otps := NewOTPService(db, sender)
+	code := sendTestOTP(t, otps, sender)
+
+	if err := otps.VerifyOTP(testContact, OTPPurposeVerifyContact, code); err == nil {
+		t.Error("code was accepted for another purpose")
+	}
+	if err := otps.VerifyOTP("+919876543211", OTPPurposeLogin, code); err == nil {
+		t.Error("code was accepted for another number")
+	}
+	if err := otps.VerifyOTP(testContact, OTPPurposeLogin, code); err != nil {
+		t.Fatalf("valid code was rejected: %v", err)
+	}
+	if err := otps.VerifyOTP(testContact, OTPPurposeLogin, code); err == nil {
+		t.Error("code was accepted twice")
+	}
+}
+
+func TestVerifyOTPOnlyLatestCode(t *testing.T) {
+	db := newTestDB(t)
+	sender := sms.NewMemorySender()
+	otps := NewOTPService(db, sender)
+	first := sendTestOTP(t, otps, sender)
+	second := sendTestOTP(t, otps, sender)
+
+	if first != second {
+		if err := otps.VerifyOTP(testContact, OTPPurposeLogin, first); err == nil {
+			t.Error("replaced code was accepted")
+		}
+	}
+	if err := otps.VerifyOTP(testContact, OTPPurposeLogin, second); err != nil {
+		t.Errorf("latest code was rejected: %v", err)
+	}
+}
+
+func TestVerifyOTPExpired(t *testing.T) {
+	db := newTestDB(t)
+	sender := sms.NewMemorySender()
+	otps := NewOTPService(db, sender)
+	code := sendTestOTP(t, otps, sender)
+
+	if err := db.Model(&models.PhoneOTP{}).Where("contact = ?", testContact).
+		Update("expires_at", time.Now().Add(-time.Second)).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := otps.VerifyOTP(testContact, OTPPurposeLogin, code); err == nil {
+		t.Error("expired code was accepted")
+	}
+}
+
+func TestVerifyOTPAttemptLimit(t *testing.T) {
+	db := newTestDB(t)
+	sender := sms.NewMemorySender()
+	otps := NewOTPService(db, sender)
+	code := sendTestOTP(t, otps, sender)
+
+	for i := 0; i < otpMaxAttempts; i++ {
+		if err := otps.VerifyOTP(testContact, OTPPurposeLogin, wrongCode(code)); err == nil {
+			t.Fatal("wrong code was accepted")
+		}
+	}
+	if err := otps.VerifyOTP(testContact, OTPPurposeLogin, code); err == nil {
+		t.Error("code was accepted after too many attempts")
+	}
+}
+
+func TestVerifyOTPParallelGuesses(t *testing.T) {
+	db := newTestDB(t)
+	sender := sms.NewMemorySender()
+	otps := NewOTPService(db, sender)
+	code := sendTestOTP(t, otps, sender)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20*otpMaxAttempts; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			otps.VerifyOTP(testContact, OTPPurposeLogin, wrongCode(code))
+		}()
+	}
+	wg.Wait()
+
+	var otp models.PhoneOTP
+	if err := db.Where("contact = ?", testContact).First(&otp).Error; err != nil {
+		t.Fatal(err)
+	}
+	if otp.Attempts > otpMaxAttempts {
+		t.Errorf("%d attempts were counted, the limit is %d", otp.Attempts, otpMaxAttempts)
+	}
+	if err := otps.VerifyOTP(testContact, OTPPurposeLogin, code); err == nil {
+		t.Error("code was accepted after too many parallel attempts")
+	}
+}
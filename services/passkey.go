@@ -0,0 +1,414 @@
+package services
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/webauthn"
+	"github.com/yasharya2901/smart_divide/config"
+	"github.com/yasharya2901/smart_divide/models"
+	"github.com/yasharya2901/smart_divide/utils"
+	"gorm.io/gorm"
+)
+
+const webAuthnSessionExpiry = 5 * time.Minute
+
+const (
+	webAuthnPurposeRegistration = "registration"
+	webAuthnPurposeLogin        = "login"
+)
+
+// ErrPasskeysDisabled is returned when no relying party is configured.
+var ErrPasskeysDisabled = errors.New("passkeys are not configured")
+
+// NewWebAuthn creates the relying party passkeys are registered for. It returns nil if no
+// relying party ID is configured, which disables passkeys.
+func NewWebAuthn(cfg config.WebAuthn) (*webauthn.WebAuthn, error) {
+	if cfg.RPID == "" {
+		return nil, nil
+	}
+
+	return webauthn.New(&webauthn.Config{
+		RPID:          cfg.RPID,
+		RPDisplayName: cfg.RPName,
+		RPOrigins:     cfg.RPOrigins,
+	})
+}
+
+type PasskeyService struct {
+	db          *gorm.DB
+	webAuthn    *webauthn.WebAuthn
+	authService *AuthService
+}
+
+func NewPasskeyService(db *gorm.DB, webAuthn *webauthn.WebAuthn, authService *AuthService) *PasskeyService {
+	return &PasskeyService{db: db, webAuthn: webAuthn, authService: authService}
+}
+
+// passkeyUser adapts a person and their passkeys to the webauthn.User interface.
+type passkeyUser struct {
+	person      *models.Person
+	credentials []models.WebAuthnCredential
+}
+
+func (u *passkeyUser) WebAuthnID() []byte {
+	return personHandle(u.person.ID)
+}
+
+func (u *passkeyUser) WebAuthnName() string {
+	if u.person.Email != "" {
+		return u.person.Email
+	}
+	return u.person.Contact
+}
+
+func (u *passkeyUser) WebAuthnDisplayName() string {
+	return u.person.Name
+}
+
+func (u *passkeyUser) WebAuthnCredentials() []webauthn.Credential {
+	credentials := make([]webauthn.Credential, 0, len(u.credentials))
+	for _, credential := range u.credentials {
+		id, err := base64.RawURLEncoding.DecodeString(credential.CredentialID)
+		if err != nil {
+			continue
+		}
+
+		var transports []protocol.AuthenticatorTransport
+		for _, transport := range strings.Fields(credential.Transports) {
+			transports = append(transports, protocol.AuthenticatorTransport(transport))
+		}
+
+		credentials = append(credentials, webauthn.Credential{
+			ID:              id,
+			PublicKey:       credential.PublicKey,
+			AttestationType: credential.AttestationType,
+			Transport:       transports,
+			Flags: webauthn.CredentialFlags{
+				UserPresent:    true,
+				UserVerified:   true,
+				BackupEligible: credential.BackupEligible,
+				BackupState:    credential.BackupState,
+			},
+			Authenticator: webauthn.Authenticator{
+				AAGUID:    credential.AAGUID,
+				SignCount: credential.SignCount,
+			},
+		})
+	}
+	return credentials
+}
+
+// personHandle is the user handle authenticators store for a person. It holds no personal data.
+func personHandle(personID uint) []byte {
+	return binary.BigEndian.AppendUint64(nil, uint64(personID))
+}
+
+func (ps *PasskeyService) loadUser(person *models.Person) (*passkeyUser, error) {
+	var credentials []models.WebAuthnCredential
+	if err := ps.db.Where("person_id = ?", person.ID).Find(&credentials).Error; err != nil {
+		return nil, err
+	}
+	return &passkeyUser{person: person, credentials: credentials}, nil
+}
+
+// BeginRegistration starts registering a new passkey for a person. It returns the options
+// for navigator.credentials.create() and a token identifying the challenge.
+func (ps *PasskeyService) BeginRegistration(personID uint) (*protocol.CredentialCreation, string, error) {
+	if ps.webAuthn == nil {
+		return nil, "", ErrPasskeysDisabled
+	}
+
+	var person models.Person
+	if err := ps.db.First(&person, personID).Error; err != nil {
+		return nil, "", err
+	}
+
+	user, err := ps.loadUser(&person)
+	if err != nil {
+		return nil, "", err
+	}
+
+	// Passkeys are discoverable so they can be used without typing an email
+	options, session, err := ps.webAuthn.BeginRegistration(user,
+		webauthn.WithExclusions(webauthn.Credentials(user.WebAuthnCredentials()).CredentialDescriptors()),
+		webauthn.WithAuthenticatorSelection(protocol.AuthenticatorSelection{
+			ResidentKey:      protocol.ResidentKeyRequirementRequired,
+			UserVerification: protocol.VerificationRequired,
+		}),
+	)
+	if err != nil {
+		return nil, "", err
+	}
+
+	token, err := ps.saveSession(person.ID, webAuthnPurposeRegistration, session)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return options, token, nil
+}
+
+// FinishRegistration verifies the response of the authenticator and stores the new passkey.
+func (ps *PasskeyService) FinishRegistration(personID uint, sessionToken string, response []byte, nickname string, device DeviceInfo) (*models.WebAuthnCredential, error) {
+	if ps.webAuthn == nil {
+		return nil, ErrPasskeysDisabled
+	}
+
+	session, err := ps.takeSession(sessionToken, webAuthnPurposeRegistration, personID)
+	if err != nil {
+		return nil, err
+	}
+
+	parsed, err := protocol.ParseCredentialCreationResponseBytes(response)
+	if err != nil {
+		return nil, errors.New("invalid passkey registration response")
+	}
+
+	var person models.Person
+	if err := ps.db.First(&person, personID).Error; err != nil {
+		return nil, err
+	}
+
+	user, err := ps.loadUser(&person)
+	if err != nil {
+		return nil, err
+	}
+
+	credential, err := ps.webAuthn.CreateCredential(user, *session, parsed)
+	if err != nil {
+		return nil, errors.New("passkey registration could not be verified")
+	}
+
+	transports := make([]string, len(credential.Transport))
+	for i, transport := range credential.Transport {
+		transports[i] = string(transport)
+	}
+
+	if nickname == "" {
+		nickname = "Passkey"
+	}
+
+	passkey := models.WebAuthnCredential{
+		PersonID:        personID,
+		CredentialID:    base64.RawURLEncoding.EncodeToString(credential.ID),
+		PublicKey:       credential.PublicKey,
+		AttestationType: credential.AttestationType,
+		AAGUID:          credential.Authenticator.AAGUID,
+		SignCount:       credential.Authenticator.SignCount,
+		Transports:      strings.Join(transports, " "),
+		BackupEligible:  credential.Flags.BackupEligible,
+		BackupState:     credential.Flags.BackupState,
+		Nickname:        nickname,
+	}
+	if err := ps.db.Create(&passkey).Error; err != nil {
+		return nil, err
+	}
+
+	ps.authService.audit(AuditPasskeyRegistered, personID, person.Email, device, nil)
+	return &passkey, nil
+}
+
+// BeginLogin starts a passkey login and returns the options for navigator.credentials.get().
+// The browser lets the user pick any discoverable passkey for this site. No email is asked
+// for, so the options never tell whether an account exists or which passkeys it has.
+func (ps *PasskeyService) BeginLogin() (*protocol.CredentialAssertion, string, error) {
+	if ps.webAuthn == nil {
+		return nil, "", ErrPasskeysDisabled
+	}
+
+	options, session, err := ps.webAuthn.BeginDiscoverableLogin(webauthn.WithUserVerification(protocol.VerificationRequired))
+	if err != nil {
+		return nil, "", err
+	}
+
+	token, err := ps.saveSession(0, webAuthnPurposeLogin, session)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return options, token, nil
+}
+
+// FinishLogin verifies the assertion of the authenticator and issues the same tokens as a
+// password login. A passkey with user verification already proves two factors, so no
+// second factor is asked for.
+func (ps *PasskeyService) FinishLogin(sessionToken string, response []byte, device DeviceInfo) (*LoginResult, error) {
+	if ps.webAuthn == nil {
+		return nil, ErrPasskeysDisabled
+	}
+
+	invalidPasskey := errors.New("passkey could not be verified")
+
+	session, err := ps.takeSession(sessionToken, webAuthnPurposeLogin, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	parsed, err := protocol.ParseCredentialRequestResponseBytes(response)
+	if err != nil {
+		ps.authService.audit(AuditLoginPasskey, 0, "", device, err)
+		return nil, invalidPasskey
+	}
+
+	var user *passkeyUser
+	findUser := func(rawID, userHandle []byte) (webauthn.User, error) {
+		var passkey models.WebAuthnCredential
+		if err := ps.db.Where("credential_id = ?", base64.RawURLEncoding.EncodeToString(rawID)).First(&passkey).Error; err != nil {
+			return nil, err
+		}
+		if !bytes.Equal(userHandle, personHandle(passkey.PersonID)) {
+			return nil, errors.New("passkey belongs to another account")
+		}
+
+		var person models.Person
+		if err := ps.db.First(&person, passkey.PersonID).Error; err != nil {
+			return nil, err
+		}
+
+		user, err = ps.loadUser(&person)
+		return user, err
+	}
+
+	credential, err := ps.webAuthn.ValidateDiscoverableLogin(findUser, *session, parsed)
+	if err != nil {
+		var personID uint
+		var email string
+		if user != nil {
+			personID, email = user.person.ID, user.person.Email
+		}
+		ps.authService.audit(AuditLoginPasskey, personID, email, device, err)
+		return nil, invalidPasskey
+	}
+
+	person := user.person
+	if credential.Authenticator.CloneWarning {
+		err := errors.New("signature counter went backwards, the passkey may have been cloned")
+		ps.authService.audit(AuditLoginPasskey, person.ID, person.Email, device, err)
+		return nil, invalidPasskey
+	}
+
+	// A locked account stays locked whichever way the person logs in
+	if person.Email != "" {
+		if err := ps.authService.loginThrottle.Check(accountAttemptKey(person.Email)); err != nil {
+			ps.authService.audit(AuditLoginPasskey, person.ID, person.Email, device, err)
+			return nil, err
+		}
+	}
+
+	now := time.Now()
+	if err := ps.db.Model(&models.WebAuthnCredential{}).
+		Where("credential_id = ?", base64.RawURLEncoding.EncodeToString(credential.ID)).
+		Updates(map[string]interface{}{
+			"sign_count":   credential.Authenticator.SignCount,
+			"backup_state": credential.Flags.BackupState,
+			"last_used_at": now,
+		}).Error; err != nil {
+		return nil, err
+	}
+
+	accessToken, refreshToken, err := ps.authService.startSession(person, device)
+	ps.authService.audit(AuditLoginPasskey, person.ID, person.Email, device, err)
+	if err != nil {
+		return nil, err
+	}
+
+	return &LoginResult{AccessToken: accessToken, RefreshToken: refreshToken}, nil
+}
+
+func (ps *PasskeyService) GetPasskeys(personID uint) ([]models.WebAuthnCredential, error) {
+	var passkeys []models.WebAuthnCredential
+	if err := ps.db.Where("person_id = ?", personID).Order("created_at desc").Find(&passkeys).Error; err != nil {
+		return nil, err
+	}
+	return passkeys, nil
+}
+
+func (ps *PasskeyService) RenamePasskey(personID, passkeyID uint, nickname string) error {
+	result := ps.db.Model(&models.WebAuthnCredential{}).
+		Where("id = ? AND person_id = ?", passkeyID, personID).
+		Update("nickname", nickname)
+	if result.Error != nil {
+		return result.Error
+	}
+
+	if result.RowsAffected == 0 {
+		return errors.New("passkey not found")
+	}
+
+	return nil
+}
+
+func (ps *PasskeyService) DeletePasskey(personID, passkeyID uint, device DeviceInfo) error {
+	result := ps.db.Unscoped().Where("id = ? AND person_id = ?", passkeyID, personID).Delete(&models.WebAuthnCredential{})
+	if result.Error != nil {
+		return result.Error
+	}
+
+	if result.RowsAffected == 0 {
+		return errors.New("passkey not found")
+	}
+
+	ps.authService.audit(AuditPasskeyRemoved, personID, "", device, nil)
+	return nil
+}
+
+// saveSession stores the challenge of a ceremony and returns the token the client sends back with the response.
+func (ps *PasskeyService) saveSession(personID uint, purpose string, session *webauthn.SessionData) (string, error) {
+	data, err := json.Marshal(session)
+	if err != nil {
+		return "", err
+	}
+
+	token, err := utils.GenerateRandomToken(32)
+	if err != nil {
+		return "", err
+	}
+
+	if err := ps.db.Create(&models.WebAuthnSession{
+		TokenHash:   utils.HashToken(token),
+		PersonID:    personID,
+		Purpose:     purpose,
+		SessionData: string(data),
+		ExpiresAt:   time.Now().Add(webAuthnSessionExpiry),
+	}).Error; err != nil {
+		return "", err
+	}
+
+	return token, nil
+}
+
+// takeSession loads the challenge of a ceremony. A challenge can only be answered once.
+func (ps *PasskeyService) takeSession(token, purpose string, personID uint) (*webauthn.SessionData, error) {
+	invalidSession := errors.New("invalid or expired passkey challenge")
+
+	var stored models.WebAuthnSession
+	if err := ps.db.Where("token_hash = ? AND purpose = ? AND person_id = ? AND expires_at > ?", utils.HashToken(token), purpose, personID, time.Now()).
+		Limit(1).Find(&stored).Error; err != nil {
+		return nil, err
+	}
+	if stored.ID == 0 {
+		return nil, invalidSession
+	}
+
+	result := ps.db.Unscoped().Where("id = ?", stored.ID).Delete(&models.WebAuthnSession{})
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return nil, invalidSession
+	}
+
+	var session webauthn.SessionData
+	if err := json.Unmarshal([]byte(stored.SessionData), &session); err != nil {
+		return nil, err
+	}
+
+	return &session, nil
+}
@@ -0,0 +1,317 @@
+package services
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"testing"
+
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/protocol/webauthncbor"
+	"github.com/yasharya2901/smart_divide/config"
+	"github.com/yasharya2901/smart_divide/models"
+)
+
+const (
+	testRPID   = "example.com"
+	testOrigin = "https://example.com"
+)
+
+// virtualAuthenticator is a software passkey: a P-256 key that answers registration and
+// login ceremonies the way a platform authenticator with user verification does.
+type virtualAuthenticator struct {
+	t            *testing.T
+	key          *ecdsa.PrivateKey
+	credentialID []byte
+	userHandle   []byte
+	signCount    uint32
+	origin       string
+}
+
+func newVirtualAuthenticator(t *testing.T) *virtualAuthenticator {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	credentialID := make([]byte, 32)
+	if _, err := rand.Read(credentialID); err != nil {
+		t.Fatal(err)
+	}
+	return &virtualAuthenticator{t: t, key: key, credentialID: credentialID, origin: testOrigin}
+}
+
+// authenticatorData returns the RP ID hash, the user present and verified flags and the
+// signature counter, followed by extra data such as the attested credential.
+func (va *virtualAuthenticator) authenticatorData(flags byte, extra []byte) []byte {
+	rpIDHash := sha256.Sum256([]byte(testRPID))
+	data := append(rpIDHash[:], flags|0x01|0x04)
+	data = binary.BigEndian.AppendUint32(data, va.signCount)
+	return append(data, extra...)
+}
+
+func (va *virtualAuthenticator) clientData(ceremony string, challenge []byte) []byte {
+	data, err := json.Marshal(map[string]string{
+		"type":      ceremony,
+		"challenge": base64.RawURLEncoding.EncodeToString(challenge),
+		"origin":    va.origin,
+	})
+	if err != nil {
+		va.t.Fatal(err)
+	}
+	return data
+}
+
+// create answers navigator.credentials.create() with a "none" attestation.
+func (va *virtualAuthenticator) create(options *protocol.CredentialCreation) []byte {
+	va.userHandle = options.Response.User.ID.(protocol.URLEncodedBase64)
+
+	publicKey, err := webauthncbor.Marshal(map[int]interface{}{
+		1:  2,  // EC2 key
+		3:  -7, // ES256
+		-1: 1,  // P-256
+		-2: va.key.X.FillBytes(make([]byte, 32)),
+		-3: va.key.Y.FillBytes(make([]byte, 32)),
+	})
+	if err != nil {
+		va.t.Fatal(err)
+	}
+
+	attested := make([]byte, 16) // AAGUID
+	attested = binary.BigEndian.AppendUint16(attested, uint16(len(va.credentialID)))
+	attested = append(attested, va.credentialID...)
+	attested = append(attested, publicKey...)
+
+	attestation, err := webauthncbor.Marshal(map[string]interface{}{
+		"fmt":      "none",
+		"attStmt":  map[string]interface{}{},
+		"authData": va.authenticatorData(0x40, attested),
+	})
+	if err != nil {
+		va.t.Fatal(err)
+	}
+
+	return va.marshal(map[string]interface{}{
+		"clientDataJSON":    va.clientData("webauthn.create", options.Response.Challenge),
+		"attestationObject": attestation,
+	})
+}
+
+// get answers navigator.credentials.get() with a signed assertion.
+func (va *virtualAuthenticator) get(options *protocol.CredentialAssertion) []byte {
+	va.signCount++
+	authData := va.authenticatorData(0, nil)
+	clientData := va.clientData("webauthn.get", options.Response.Challenge)
+
+	clientDataHash := sha256.Sum256(clientData)
+	digest := sha256.Sum256(append(append([]byte{}, authData...), clientDataHash[:]...))
+	signature, err := ecdsa.SignASN1(rand.Reader, va.key, digest[:])
+	if err != nil {
+		va.t.Fatal(err)
+	}
+
+	return va.marshal(map[string]interface{}{
+		"clientDataJSON":    clientData,
+		"authenticatorData": authData,
+		"signature":         signature,
+		"userHandle":        va.userHandle,
+	})
+}
+
+// marshal wraps an authenticator response in a PublicKeyCredential, with binary fields
+// base64url encoded as the browser does.
+func (va *virtualAuthenticator) marshal(response map[string]interface{}) []byte {
+	encoded := make(map[string]string, len(response))
+	for name, value := range response {
+		encoded[name] = base64.RawURLEncoding.EncodeToString(value.([]byte))
+	}
+
+	data, err := json.Marshal(map[string]interface{}{
+		"id":       base64.RawURLEncoding.EncodeToString(va.credentialID),
+		"rawId":    base64.RawURLEncoding.EncodeToString(va.credentialID),
+		"type":     "public-key",
+		"response": encoded,
+	})
+	if err != nil {
+		va.t.Fatal(err)
+	}
+	return data
+}
+
+func newTestPasskeyService(t *testing.T) *PasskeyService {
+	t.Helper()
+
+	db := newTestDB(t)
+	webAuthn, err := NewWebAuthn(config.WebAuthn{RPID: testRPID, RPName: "Smart Divide", RPOrigins: []string{testOrigin}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	authService, _ := newTestAuthService(t, db, testConfig())
+	return NewPasskeyService(db, webAuthn, authService)
+}
+
+// registerPasskey registers a new virtual authenticator for the person.
+func registerPasskey(t *testing.T, ps *PasskeyService, person *models.Person) *virtualAuthenticator {
+	t.Helper()
+
+	options, token, err := ps.BeginRegistration(person.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if options.Response.AuthenticatorSelection.ResidentKey != protocol.ResidentKeyRequirementRequired {
+		t.Errorf("registration does not require a discoverable passkey")
+	}
+
+	authenticator := newVirtualAuthenticator(t)
+	passkey, err := ps.FinishRegistration(person.ID, token, authenticator.create(options), "Laptop", DeviceInfo{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if passkey.CredentialID != base64.RawURLEncoding.EncodeToString(authenticator.credentialID) || passkey.Nickname != "Laptop" {
+		t.Errorf("stored passkey %+v", passkey)
+	}
+	return authenticator
+}
+
+func TestPasskeyRegistrationAndLogin(t *testing.T) {
+	ps := newTestPasskeyService(t)
+	person := createPerson(t, ps.db, "alice")
+	authenticator := registerPasskey(t, ps, person)
+
+	options, token, err := ps.BeginLogin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(options.Response.AllowedCredentials) != 0 {
+		t.Errorf("login options list credentials: %v", options.Response.AllowedCredentials)
+	}
+
+	result, err := ps.FinishLogin(token, authenticator.get(options), DeviceInfo{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.AccessToken == "" || result.RefreshToken == "" {
+		t.Fatalf("login returned %+v", result)
+	}
+
+	var passkey models.WebAuthnCredential
+	if err := ps.db.Where("person_id = ?", person.ID).First(&passkey).Error; err != nil {
+		t.Fatal(err)
+	}
+	if passkey.SignCount != authenticator.signCount || passkey.LastUsedAt == nil {
+		t.Errorf("sign count %d and last use %v not updated", passkey.SignCount, passkey.LastUsedAt)
+	}
+
+	// A challenge can only be answered once
+	if _, err := ps.FinishLogin(token, authenticator.get(options), DeviceInfo{}); err == nil {
+		t.Error("challenge answered twice")
+	}
+}
+
+func TestPasskeyRegistrationRejectsWrongOrigin(t *testing.T) {
+	ps := newTestPasskeyService(t)
+	person := createPerson(t, ps.db, "alice")
+
+	options, token, err := ps.BeginRegistration(person.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	authenticator := newVirtualAuthenticator(t)
+	authenticator.origin = "https://evil.example"
+	if _, err := ps.FinishRegistration(person.ID, token, authenticator.create(options), "", DeviceInfo{}); err == nil {
+		t.Fatal("registration from another origin accepted")
+	}
+}
+
+func TestPasskeyLoginOptionsDoNotDependOnAccount(t *testing.T) {
+	ps := newTestPasskeyService(t)
+	registerPasskey(t, ps, createPerson(t, ps.db, "alice"))
+
+	first, _, err := ps.BeginLogin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, _, err := ps.BeginLogin()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Only the challenge differs, so the options reveal nothing about accounts
+	first.Response.Challenge, second.Response.Challenge = nil, nil
+	a, _ := json.Marshal(first)
+	b, _ := json.Marshal(second)
+	if string(a) != string(b) {
+		t.Errorf("login options differ:\n%s\n%s", a, b)
+	}
+}
+
+func TestPasskeyLoginRejectsBadAssertions(t *testing.T) {
+	ps := newTestPasskeyService(t)
+	alice := registerPasskey(t, ps, createPerson(t, ps.db, "alice"))
+	bob := createPerson(t, ps.db, "bob")
+	registerPasskey(t, ps, bob)
+
+	tests := []struct {
+		name   string
+		modify func(va *virtualAuthenticator)
+	}{
+		{"wrong key", func(va *virtualAuthenticator) {
+			other := newVirtualAuthenticator(t)
+			va.key = other.key
+		}},
+		{"other user handle", func(va *virtualAuthenticator) {
+			va.userHandle = personHandle(bob.ID)
+		}},
+		{"unknown credential", func(va *virtualAuthenticator) {
+			va.credentialID = newVirtualAuthenticator(t).credentialID
+		}},
+		{"wrong origin", func(va *virtualAuthenticator) {
+			va.origin = "https://evil.example"
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			authenticator := *alice
+			tt.modify(&authenticator)
+
+			options, token, err := ps.BeginLogin()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := ps.FinishLogin(token, authenticator.get(options), DeviceInfo{}); err == nil {
+				t.Fatal("login accepted")
+			}
+		})
+	}
+}
+
+func TestPasskeyLoginRejectsClonedAuthenticator(t *testing.T) {
+	ps := newTestPasskeyService(t)
+	authenticator := registerPasskey(t, ps, createPerson(t, ps.db, "alice"))
+	clone := *authenticator
+
+	for i := 0; i < 2; i++ {
+		options, token, err := ps.BeginLogin()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := ps.FinishLogin(token, authenticator.get(options), DeviceInfo{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The copy still has the first counter value, which the server has seen already
+	options, token, err := ps.BeginLogin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ps.FinishLogin(token, clone.get(options), DeviceInfo{}); err == nil {
+		t.Fatal("login with a signature counter that went backwards accepted")
+	}
+}
@@ -0,0 +1,116 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"time"
+
+	"github.com/yasharya2901/smart_divide/models"
+	"github.com/yasharya2901/smart_divide/utils"
+	"gorm.io/gorm"
+)
+
+const passwordResetTokenExpiry = 30 * time.Minute
+
+// RequestPasswordReset emails a one-time reset link to the person with the given email.
+// Unknown emails are silently ignored so callers can't tell which emails are registered.
+func (as *AuthService) RequestPasswordReset(email string, device DeviceInfo) error {
+	var person models.Person
+	if err := as.db.Where("email = ?", email).First(&person).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			as.audit(AuditPasswordResetRequest, 0, email, device, errors.New("unknown email"))
+			return nil
+		}
+		return err
+	}
+
+	token, err := utils.GenerateRandomToken(32)
+	if err != nil {
+		return err
+	}
+
+	err = as.db.Transaction(func(tx *gorm.DB) error {
+		// Only the most recently requested link stays valid
+		if err := tx.Model(&models.PasswordResetToken{}).
+			Where("person_id = ? AND used_at IS NULL", person.ID).
+			Update("expires_at", time.Now()).Error; err != nil {
+			return err
+		}
+
+		return tx.Create(&models.PasswordResetToken{
+			PersonID:  person.ID,
+			TokenHash: utils.HashToken(token),
+			ExpiresAt: time.Now().Add(passwordResetTokenExpiry),
+		}).Error
+	})
+	if err != nil {
+		return err
+	}
+
+	as.audit(AuditPasswordResetRequest, person.ID, email, device, nil)
+
+	body := fmt.Sprintf("Hi %s,\n\nUse the link below to reset your Smart Divide password. It expires in %d minutes.\n\n%s\n\nIf you didn't ask for a password reset, you can ignore this email.",
+		person.Name, int(passwordResetTokenExpiry.Minutes()), tokenLink(as.cfg.Links.PasswordReset, token))
+
+	return as.mailer.Send(person.Email, "Reset your password", body)
+}
+
+// ResetPassword sets a new password using a reset token. The token can only be used once,
+// and every session of the person is logged out.
+func (as *AuthService) ResetPassword(token, newPassword string, device DeviceInfo) error {
+	var resetToken models.PasswordResetToken
+	if err := as.db.Where("token_hash = ? AND used_at IS NULL AND expires_at > ?", utils.HashToken(token), time.Now()).
+		First(&resetToken).Error; err != nil {
+		err := errors.New("invalid or expired reset token")
+		as.audit(AuditPasswordReset, 0, "", device, err)
+		return err
+	}
+
+	person, err := as.peopleService.GetPersonByID(resetToken.PersonID)
+	if err != nil {
+		return err
+	}
+
+	if err := as.passwordPolicy.Check(newPassword, person.Name, person.Email); err != nil {
+		return err
+	}
+
+	hashedPassword, err := as.passwords.Hash(newPassword)
+	if err != nil {
+		return err
+	}
+
+	err = as.db.Transaction(func(tx *gorm.DB) error {
+		// Mark the token as used first, so a concurrent request with the same token fails
+		result := tx.Model(&models.PasswordResetToken{}).
+			Where("id = ? AND used_at IS NULL", resetToken.ID).
+			Update("used_at", time.Now())
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return errors.New("invalid or expired reset token")
+		}
+
+		if err := tx.Model(&models.Person{}).Where("id = ?", resetToken.PersonID).Updates(map[string]interface{}{
+			"password":      hashedPassword,
+			"token_version": gorm.Expr("token_version + 1"),
+		}).Error; err != nil {
+			return err
+		}
+
+		return NewSessionService(tx).RevokeAllSessions(resetToken.PersonID)
+	})
+	as.audit(AuditPasswordReset, resetToken.PersonID, "", device, err)
+	return err
+}
+
+// tokenLink appends a token to a frontend URL, or returns the bare token when no URL is configured.
+func tokenLink(baseURL, token string) string {
+	if baseURL == "" {
+		return token
+	}
+
+	return baseURL + "?token=" + url.QueryEscape(token)
+}
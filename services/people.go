@@ -2,27 +2,33 @@ package services
 
 import (
 	"errors"
-	"time"
+	"strings"
 
+	"github.com/yasharya2901/smart_divide/config"
+	"github.com/yasharya2901/smart_divide/mailer"
 	"github.com/yasharya2901/smart_divide/models"
-	"github.com/yasharya2901/smart_divide/utils"
+	"github.com/yasharya2901/smart_divide/passwordhash"
 	"gorm.io/gorm"
 )
 
 type PeopleService struct {
-	db *gorm.DB
+	db                       *gorm.DB
+	emailVerificationService *EmailVerificationService
+	passwords                *passwordhash.Manager
 }
 
-func NewPeopleService(db *gorm.DB) *PeopleService {
-	return &PeopleService{db: db}
+func NewPeopleService(db *gorm.DB, cfg *config.Config, mail mailer.Mailer, passwords *passwordhash.Manager) *PeopleService {
+	return &PeopleService{db: db, emailVerificationService: NewEmailVerificationService(db, cfg, mail), passwords: passwords}
 }
 
 func (ps *PeopleService) CreatePerson(name, contact, email string) (*models.Person, error) {
 	// Create a person
 	person := models.Person{
-		Name:    name,
-		Contact: contact,
-		Email:   email,
+		Name:        name,
+		Contact:     contact,
+		Email:       email,
+		EmailHash:   emailHash(email),
+		ContactHash: contactHash(contact),
 	}
 	if err := ps.db.Create(&person).Error; err != nil {
 		return nil, err
@@ -48,7 +54,7 @@ func (ps *PeopleService) GetPersonByID(id uint) (*models.Person, error) {
 	return &person, nil
 }
 
-func (ps *PeopleService) UpdatePerson(id uint, name, contact, email, refreshToken string, expiryTime time.Time) (*models.Person, error) {
+func (ps *PeopleService) UpdatePerson(id uint, name, contact, email string) (*models.Person, error) {
 	// Update a person
 	var person models.Person
 
@@ -59,56 +65,75 @@ func (ps *PeopleService) UpdatePerson(id uint, name, contact, email, refreshToke
 	if name != "" {
 		person.Name = name
 	}
-	if contact != "" {
+	if contact != "" && contact != person.Contact {
+		var count int64
+		if err := ps.db.Model(&models.Person{}).Where("verified_contact = ? AND id <> ?", contact, id).Count(&count).Error; err != nil {
+			return nil, err
+		}
+		if count > 0 {
+			return nil, ErrContactTaken
+		}
 		person.Contact = contact
-	}
-	if email != "" {
-		person.Email = email
+		person.ContactHash = contactHash(contact)
+		person.ContactVerified = false
+		person.VerifiedContact = nil
 	}
 
-	if refreshToken != "" {
-		person.RefreshToken = refreshToken
-		person.RefreshTokenExpiryDate = &expiryTime
+	// A new email stays pending until the new address is confirmed
+	emailChanged := email != "" && email != person.Email
+	if emailChanged {
+		var count int64
+		if err := ps.db.Model(&models.Person{}).Where("email = ? AND id <> ?", email, id).Count(&count).Error; err != nil {
+			return nil, err
+		}
+		if count > 0 {
+			return nil, errors.New("email already registered")
+		}
+		person.PendingEmail = email
 	}
 
 	if err := ps.db.Save(&person).Error; err != nil {
 		return nil, err
 	}
+
+	if emailChanged {
+		if err := ps.emailVerificationService.SendVerification(&person, email); err != nil {
+			return nil, err
+		}
+	}
 	return &person, nil
 }
 
-func (ps *PeopleService) GetPeopleByContacts(contacts []string) ([]models.Person, error) {
-	// Get people by contacts
-	var people []models.Person
-	if err := ps.db.Where("contact IN ?", contacts).Find(&people).Error; err != nil {
-		return nil, err
+// SearchPeople returns the accounts whose name, email or contact number contains the query,
+// newest first. Placeholders and deleted accounts are left out.
+func (ps *PeopleService) SearchPeople(query string, limit, offset int) ([]models.Person, error) {
+	db := ps.db.Where("placeholder = ? AND anonymized_at IS NULL", false)
+	if query != "" {
+		pattern := "%" + escapeLike(query) + "%"
+		db = db.Where("name LIKE ? OR email LIKE ? OR contact LIKE ?", pattern, pattern, pattern)
 	}
-	return people, nil
-}
 
-func (ps *PeopleService) GetPeopleByEmails(emails []string) ([]models.Person, error) {
-	// Get people by emails
 	var people []models.Person
-	if err := ps.db.Where("email IN ?", emails).Find(&people).Error; err != nil {
+	if err := db.Order("id desc").Limit(clampLimit(limit)).Offset(offset).Find(&people).Error; err != nil {
 		return nil, err
 	}
 	return people, nil
 }
 
+// escapeLike escapes the wildcards of a LIKE pattern.
+func escapeLike(value string) string {
+	return strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(value)
+}
+
 func (ps *PeopleService) Authenticate(email, password string) (interface{}, error) {
 	// Authenticate a person
 	var person models.Person
 
-	hashedPassword, err := utils.HashPassword(password)
-	if err != nil {
-		return nil, err
-	}
-
 	if err := ps.db.Where("email = ?", email).First(&person).Error; err != nil {
 		return nil, err
 	}
 
-	passwordMatched, err := utils.ComparePasswords(hashedPassword, person.Password)
+	passwordMatched, _, err := ps.passwords.Verify(person.Password, password)
 	if err != nil {
 		return nil, err
 	}
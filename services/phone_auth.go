@@ -0,0 +1,119 @@
+package services
+
+import (
+	"errors"
+
+	"github.com/yasharya2901/smart_divide/models"
+	"gorm.io/gorm"
+)
+
+// ErrContactTaken is returned when a contact number was already verified by another account.
+var ErrContactTaken = errors.New("contact number already registered")
+
+// RequestLoginOTP texts a login code to the verified phone number of a person. Unknown and
+// unverified numbers are silently ignored so callers can't tell which numbers are registered,
+// which is why every number counts against the request limit before it is looked up.
+func (as *AuthService) RequestLoginOTP(contact string) error {
+	otp, err := as.otpService.reserveOTP(contact, OTPPurposeLogin)
+	if err != nil {
+		return err
+	}
+
+	var person models.Person
+	if err := as.db.Where("verified_contact = ?", contact).First(&person).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil
+		}
+		return err
+	}
+
+	return as.otpService.issueOTP(otp)
+}
+
+// LoginWithOTP logs in the person who verified a phone number using a code texted to it.
+// Only verified numbers are unique, so anyone else could have set the number on their account.
+func (as *AuthService) LoginWithOTP(contact, code string, device DeviceInfo) (*LoginResult, error) {
+	var person models.Person
+	if err := as.db.Where("verified_contact = ?", contact).Limit(1).Find(&person).Error; err != nil {
+		return nil, err
+	}
+
+	if err := as.otpService.VerifyOTP(contact, OTPPurposeLogin, code); err != nil {
+		as.audit(AuditLoginOTP, person.ID, person.Email, device, err)
+		return nil, err
+	}
+
+	if person.ID == 0 {
+		return nil, errors.New(otpInvalidMessage)
+	}
+
+	result, err := as.completeLogin(&person, device)
+	as.audit(AuditLoginOTP, person.ID, person.Email, device, err)
+	return result, err
+}
+
+// RequestContactVerification texts a verification code to the contact number of a person.
+func (as *AuthService) RequestContactVerification(personID uint) error {
+	person, err := as.peopleService.GetPersonByID(personID)
+	if err != nil {
+		return err
+	}
+
+	if person.ContactVerified {
+		return errors.New("contact number is already verified")
+	}
+
+	if err := as.ensureContactAvailable(person); err != nil {
+		return err
+	}
+
+	return as.otpService.SendOTP(person.Contact, OTPPurposeVerifyContact)
+}
+
+// VerifyContact marks the contact number of a person as verified using a texted code.
+func (as *AuthService) VerifyContact(personID uint, code string) error {
+	person, err := as.peopleService.GetPersonByID(personID)
+	if err != nil {
+		return err
+	}
+
+	if err := as.otpService.VerifyOTP(person.Contact, OTPPurposeVerifyContact, code); err != nil {
+		return err
+	}
+
+	return as.markContactVerified(person)
+}
+
+// markContactVerified records that the person owns their contact number, and merges the
+// placeholders added with that number into their account. The unique index on
+// verified_contact keeps two accounts from verifying the same number at once.
+func (as *AuthService) markContactVerified(person *models.Person) error {
+	if err := as.ensureContactAvailable(person); err != nil {
+		return err
+	}
+
+	return as.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(person).Updates(map[string]interface{}{
+			"contact_verified": true,
+			"verified_contact": person.Contact,
+		}).Error; err != nil {
+			return err
+		}
+
+		return NewPlaceholderService(tx).ClaimPlaceholders(person.ID, "", person.Contact)
+	})
+}
+
+// ensureContactAvailable fails if another account already verified the contact number of
+// the person.
+func (as *AuthService) ensureContactAvailable(person *models.Person) error {
+	var count int64
+	if err := as.db.Model(&models.Person{}).Where("verified_contact = ? AND id <> ?", person.Contact, person.ID).
+		Count(&count).Error; err != nil {
+		return err
+	}
+	if count > 0 {
+		return ErrContactTaken
+	}
+	return nil
+}
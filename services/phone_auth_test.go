@@ -0,0 +1,50 @@
+package services
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/yasharya2901/smart_divide/sms"
+)
+
+func TestRequestLoginOTPLimitsEveryNumber(t *testing.T) {
+	db := newTestDB(t)
+	as, _ := newTestAuthService(t, db, testConfig())
+	sender := as.otpService.sender.(*sms.MemorySender)
+
+	person := createPerson(t, db, "registered")
+	if err := db.Model(person).Updates(map[string]interface{}{
+		"contact":          testContact,
+		"contact_verified": true,
+		"verified_contact": testContact,
+	}).Error; err != nil {
+		t.Fatal(err)
+	}
+	unknown := "+919876543211"
+
+	// Registered and unknown numbers run into the limit after the same number of requests
+	for _, contact := range []string{testContact, unknown} {
+		for i := 0; i < otpMaxRequests; i++ {
+			if err := as.RequestLoginOTP(contact); err != nil {
+				t.Fatalf("request %d for %s: %v", i+1, contact, err)
+			}
+		}
+		if err := as.RequestLoginOTP(contact); !errors.Is(err, ErrTooManyOTPRequests) {
+			t.Errorf("request over the limit for %s returned %v", contact, err)
+		}
+	}
+
+	for _, message := range sender.Messages() {
+		if message.To != testContact {
+			t.Errorf("code texted to %s", message.To)
+		}
+	}
+	if len(sender.Messages()) != otpMaxRequests {
+		t.Errorf("%d codes texted, want %d", len(sender.Messages()), otpMaxRequests)
+	}
+
+	// Requests that sent nothing can't be used to log in
+	if _, err := as.LoginWithOTP(unknown, "", DeviceInfo{}); err == nil {
+		t.Error("logged in to an unknown number without a code")
+	}
+}
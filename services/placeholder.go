@@ -0,0 +1,191 @@
+package services
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/yasharya2901/smart_divide/models"
+	"gorm.io/gorm"
+)
+
+// PlaceholderService manages placeholder members, people added to an event by name before
+// they have an account. Expenses can be recorded against them as with any member, and once
+// someone verifies the email or number of a placeholder it is merged into their account.
+type PlaceholderService struct {
+	db *gorm.DB
+}
+
+func NewPlaceholderService(db *gorm.DB) *PlaceholderService {
+	return &PlaceholderService{db: db}
+}
+
+// CreatePlaceholder adds a placeholder member to an event. The email and contact number are optional.
+func (ps *PlaceholderService) CreatePlaceholder(eventID uint, name, contact, email string) (*models.Person, error) {
+	email = strings.TrimSpace(email)
+
+	if email != "" {
+		var count int64
+		if err := ps.db.Model(&models.Person{}).Where("email = ? AND email_verified = ?", email, true).Count(&count).Error; err != nil {
+			return nil, err
+		}
+		if count > 0 {
+			return nil, errors.New("a person with this email is already registered, add them instead")
+		}
+	}
+
+	if contact != "" {
+		var count int64
+		if err := ps.db.Model(&models.Person{}).Where("contact = ? AND contact_verified = ?", contact, true).Count(&count).Error; err != nil {
+			return nil, err
+		}
+		if count > 0 {
+			return nil, errors.New("a person with this contact number is already registered, add them instead")
+		}
+	}
+
+	person := models.Person{
+		Name:               name,
+		Placeholder:        true,
+		PlaceholderEmail:   email,
+		PlaceholderContact: contact,
+	}
+
+	err := ps.db.Transaction(func(tx *gorm.DB) error {
+		// Email is left NULL, so placeholders don't collide on its unique index
+		if err := tx.Omit("Email").Create(&person).Error; err != nil {
+			return err
+		}
+
+		return tx.Create(&models.EventPerson{EventID: eventID, PersonID: person.ID, Role: RoleMember}).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &person, nil
+}
+
+// ClaimPlaceholders merges every placeholder with the verified email or contact number into
+// the account of the person. Empty values are ignored.
+func (ps *PlaceholderService) ClaimPlaceholders(personID uint, email, contact string) error {
+	if email == "" && contact == "" {
+		return nil
+	}
+
+	query := ps.db.Where("placeholder = ? AND id <> ?", true, personID)
+	switch {
+	case email != "" && contact != "":
+		query = query.Where("placeholder_email = ? OR placeholder_contact = ?", email, contact)
+	case email != "":
+		query = query.Where("placeholder_email = ?", email)
+	default:
+		query = query.Where("placeholder_contact = ?", contact)
+	}
+
+	var placeholders []models.Person
+	if err := query.Find(&placeholders).Error; err != nil {
+		return err
+	}
+
+	for _, placeholder := range placeholders {
+		if err := ps.merge(placeholder.ID, personID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// merge re-points the splits, payments and event memberships of a placeholder to the
+// person and deletes the placeholder.
+func (ps *PlaceholderService) merge(placeholderID, personID uint) error {
+	return ps.db.Transaction(func(tx *gorm.DB) error {
+		if err := mergeSplits(tx, placeholderID, personID); err != nil {
+			return err
+		}
+
+		if err := tx.Model(&models.Expense{}).Where("paid_by_id = ?", placeholderID).
+			Update("paid_by_id", personID).Error; err != nil {
+			return err
+		}
+
+		if err := mergeMemberships(tx, placeholderID, personID); err != nil {
+			return err
+		}
+
+		if err := tx.Model(&models.Person{}).Where("id = ?", placeholderID).
+			Update("merged_into_id", personID).Error; err != nil {
+			return err
+		}
+
+		return tx.Delete(&models.Person{}, placeholderID).Error
+	})
+}
+
+// mergeSplits moves the expense splits of a placeholder to the person. If both have a
+// split of the same expense, the amounts are added to the person's split.
+func mergeSplits(tx *gorm.DB, placeholderID, personID uint) error {
+	var splits []models.ExpensePerson
+	if err := tx.Where("person_id = ?", placeholderID).Find(&splits).Error; err != nil {
+		return err
+	}
+
+	for _, split := range splits {
+		var existing []models.ExpensePerson
+		if err := tx.Where("expense_id = ? AND person_id = ?", split.ExpenseID, personID).Limit(1).Find(&existing).Error; err != nil {
+			return err
+		}
+
+		if len(existing) == 0 {
+			if err := tx.Model(&split).Update("person_id", personID).Error; err != nil {
+				return err
+			}
+			continue
+		}
+
+		paid, err := existing[0].PaidAmount.Add(split.PaidAmount)
+		if err != nil {
+			return err
+		}
+		owed, err := existing[0].OwedAmount.Add(split.OwedAmount)
+		if err != nil {
+			return err
+		}
+		if err := tx.Model(&existing[0]).Updates(map[string]interface{}{
+			"paid_amount_minor": paid.Amount,
+			"owed_amount_minor": owed.Amount,
+		}).Error; err != nil {
+			return err
+		}
+		if err := tx.Delete(&split).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// mergeMemberships moves the event memberships of a placeholder to the person. Events the
+// person is already a member of keep the person's role.
+func mergeMemberships(tx *gorm.DB, placeholderID, personID uint) error {
+	var memberships []models.EventPerson
+	if err := tx.Where("person_id = ?", placeholderID).Find(&memberships).Error; err != nil {
+		return err
+	}
+
+	for _, membership := range memberships {
+		role, err := NewEventService(tx).GetRole(membership.EventID, personID)
+		if err != nil {
+			return err
+		}
+
+		if role == "" {
+			if err := tx.Create(&models.EventPerson{EventID: membership.EventID, PersonID: personID, Role: membership.Role}).Error; err != nil {
+				return err
+			}
+		}
+
+		if err := tx.Where("event_id = ? AND person_id = ?", membership.EventID, placeholderID).
+			Delete(&models.EventPerson{}).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,89 @@
+package services
+
+import (
+	"errors"
+	"time"
+
+	"github.com/yasharya2901/smart_divide/models"
+	"gorm.io/gorm"
+)
+
+// DeviceInfo describes the device a session is created from.
+type DeviceInfo struct {
+	DeviceName string
+	UserAgent  string
+	IPAddress  string
+}
+
+type SessionService struct {
+	db *gorm.DB
+}
+
+func NewSessionService(db *gorm.DB) *SessionService {
+	return &SessionService{db: db}
+}
+
+func (ss *SessionService) CreateSession(personID uint, device DeviceInfo, expiresAt time.Time) (*models.Session, error) {
+	// Create a session for a newly logged in device
+	session := models.Session{
+		PersonID:   personID,
+		DeviceName: device.DeviceName,
+		UserAgent:  device.UserAgent,
+		IPAddress:  device.IPAddress,
+		LastUsedAt: time.Now(),
+		ExpiresAt:  expiresAt,
+	}
+	if err := ss.db.Create(&session).Error; err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
+
+func (ss *SessionService) GetSessionByID(id uint) (*models.Session, error) {
+	// Get a session by ID
+	var session models.Session
+	if err := ss.db.First(&session, id).Error; err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
+
+func (ss *SessionService) GetActiveSessions(personID uint) ([]models.Session, error) {
+	// Get all sessions of a person that can still be refreshed
+	var sessions []models.Session
+	if err := ss.db.Where("person_id = ? AND revoked = ? AND expires_at > ?", personID, false, time.Now()).
+		Order("last_used_at desc").Find(&sessions).Error; err != nil {
+		return nil, err
+	}
+	return sessions, nil
+}
+
+func (ss *SessionService) RevokeSession(personID, sessionID uint) error {
+	// Revoke a single session of a person
+	result := ss.db.Model(&models.Session{}).
+		Where("id = ? AND person_id = ? AND revoked = ?", sessionID, personID, false).
+		Updates(map[string]interface{}{"revoked": true, "refresh_token_hash": ""})
+	if result.Error != nil {
+		return result.Error
+	}
+
+	if result.RowsAffected == 0 {
+		return errors.New("session not found")
+	}
+
+	return nil
+}
+
+func (ss *SessionService) RevokeOtherSessions(personID, currentSessionID uint) error {
+	// Revoke every session of a person except the current one
+	return ss.db.Model(&models.Session{}).
+		Where("person_id = ? AND id <> ? AND revoked = ?", personID, currentSessionID, false).
+		Updates(map[string]interface{}{"revoked": true, "refresh_token_hash": ""}).Error
+}
+
+func (ss *SessionService) RevokeAllSessions(personID uint) error {
+	// Revoke every session of a person
+	return ss.db.Model(&models.Session{}).
+		Where("person_id = ? AND revoked = ?", personID, false).
+		Updates(map[string]interface{}{"revoked": true, "refresh_token_hash": ""}).Error
+}
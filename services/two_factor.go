@@ -0,0 +1,272 @@
+package services
+
+import (
+	"crypto/rand"
+	"encoding/base32"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/yasharya2901/smart_divide/models"
+	"github.com/yasharya2901/smart_divide/utils"
+	"gorm.io/gorm"
+)
+
+const (
+	totpIssuer           = "Smart Divide"
+	twoFactorTokenExpiry = 5 * time.Minute
+	recoveryCodeCount    = 10
+)
+
+// TwoFactorEnrollment holds what an authenticator app needs to add an account.
+type TwoFactorEnrollment struct {
+	Secret          string `json:"secret"`
+	ProvisioningURI string `json:"provisioning_uri"`
+}
+
+// EnrollTwoFactor starts TOTP enrollment by generating a new secret for the person.
+// Two-factor authentication is only enabled once a code is confirmed with ConfirmTwoFactor.
+func (as *AuthService) EnrollTwoFactor(personID uint) (*TwoFactorEnrollment, error) {
+	person, err := as.peopleService.GetPersonByID(personID)
+	if err != nil {
+		return nil, err
+	}
+
+	if person.TOTPEnabled {
+		return nil, errors.New("two-factor authentication is already enabled")
+	}
+
+	secret, err := utils.GenerateTOTPSecret()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := as.db.Model(person).Updates(map[string]interface{}{"totp_secret": secret, "totp_last_step": 0}).Error; err != nil {
+		return nil, err
+	}
+
+	return &TwoFactorEnrollment{
+		Secret:          secret,
+		ProvisioningURI: utils.TOTPProvisioningURI(secret, totpIssuer, person.Email),
+	}, nil
+}
+
+// ConfirmTwoFactor enables two-factor authentication once the person proves their
+// authenticator app works, and returns their recovery codes.
+func (as *AuthService) ConfirmTwoFactor(personID uint, code string, device DeviceInfo) ([]string, error) {
+	person, err := as.peopleService.GetPersonByID(personID)
+	if err != nil {
+		return nil, err
+	}
+
+	if person.TOTPEnabled {
+		return nil, errors.New("two-factor authentication is already enabled")
+	}
+
+	if person.TOTPSecret == "" {
+		return nil, errors.New("two-factor enrollment has not been started")
+	}
+
+	if err := as.verifyTOTP(person, code); err != nil {
+		return nil, err
+	}
+
+	var codes []string
+	err = as.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(person).Update("totp_enabled", true).Error; err != nil {
+			return err
+		}
+
+		codes, err = replaceRecoveryCodes(tx, person.ID)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	as.audit(AuditTwoFactorEnabled, person.ID, person.Email, device, nil)
+
+	return codes, nil
+}
+
+// DisableTwoFactor turns off two-factor authentication. The person must confirm with
+// their password and either a current code or a recovery code.
+func (as *AuthService) DisableTwoFactor(personID uint, password, code, recoveryCode string, device DeviceInfo) error {
+	person, err := as.peopleService.GetPersonByID(personID)
+	if err != nil {
+		return err
+	}
+
+	if !person.TOTPEnabled {
+		return errors.New("two-factor authentication is not enabled")
+	}
+
+	// Accounts that only sign in with an identity provider have no password to confirm
+	if person.Password != "" {
+		if valid, _, err := as.passwords.Verify(person.Password, password); err != nil || !valid {
+			err := errors.New("incorrect password")
+			as.audit(AuditTwoFactorDisabled, person.ID, person.Email, device, err)
+			return err
+		}
+	}
+
+	if err := as.verifySecondFactor(person, code, recoveryCode); err != nil {
+		as.audit(AuditTwoFactorDisabled, person.ID, person.Email, device, err)
+		return err
+	}
+
+	err = as.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(person).Updates(map[string]interface{}{
+			"totp_enabled":   false,
+			"totp_secret":    "",
+			"totp_last_step": 0,
+		}).Error; err != nil {
+			return err
+		}
+
+		return tx.Where("person_id = ?", person.ID).Delete(&models.RecoveryCode{}).Error
+	})
+	if err != nil {
+		return err
+	}
+
+	as.audit(AuditTwoFactorDisabled, person.ID, person.Email, device, nil)
+	return nil
+}
+
+// RegenerateRecoveryCodes replaces every recovery code of the person after checking a current code.
+func (as *AuthService) RegenerateRecoveryCodes(personID uint, code string, device DeviceInfo) ([]string, error) {
+	person, err := as.peopleService.GetPersonByID(personID)
+	if err != nil {
+		return nil, err
+	}
+
+	if !person.TOTPEnabled {
+		return nil, errors.New("two-factor authentication is not enabled")
+	}
+
+	if err := as.verifyTOTP(person, code); err != nil {
+		return nil, err
+	}
+
+	codes, err := replaceRecoveryCodes(as.db, person.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	as.audit(AuditRecoveryCodesRegenerate, person.ID, person.Email, device, nil)
+	return codes, nil
+}
+
+// CompleteTwoFactorLogin exchanges the token returned by the first login step and a
+// TOTP or recovery code for an access and refresh token.
+func (as *AuthService) CompleteTwoFactorLogin(twoFactorToken, code, recoveryCode string, device DeviceInfo) (string, string, error) {
+	claims, err := utils.ValidateToken(twoFactorToken, as.accessKeys)
+	if err != nil || claims.Type != utils.TokenTypeTwoFactor {
+		return "", "", errors.New("invalid or expired two-factor token")
+	}
+
+	person, err := as.peopleService.GetPersonByID(claims.UserID)
+	if err != nil || person.TokenVersion != claims.Version || !person.TOTPEnabled {
+		return "", "", errors.New("invalid or expired two-factor token")
+	}
+
+	if err := as.checkLoginAllowed(person.Email, device); err != nil {
+		return "", "", err
+	}
+
+	// Wrong codes count towards the account lockout, so the second factor can't be brute forced
+	if err := as.verifySecondFactor(person, code, recoveryCode); err != nil {
+		as.audit(AuditLoginTwoFactor, person.ID, person.Email, device, err)
+		if recordErr := as.recordLoginFailure(person, person.Email, device); recordErr != nil {
+			return "", "", recordErr
+		}
+		return "", "", err
+	}
+
+	accessToken, refreshToken, err := as.startSession(person, device)
+	as.audit(AuditLoginTwoFactor, person.ID, person.Email, device, err)
+	return accessToken, refreshToken, err
+}
+
+func (as *AuthService) verifySecondFactor(person *models.Person, code, recoveryCode string) error {
+	if recoveryCode != "" {
+		return as.useRecoveryCode(person.ID, recoveryCode)
+	}
+
+	return as.verifyTOTP(person, code)
+}
+
+// verifyTOTP checks a code and records its time step, so the same code can't be used twice.
+func (as *AuthService) verifyTOTP(person *models.Person, code string) error {
+	step, valid := utils.ValidateTOTP(person.TOTPSecret, code, time.Now())
+	if !valid {
+		return errors.New("invalid two-factor code")
+	}
+
+	result := as.db.Model(&models.Person{}).
+		Where("id = ? AND totp_last_step < ?", person.ID, step).
+		Update("totp_last_step", step)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return errors.New("two-factor code was already used")
+	}
+
+	return nil
+}
+
+func (as *AuthService) useRecoveryCode(personID uint, recoveryCode string) error {
+	result := as.db.Model(&models.RecoveryCode{}).
+		Where("person_id = ? AND code_hash = ? AND used_at IS NULL", personID, utils.HashToken(normalizeRecoveryCode(recoveryCode))).
+		Update("used_at", time.Now())
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return errors.New("invalid recovery code")
+	}
+
+	return nil
+}
+
+// replaceRecoveryCodes deletes the existing recovery codes of a person and stores
+// hashes of a new set. The plain codes are only ever returned here.
+func replaceRecoveryCodes(tx *gorm.DB, personID uint) ([]string, error) {
+	if err := tx.Where("person_id = ?", personID).Delete(&models.RecoveryCode{}).Error; err != nil {
+		return nil, err
+	}
+
+	codes := make([]string, recoveryCodeCount)
+	records := make([]models.RecoveryCode, recoveryCodeCount)
+	for i := range codes {
+		code, err := generateRecoveryCode()
+		if err != nil {
+			return nil, err
+		}
+
+		codes[i] = code
+		records[i] = models.RecoveryCode{PersonID: personID, CodeHash: utils.HashToken(normalizeRecoveryCode(code))}
+	}
+
+	if err := tx.Create(&records).Error; err != nil {
+		return nil, err
+	}
+
+	return codes, nil
+}
+
+// generateRecoveryCode returns a code like "k3j9x-2mf7q".
+func generateRecoveryCode() (string, error) {
+	b := make([]byte, 7)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	code := strings.ToLower(base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(b))[:10]
+	return code[:5] + "-" + code[5:], nil
+}
+
+func normalizeRecoveryCode(code string) string {
+	return strings.ToLower(strings.ReplaceAll(strings.TrimSpace(code), "-", ""))
+}
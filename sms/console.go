@@ -0,0 +1,16 @@
+package sms
+
+import "log"
+
+// ConsoleSender prints text messages to the log instead of sending them.
+// It is meant for local development.
+type ConsoleSender struct{}
+
+func NewConsoleSender() *ConsoleSender {
+	return &ConsoleSender{}
+}
+
+func (s *ConsoleSender) Send(to, message string) error {
+	log.Printf("SMS to %s: %s", to, message)
+	return nil
+}
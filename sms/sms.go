@@ -0,0 +1,31 @@
+package sms
+
+import (
+	"fmt"
+
+	"github.com/yasharya2901/smart_divide/config"
+)
+
+// Sender delivers text messages to phone numbers.
+type Sender interface {
+	Send(to, message string) error
+}
+
+// Message is a text message handed to a Sender.
+type Message struct {
+	To   string
+	Body string
+}
+
+// New creates the sender selected by the driver of the config.
+// Supported drivers are "console" and "memory".
+func New(cfg config.SMS) (Sender, error) {
+	switch driver := cfg.Driver; driver {
+	case "console":
+		return NewConsoleSender(), nil
+	case "memory":
+		return NewMemorySender(), nil
+	default:
+		return nil, fmt.Errorf("unknown sms driver %q", driver)
+	}
+}
@@ -6,30 +6,78 @@ import (
 	"github.com/golang-jwt/jwt/v5"
 )
 
+// Token types, so a token issued for one purpose can't be used for another.
+const (
+	TokenTypeAccess    = "access"
+	TokenTypeRefresh   = "refresh"
+	TokenTypeTwoFactor = "2fa"
+)
+
+// KeySet provides the key new tokens are signed with and the keys tokens are verified with.
+type KeySet interface {
+	// SigningKey returns the key ID, signing method and key for new tokens.
+	SigningKey() (kid string, method jwt.SigningMethod, key interface{}, err error)
+	// VerificationKey returns the key to verify a token signed with the key ID.
+	VerificationKey(kid string) (interface{}, error)
+	// Algorithms returns the signing algorithms tokens are accepted with.
+	Algorithms() []string
+}
+
+// HMACKey is a KeySet with a single shared HS256 secret.
+type HMACKey []byte
+
+func (k HMACKey) SigningKey() (string, jwt.SigningMethod, interface{}, error) {
+	return "", jwt.SigningMethodHS256, []byte(k), nil
+}
+
+func (k HMACKey) VerificationKey(kid string) (interface{}, error) {
+	return []byte(k), nil
+}
+
+func (k HMACKey) Algorithms() []string {
+	return []string{jwt.SigningMethodHS256.Alg()}
+}
+
 type Claims struct {
-	UserID uint   `json:"user_id"`
-	Email  string `json:"email"`
+	UserID         uint   `json:"user_id"`
+	Email          string `json:"email"`
+	SessionID      uint   `json:"sid,omitempty"` // Session the token was issued for
+	Version        uint   `json:"ver"`           // Token version of the person when the token was issued
+	Type           string `json:"typ"`           // Token type
+	ImpersonatorID uint   `json:"imp,omitempty"` // Administrator impersonating the person, if any
 	jwt.RegisteredClaims
 }
 
 // Generate a JWT token.
-// The token should take the user ID, email, expiration time, and secret key as arguments and return the token string and an error.
-func GenerateToken(userID uint, email string, expirationTime time.Duration, secretKey string) (string, time.Time, error) {
-	expiryTime := time.Now().Add(expirationTime)
-
-	claims := &Claims{
-		UserID: userID,
-		Email:  email,
-		RegisteredClaims: jwt.RegisteredClaims{
-			ExpiresAt: jwt.NewNumericDate(time.Now().Add(expirationTime)),
-			IssuedAt:  jwt.NewNumericDate(time.Now()),
-			NotBefore: jwt.NewNumericDate(time.Now()),
-		},
+// The token takes the claims, expiration time, and the keys to sign with as arguments and returns the token string, its expiry time and an error.
+// The registered claims (expiry, issued at, not before and a unique token ID) are filled in here.
+func GenerateToken(claims Claims, expirationTime time.Duration, keys KeySet) (string, time.Time, error) {
+	now := time.Now()
+	expiryTime := now.Add(expirationTime)
+
+	tokenID, err := GenerateRandomToken(16)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	claims.RegisteredClaims = jwt.RegisteredClaims{
+		ID:        tokenID,
+		ExpiresAt: jwt.NewNumericDate(expiryTime),
+		IssuedAt:  jwt.NewNumericDate(now),
+		NotBefore: jwt.NewNumericDate(now),
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	kid, method, key, err := keys.SigningKey()
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	token := jwt.NewWithClaims(method, &claims)
+	if kid != "" {
+		token.Header["kid"] = kid
+	}
 
-	signedToken, err := token.SignedString([]byte(secretKey))
+	signedToken, err := token.SignedString(key)
 	if err != nil {
 		return "", time.Time{}, err
 	}
@@ -38,11 +86,13 @@ func GenerateToken(userID uint, email string, expirationTime time.Duration, secr
 }
 
 // Validate a JWT token.
-// The function takes a token string and a secret key as arguments and returns the claims and an error.
-func ValidateToken(tokenString string, secretKey string) (*Claims, error) {
+// The function takes a token string and the keys to verify with as arguments and returns the claims and an error.
+// Only the algorithms of the key set are accepted, the key is picked by the kid header of the token.
+func ValidateToken(tokenString string, keys KeySet) (*Claims, error) {
 	token, err := jwt.ParseWithClaims(tokenString, &Claims{}, func(token *jwt.Token) (interface{}, error) {
-		return []byte(secretKey), nil
-	})
+		kid, _ := token.Header["kid"].(string)
+		return keys.VerificationKey(kid)
+	}, jwt.WithValidMethods(keys.Algorithms()))
 	if err != nil {
 		return nil, err
 	}
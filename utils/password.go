@@ -3,8 +3,6 @@ package utils
 import (
 	"crypto/rand"
 	"math/big"
-
-	"golang.org/x/crypto/bcrypt"
 )
 
 const charset = "abcdefghijklmnopqrstuvwxyz" + "ABCDEFGHIJKLMNOPQRSTUVWXYZ" + "0123456789"
@@ -21,23 +19,3 @@ func GenerateRandomPassword(length int) (string, error) {
 	}
 	return string(password), nil
 }
-
-func HashPassword(password string) (string, error) {
-	// Hash the password
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
-	if err != nil {
-		return "", err
-	}
-
-	return string(hashedPassword), nil
-}
-
-func ComparePasswords(hashedPassword, password string) (bool, error) {
-	// Compare the hashed password with the password
-	err := bcrypt.CompareHashAndPassword([]byte(hashedPassword), []byte(password))
-	if err != nil {
-		return false, err
-	}
-
-	return true, nil
-}